@@ -0,0 +1,327 @@
+package csvdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AggSpec is an aggregate computed by Aggregate().
+// Func is one of CAggCount, CAggSum, CAggMin, CAggMax, CAggAvg, CAggFirst, CAggLast.
+// Column "" or "*" counts every row with CAggCount.
+// As is the result column name, func(column) by default.
+type AggSpec struct {
+	Func   string
+	Column string
+	As     string
+}
+
+// aggDef is an aggregate function over the column colIdx.
+// colIdx is -1 for count(*)
+type aggDef struct {
+	fn        string
+	colIdx    int
+	colType   string
+	nullValue string
+}
+
+type aggState struct {
+	cnt  int64
+	sumi int64
+	sumf float64
+	val  string
+}
+
+type aggGroup struct {
+	keys   []string
+	states []*aggState
+}
+
+func newAggDef(fn string, colIdx int, colType, nullValue string) (*aggDef, error) {
+	fn = strings.ToLower(fn)
+	switch fn {
+	case CAggCount, CAggMin, CAggMax, CAggFirst, CAggLast:
+	case CAggSum, CAggAvg:
+		switch colType {
+		case cColTypeUntyped, CColTypeInt64, CColTypeFloat64:
+		default:
+			return nil, errors.Errorf("%s of type %s column is not supported", fn, colType)
+		}
+	default:
+		return nil, errors.Errorf("unknown aggregate function %s", fn)
+	}
+	if colIdx < 0 && fn != CAggCount {
+		return nil, errors.Errorf("%s needs a column", fn)
+	}
+	d := new(aggDef)
+	d.fn = fn
+	d.colIdx = colIdx
+	d.colType = colType
+	d.nullValue = nullValue
+	return d, nil
+}
+
+// resultType() returns the column type of the aggregated value
+func (d *aggDef) resultType() string {
+	switch d.fn {
+	case CAggCount:
+		return CColTypeInt64
+	case CAggAvg:
+		return CColTypeFloat64
+	case CAggSum:
+		if d.colType == CColTypeInt64 {
+			return CColTypeInt64
+		}
+		return CColTypeFloat64
+	}
+	return d.colType
+}
+
+// update() adds a row to st. NULLs are skipped except by count(*)
+func (d *aggDef) update(st *aggState, row []string) error {
+	if d.colIdx < 0 {
+		st.cnt++
+		return nil
+	}
+	v := row[d.colIdx]
+	if isNullOutput(d.nullValue, d.colType, v) {
+		return nil
+	}
+	switch d.fn {
+	case CAggSum, CAggAvg:
+		if d.colType == CColTypeInt64 {
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			st.sumi += i
+			st.sumf += float64(i)
+		} else {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			st.sumf += f
+		}
+	case CAggMin:
+		if st.cnt == 0 || compareTyped(d.colType, v, st.val) < 0 {
+			st.val = v
+		}
+	case CAggMax:
+		if st.cnt == 0 || compareTyped(d.colType, v, st.val) > 0 {
+			st.val = v
+		}
+	case CAggFirst:
+		if st.cnt == 0 {
+			st.val = v
+		}
+	case CAggLast:
+		st.val = v
+	}
+	st.cnt++
+	return nil
+}
+
+// merge() adds the state o of rows following those of st to st
+func (d *aggDef) merge(st, o *aggState) {
+	if o.cnt == 0 {
+		return
+	}
+	switch d.fn {
+	case CAggSum, CAggAvg:
+		st.sumi += o.sumi
+		st.sumf += o.sumf
+	case CAggMin:
+		if st.cnt == 0 || compareTyped(d.colType, o.val, st.val) < 0 {
+			st.val = o.val
+		}
+	case CAggMax:
+		if st.cnt == 0 || compareTyped(d.colType, o.val, st.val) > 0 {
+			st.val = o.val
+		}
+	case CAggFirst:
+		if st.cnt == 0 {
+			st.val = o.val
+		}
+	case CAggLast:
+		st.val = o.val
+	}
+	st.cnt += o.cnt
+}
+
+// result() returns the aggregated value. It is NULL when no value was found
+func (d *aggDef) result(st *aggState) string {
+	switch d.fn {
+	case CAggCount:
+		return strconv.FormatInt(st.cnt, 10)
+	}
+	if st.cnt == 0 {
+		return d.nullValue
+	}
+	switch d.fn {
+	case CAggSum:
+		if d.colType == CColTypeInt64 {
+			return strconv.FormatInt(st.sumi, 10)
+		}
+		return asString(st.sumf)
+	case CAggAvg:
+		return asString(st.sumf / float64(st.cnt))
+	}
+	return st.val
+}
+
+// aggregateRows() aggregates rows of it grouped by the columns groupIdxs.
+// Groups are returned in the order they first appeared.
+// Without groupIdxs, 1 group is always returned.
+func aggregateRows(it rowIterator, groupIdxs []int, defs []*aggDef) ([]*aggGroup, error) {
+	groups := make([]*aggGroup, 0)
+	groupMap := make(map[string]*aggGroup)
+	for it.next() {
+		row := it.current()
+		keys := make([]string, len(groupIdxs))
+		for i, idx := range groupIdxs {
+			keys[i] = row[idx]
+		}
+		k := groupKey(keys)
+		ag, ok := groupMap[k]
+		if !ok {
+			ag = newAggGroup(keys, len(defs))
+			groupMap[k] = ag
+			groups = append(groups, ag)
+		}
+		for i, d := range defs {
+			if err := d.update(ag.states[i], row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := it.lastErr(); err != nil {
+		return nil, err
+	}
+	if len(groupIdxs) == 0 && len(groups) == 0 {
+		groups = append(groups, newAggGroup([]string{}, len(defs)))
+	}
+	return groups, nil
+}
+
+// aggregation is aggs grouped by groupBy columns and the result columns
+type aggregation struct {
+	groupIdxs []int
+	defs      []*aggDef
+	resCols   []string
+	resTypes  []string
+	nullValue string
+}
+
+// aggregate() computes aggs grouped by groupBy over the rows of iter.
+// The result columns are groupBy followed by aggs.
+// nullValue is the NULL string of the rows.
+func aggregate(iter rowIterator, columns, columnTypes []string,
+	colMap map[string]int, groupBy []string, aggs []AggSpec,
+	nullValue string) (*CsvRows, error) {
+	defer iter.close()
+	a, err := newAggregation(columns, columnTypes, colMap, groupBy, aggs, nullValue)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := aggregateRows(iter, a.groupIdxs, a.defs)
+	if err != nil {
+		return nil, err
+	}
+	return a.result(groups)
+}
+
+func newAggregation(columns, columnTypes []string,
+	colMap map[string]int, groupBy []string, aggs []AggSpec,
+	nullValue string) (*aggregation, error) {
+	groupIdxs := make([]int, len(groupBy))
+	resCols := make([]string, 0, len(groupBy)+len(aggs))
+	resTypes := make([]string, 0, len(groupBy)+len(aggs))
+	for i, col := range groupBy {
+		idx, ok := colMap[col]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("column %s does not exist", col))
+		}
+		groupIdxs[i] = idx
+		resCols = append(resCols, col)
+		resTypes = append(resTypes, columnTypes[idx])
+	}
+
+	defs := make([]*aggDef, len(aggs))
+	for i, a := range aggs {
+		idx := -1
+		colType := cColTypeUntyped
+		column := a.Column
+		if column == "" {
+			column = "*"
+		}
+		if column != "*" {
+			ok := false
+			idx, ok = colMap[column]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("column %s does not exist", column))
+			}
+			colType = columnTypes[idx]
+		}
+		d, err := newAggDef(a.Func, idx, colType, nullValue)
+		if err != nil {
+			return nil, err
+		}
+		defs[i] = d
+		label := a.As
+		if label == "" {
+			label = fmt.Sprintf("%s(%s)", d.fn, column)
+		}
+		resCols = append(resCols, label)
+		resTypes = append(resTypes, d.resultType())
+	}
+
+	a := new(aggregation)
+	a.groupIdxs = groupIdxs
+	a.defs = defs
+	a.resCols = resCols
+	a.resTypes = resTypes
+	a.nullValue = nullValue
+	return a, nil
+}
+
+// result() returns the rows of the aggregated groups
+func (a *aggregation) result(groups []*aggGroup) (*CsvRows, error) {
+	rows := make([][]string, len(groups))
+	for i, ag := range groups {
+		row := make([]string, 0, len(a.resCols))
+		row = append(row, ag.keys...)
+		for j, d := range a.defs {
+			row = append(row, d.result(ag.states[j]))
+		}
+		rows[i] = row
+	}
+	r, err := newCsvRowsFromIter(newBufferIter(rows), a.resCols, a.resTypes, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.nullValue = a.nullValue
+	return r, nil
+}
+
+func newAggGroup(keys []string, nDefs int) *aggGroup {
+	ag := new(aggGroup)
+	ag.keys = keys
+	ag.states = make([]*aggState, nDefs)
+	for i := range ag.states {
+		ag.states[i] = new(aggState)
+	}
+	return ag
+}
+
+func groupKey(keys []string) string {
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Itoa(len(k)))
+		b.WriteString(":")
+		b.WriteString(k)
+	}
+	return b.String()
+}
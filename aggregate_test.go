@@ -0,0 +1,199 @@
+package csvdb
+
+import (
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	rootDir, err := ensureTestDir("TestAggregate")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateTypedGroup("agg",
+		[]string{"shop", "item", "qty", "price"},
+		[]string{CColTypeString, CColTypeString, CColTypeInt64, CColTypeFloat64},
+		false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	data := map[string][][]interface{}{
+		"day1": {
+			{"a", "apple", 1, 1.0},
+			{"b", "apple", 2, 1.5},
+			{"a", "pear", 3, 2.0},
+		},
+		"day2": {
+			{"a", "apple", 4, 1.5},
+			{"b", "pear", 5, 2.5},
+		},
+	}
+	for tableName, rows := range data {
+		tb, err := g.CreateTable(tableName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for _, row := range rows {
+			if err := tb.InsertRow(nil, row...); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	r, err := g.Aggregate(nil, []string{"shop"},
+		AggSpec{Func: CAggCount},
+		AggSpec{Func: CAggSum, Column: "qty", As: "total"},
+		AggSpec{Func: CAggAvg, Column: "price"},
+		AggSpec{Func: CAggMax, Column: "item"},
+		AggSpec{Func: CAggFirst, Column: "qty"},
+		AggSpec{Func: CAggLast, Column: "qty"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("label", r.Columns()[2], "total"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("default label", r.Columns()[3], "avg(price)"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := r.OrderBy([]string{"shop"}, CorderByAsc); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	expected := [][]interface{}{
+		{"a", int64(3), int64(8), 1.5, "pear", int64(1), int64(4)},
+		{"b", int64(2), int64(7), 2.0, "pear", int64(2), int64(5)},
+	}
+	i := 0
+	for r.Next() {
+		var shop, maxItem string
+		var cnt, total, first, last int64
+		var avg float64
+		if err := r.Scan(&shop, &cnt, &total, &avg, &maxItem, &first, &last); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		got := []interface{}{shop, cnt, total, avg, maxItem, first, last}
+		for j, v := range got {
+			if err := getGotExpErr(shop, v, expected[i][j]); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		i++
+	}
+	if err := getGotExpErr("groups", i, 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := g.GetTable("day1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = tb.Aggregate(Eq("item", "apple"), nil,
+		AggSpec{Func: CAggSum, Column: "qty"},
+		AggSpec{Func: CAggMin, Column: "price"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var qty int
+	var minPrice float64
+	if !r.Next() {
+		t.Errorf("no aggregated row")
+		return
+	}
+	if err := r.Scan(&qty, &minPrice); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("sum qty", qty, 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("min price", minPrice, 1.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if _, err := tb.Aggregate(nil, nil, AggSpec{Func: CAggSum, Column: "item"}); err == nil {
+		t.Errorf("sum of a string column must fail")
+		return
+	}
+	if _, err := tb.Aggregate(nil, []string{"nothing"}, AggSpec{Func: CAggCount}); err == nil {
+		t.Errorf("unknown group by column must fail")
+		return
+	}
+
+	// empty strings are not NULLs in groups without a NULL string
+	tb, err = g.CreateTable("day3")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{"c", "", 6, nil}, {"c", "kiwi", 7, 3.0}} {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = tb.Aggregate(nil, nil,
+		AggSpec{Func: CAggCount, Column: "item"},
+		AggSpec{Func: CAggMin, Column: "item"},
+		AggSpec{Func: CAggCount, Column: "price"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if !r.Next() {
+		t.Errorf("no aggregated row")
+		return
+	}
+	var items, prices int
+	minItem := "none"
+	if err := r.Scan(&items, &minItem, &prices); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.Close()
+	if err := getGotExpErr("count of empty strings", items, 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("min of empty strings", minItem, ""); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count of NULL floats", prices, 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
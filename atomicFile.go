@@ -0,0 +1,68 @@
+package csvdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// newTempFileFor() creates a temporary file in the directory of path
+// so that it can be renamed over path
+func newTempFileFor(path string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+// commitTempFile() syncs and closes the temporary file f and renames it over path.
+// On errors the temporary file is removed and path is left as it was.
+func commitTempFile(f *os.File, path string, perm os.FileMode) error {
+	tmpPath := f.Name()
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.WithStack(err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir() makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeFileAtomic() replaces path with what write() writes
+// so that readers see either the old or the new file
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	f, err := newTempFileFor(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return commitTempFile(f, path, perm)
+}
@@ -0,0 +1,164 @@
+package csvdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestAtomicRewrite(t *testing.T) {
+	rootDir, err := ensureTestDir("TestAtomicRewrite")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	checkNoTempFiles := func(title string) error {
+		for _, dir := range []string{rootDir, filepath.Join(rootDir, "test_atomic")} {
+			tmpFiles, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+			if err != nil {
+				return err
+			}
+			if len(tmpFiles) > 0 {
+				return errors.New(fmt.Sprintf("%s: temp files are left %v", title, tmpFiles))
+			}
+		}
+		return nil
+	}
+
+	for _, useGzip := range []bool{false, true} {
+		if err := db.DropAll(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		tb, err := db.CreateTable("test_atomic", []string{"id", "name"}, useGzip, 10)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for i := 1; i <= 3; i++ {
+			if err := tb.InsertRow(nil, i, fmt.Sprintf("name%d", i)); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		// an aborted rewrite leaves the table as it was
+		writer, err := newCsvWriter(tb.path, CWriteModeWrite)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := writer.write([]string{"9", "name9"}); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		writer.close()
+		if err := getGotExpErr("count after abort", tb.Count(nil), 3); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := checkNoTempFiles("abort"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		if err := tb.Update(func(v []string) bool { return v[0] == "2" },
+			map[string]interface{}{"name": "updated"}); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Delete(func(v []string) bool { return v[0] == "1" }); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		var name string
+		if err := tb.Select1Row(func(v []string) bool { return v[0] == "2" },
+			[]string{"name"}, &name); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("updated name", name, "updated"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("count after rewrite", tb.Count(nil), 2); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := checkNoTempFiles("rewrite"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	g, err := db.GetGroup("test_atomic")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := g.CreateTable("test_atomic2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	// a failed write of the ini file leaves it as it was
+	org, err := os.ReadFile(g.iniFile)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := writeFileAtomic(g.iniFile, 0640, func(w io.Writer) error {
+		w.Write([]byte("broken"))
+		return errors.New("disk full")
+	}); err == nil {
+		t.Errorf("writeFileAtomic() must return the error of write")
+		return
+	}
+	got, err := os.ReadFile(g.iniFile)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("ini file", string(got), string(org)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := checkNoTempFiles("ini"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g2, err := db2.GetGroup("test_atomic")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("tables", len(g2.tableDefs), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
@@ -0,0 +1,116 @@
+// csvdbimport imports CSV/TSV files into a table of a CsvDB.
+//
+//	csvdbimport -base DIR -table GROUP.TABLE [options] FILE...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	csvdb "github.com/toku463ne/goCsvDb"
+)
+
+func parseRune(name, s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		return 0, fmt.Errorf("-%s must be 1 character: %s", name, s)
+	}
+	return r, nil
+}
+
+func parseColumnMap(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	columnMap := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("-map must be SRC=COL[,SRC=COL...]: %s", s)
+		}
+		columnMap[kv[0]] = kv[1]
+	}
+	return columnMap, nil
+}
+
+func run() error {
+	baseDir := flag.String("base", "", "base directory of the CsvDB")
+	tableName := flag.String("table", "", "GROUP.TABLE, or TABLE of the group of the same name")
+	delimiter := flag.String("delim", "", `field delimiter. "tab" or \t for TSV`)
+	comment := flag.String("comment", "", "comment character")
+	lazyQuotes := flag.Bool("lazy", false, "allow quotes in unquoted fields")
+	trim := flag.Bool("trim", false, "trim leading spaces of fields")
+	noHeader := flag.Bool("noheader", false, "the files have no header row")
+	columnMap := flag.String("map", "", "SRC=COL[,SRC=COL...] mapping of header names to columns")
+	useGzip := flag.Bool("gzip", false, "decompress the files")
+	rejectPath := flag.String("reject", "", "file to write malformed rows to")
+	flag.Parse()
+
+	if *baseDir == "" || *tableName == "" || flag.NArg() == 0 {
+		flag.Usage()
+		return fmt.Errorf("-base, -table and files are required")
+	}
+	if *rejectPath != "" && flag.NArg() > 1 {
+		return fmt.Errorf("-reject can be used with 1 file")
+	}
+
+	opts := new(csvdb.ImportOptions)
+	var err error
+	if opts.Delimiter, err = parseRune("delim", *delimiter); err != nil {
+		return err
+	}
+	if opts.Comment, err = parseRune("comment", *comment); err != nil {
+		return err
+	}
+	if opts.ColumnMap, err = parseColumnMap(*columnMap); err != nil {
+		return err
+	}
+	opts.LazyQuotes = *lazyQuotes
+	opts.TrimLeadingSpace = *trim
+	opts.NoHeader = *noHeader
+	opts.Gzip = *useGzip
+	opts.RejectPath = *rejectPath
+
+	db, err := csvdb.NewCsvDB(*baseDir)
+	if err != nil {
+		return err
+	}
+	groupName, name := *tableName, *tableName
+	if pos := strings.Index(*tableName, "."); pos >= 0 {
+		groupName, name = (*tableName)[:pos], (*tableName)[pos+1:]
+	}
+	g, err := db.GetGroup(groupName)
+	if err != nil {
+		return err
+	}
+	t, err := g.GetTable(name)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range flag.Args() {
+		res, err := t.Import(path, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: read %d imported %d rejected %d\n",
+			path, res.Read, res.Imported, res.Rejected)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
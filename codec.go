@@ -0,0 +1,275 @@
+package csvdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+/*
+Codec is a compression format of table files.
+
+Codecs are chosen per group by CsvTableGroup.SetCodec() and
+detected from Magic when files are read.
+none, gzip (levels 1-9), zstd (levels 1-22), snappy (no level)
+and lz4 (levels 1-9) are built in, and bzip2 files can be read.
+Other codecs can be added by RegisterCodec(), for example
+
+	csvdb.RegisterCodec(&csvdb.Codec{Name: "xz", Ext: ".xz",
+		Magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { ... },
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) { ... }})
+*/
+type Codec struct {
+	// name in the ini file
+	Name string
+	// extension of table files such as ".gz"
+	Ext string
+	// first bytes of compressed files
+	Magic []byte
+	// NewReader is nil if the codec is not implemented
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	// NewWriter is nil if the codec can only be read.
+	// level 0 is the default level of the codec
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+
+	// match tells if the first magicLen bytes are of the codec
+	// when they are not fixed
+	match    func(head []byte) bool
+	magicLen int
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]*Codec{}
+)
+
+func init() {
+	for _, c := range []*Codec{
+		{Name: CCodecNone},
+		{Name: CCodecGzip, Ext: ".gz", Magic: []byte{0x1f, 0x8b},
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				zr, err := gzip.NewReader(r)
+				return zr, errors.WithStack(err)
+			},
+			NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+				if level == 0 {
+					level = gzip.DefaultCompression
+				}
+				zw, err := gzip.NewWriterLevel(w, level)
+				return zw, errors.WithStack(err)
+			}},
+		// the stream header of any level and the block magic.
+		// "BZh" only would match CSV text
+		{Name: CCodecBzip2, Ext: ".bz2", match: isBzip2Head, magicLen: 10,
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				return io.NopCloser(bzip2.NewReader(r)), nil
+			}},
+		{Name: CCodecZstd, Ext: ".zst", Magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				return zr.IOReadCloser(), nil
+			},
+			NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+				if level == 0 {
+					level = 3
+				}
+				if level < 1 || level > 22 {
+					return nil, errors.Errorf("invalid zstd level %d", level)
+				}
+				zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1),
+					zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+				return zw, errors.WithStack(err)
+			}},
+		// the snappy framing format. Appends start new streams
+		{Name: CCodecSnappy, Ext: ".sz", Magic: []byte("\xff\x06\x00\x00sNaPpY"),
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				return io.NopCloser(s2.NewReader(r)), nil
+			},
+			NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+				if level != 0 {
+					return nil, errors.New("codec snappy has no level")
+				}
+				return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), nil
+			}},
+		{Name: CCodecLz4, Ext: ".lz4", Magic: []byte{0x04, 0x22, 0x4d, 0x18},
+			NewReader: func(r io.Reader) (io.ReadCloser, error) {
+				br := bufio.NewReader(r)
+				return &lz4Reader{r: br, zr: lz4.NewReader(br)}, nil
+			},
+			NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+				zw := lz4.NewWriter(w)
+				if level == 0 {
+					return zw, nil
+				}
+				if level < 1 || level > 9 {
+					return nil, errors.Errorf("invalid lz4 level %d", level)
+				}
+				// Level1 to Level9 are 1<<8 to 1<<16
+				if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (7 + level)))); err != nil {
+					return nil, errors.WithStack(err)
+				}
+				return zw, nil
+			}},
+	} {
+		codecs[c.Name] = c
+	}
+}
+
+// isBzip2Head() tells if head is "BZh", a level of 1 to 9 and "1AY&SY"
+func isBzip2Head(head []byte) bool {
+	return len(head) == 10 && bytes.HasPrefix(head, []byte("BZh")) &&
+		head[3] >= '1' && head[3] <= '9' && bytes.Equal(head[4:], []byte("1AY&SY"))
+}
+
+// lz4Reader reads concatenated lz4 frames as appends write 1 frame each
+type lz4Reader struct {
+	r  *bufio.Reader
+	zr *lz4.Reader
+}
+
+func (l *lz4Reader) Read(p []byte) (int, error) {
+	for {
+		n, err := l.zr.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if _, err := l.r.Peek(1); err != nil {
+			return n, io.EOF
+		}
+		l.zr.Reset(l.r)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (l *lz4Reader) Close() error {
+	return nil
+}
+
+// RegisterCodec() adds or replaces a codec.
+// Ext and Magic of a known codec are kept if they are empty.
+func RegisterCodec(codec *Codec) error {
+	if codec == nil || codec.Name == "" || codec.NewReader == nil {
+		return errors.New("a codec needs a name and NewReader")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	c := *codec
+	if known, ok := codecs[c.Name]; ok {
+		if c.Ext == "" {
+			c.Ext = known.Ext
+		}
+		if c.Magic == nil {
+			c.Magic = known.Magic
+			c.match = known.match
+			c.magicLen = known.magicLen
+		}
+	}
+	if c.Ext == "" || (len(c.Magic) == 0 && c.match == nil) {
+		return errors.Errorf("codec %s needs an extension and magic bytes", c.Name)
+	}
+	codecs[c.Name] = &c
+	return nil
+}
+
+// getCodec() returns the codec of name which can write
+func getCodec(name string) (*Codec, error) {
+	if name == "" {
+		name = CCodecNone
+	}
+	codecsMu.RLock()
+	c, ok := codecs[name]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unknown codec %s", name)
+	}
+	if c.Name != CCodecNone && c.NewWriter == nil {
+		return nil, errors.Errorf("codec %s cannot write. Register an implementation by RegisterCodec()", name)
+	}
+	return c, nil
+}
+
+// codecByExt() returns the codec of the extension of path or none
+func codecByExt(path string) *Codec {
+	ext := filepath.Ext(path)
+	if ext == ".gzip" {
+		ext = ".gz"
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if ext != "" {
+		for _, c := range codecs {
+			if c.Ext == ext {
+				return c
+			}
+		}
+	}
+	return codecs[CCodecNone]
+}
+
+// detectCodec() returns the codec of the magic bytes at the head of br or none
+func detectCodec(br *bufio.Reader) (*Codec, error) {
+	codecsMu.RLock()
+	list := make([]*Codec, 0, len(codecs))
+	for _, c := range codecs {
+		if len(c.Magic) > 0 || c.match != nil {
+			list = append(list, c)
+		}
+	}
+	none := codecs[CCodecNone]
+	codecsMu.RUnlock()
+	magicLen := func(c *Codec) int {
+		if c.match != nil {
+			return c.magicLen
+		}
+		return len(c.Magic)
+	}
+	// longer magic bytes first
+	sort.Slice(list, func(i, j int) bool { return magicLen(list[i]) > magicLen(list[j]) })
+
+	for _, c := range list {
+		head, err := br.Peek(magicLen(c))
+		if err != nil && err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+		if (c.match != nil && c.match(head)) || (c.match == nil && bytes.Equal(head, c.Magic)) {
+			if c.NewReader == nil {
+				return nil, errors.Errorf("codec %s is not registered", c.Name)
+			}
+			return c, nil
+		}
+	}
+	return none, nil
+}
+
+// newDecompressor() returns a reader of r decompressed by the detected codec
+func newDecompressor(r io.Reader) (io.Reader, io.Closer, *Codec, error) {
+	br := bufio.NewReader(r)
+	c, err := detectCodec(br)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if c.Name == CCodecNone {
+		return br, nil, c, nil
+	}
+	zr, err := c.NewReader(br)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return zr, zr, c, nil
+}
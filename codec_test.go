@@ -0,0 +1,375 @@
+package csvdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// xorWriter writes magic bytes and xor-ed data to test RegisterCodec()
+type xorWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (x *xorWriter) Write(p []byte) (int, error) {
+	if !x.started {
+		x.started = true
+		if _, err := x.w.Write([]byte("XOR1")); err != nil {
+			return 0, err
+		}
+	}
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ 0x5a
+	}
+	return x.w.Write(b)
+}
+
+func (x *xorWriter) Close() error {
+	return nil
+}
+
+type xorReader struct {
+	r io.Reader
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= 0x5a
+	}
+	return n, err
+}
+
+func TestCodec(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCodec")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateGroup("zipped", []string{"id", "name"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, c := range []struct {
+		codec string
+		level int
+	}{
+		{"unknown", 0},
+		{CCodecBzip2, 0},
+		{CCodecGzip, 42},
+		{CCodecZstd, 23},
+		{CCodecSnappy, 1},
+		{CCodecLz4, 10},
+		{CCodecNone, 1},
+	} {
+		if err := g.SetCodec(c.codec, c.level); err == nil {
+			t.Errorf("SetCodec(%s, %d) must fail", c.codec, c.level)
+			return
+		}
+	}
+	if err := g.SetCodec(CCodecGzip, gzip.BestCompression); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err := g.CreateTable("zipped_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("path", filepath.Base(tb.path), "zipped_1.csv.gz"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	// 2 appends make 2 gzip streams
+	for i := 1; i <= 2; i++ {
+		if err := tb.InsertRow(nil, i, "name"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := getGotExpErr("count", tb.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// groups saved before codecs have only useGzip
+	cfg, err := os.ReadFile(g.iniFile)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(cfg), "\n") {
+		if !strings.HasPrefix(line, "codec") {
+			lines = append(lines, line)
+		}
+	}
+	if err := os.WriteFile(g.iniFile, []byte(strings.Join(lines, "\n")), 0640); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g2, err := db2.GetGroup("zipped")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("legacy codec", g2.codec, CCodecGzip); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb2, err := g2.GetTable("zipped_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("legacy count", tb2.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// built in codecs are detected from magic bytes
+	for _, c := range []struct {
+		codec string
+		level int
+		ext   string
+	}{
+		{CCodecZstd, 0, ".zst"},
+		{CCodecZstd, 19, ".zst"},
+		{CCodecSnappy, 0, ".sz"},
+		{CCodecLz4, 0, ".lz4"},
+		{CCodecLz4, 9, ".lz4"},
+	} {
+		title := fmt.Sprintf("%s level %d", c.codec, c.level)
+		cg, err := db.CreateGroup("packed", []string{"id", "name"}, false, 10)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := cg.SetCodec(c.codec, c.level); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		ct, err := cg.CreateTable("packed_1")
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" path", filepath.Base(ct.path), "packed_1.csv"+c.ext); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		// 2 appends make 2 streams
+		for i := 1; i <= 2; i++ {
+			if err := ct.InsertRow(nil, i, strings.Repeat("name", i*100)); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+			if err := ct.Flush(); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := ct.Update(Eq("id", "2"), map[string]interface{}{"name": "b"}); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		f, err := os.Open(ct.path)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		codec, err := detectCodec(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" detected", codec.Name, c.codec); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		db2, err := NewCsvDB(rootDir)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		cg2, err := db2.GetGroup("packed")
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		ct2, err := cg2.GetTable("packed_1")
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		names := []string{}
+		r, err := ct2.SelectRows(nil, []string{"name"})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for r.Next() {
+			var name string
+			if err := r.Scan(&name); err != nil {
+				r.Close()
+				t.Errorf("%v", err)
+				return
+			}
+			names = append(names, name)
+		}
+		r.Close()
+		if err := getGotExpErr(title+" names", strings.Join(names, ","), strings.Repeat("name", 100)+",b"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := cg.Drop(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	// a registered codec
+	if err := RegisterCodec(&Codec{Name: "xor"}); err == nil {
+		t.Errorf("a codec without NewReader must fail")
+		return
+	}
+	if err := RegisterCodec(&Codec{Name: "xor", Ext: ".xor", Magic: []byte("XOR1"),
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(r, magic); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return io.NopCloser(&xorReader{r}), nil
+		},
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return &xorWriter{w: w}, nil
+		}}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	xg, err := db.CreateGroup("xored", []string{"id", "name"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	// the group cannot be loaded without the codec
+	defer func() {
+		xg.Drop()
+		codecsMu.Lock()
+		delete(codecs, "xor")
+		codecsMu.Unlock()
+	}()
+	if err := xg.SetCodec("xor", 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	xt, err := xg.CreateTable("xored_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := xt.InsertRow(nil, 1, "a"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := xt.InsertRow(nil, 2, "b"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := xt.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := xt.Update(func(v []string) bool { return v[0] == "2" },
+		map[string]interface{}{"name": "c"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var name string
+	if err := xt.Select1Row(func(v []string) bool { return v[0] == "2" },
+		[]string{"name"}, &name); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("xor name", name, "c"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	raw, err := os.ReadFile(xt.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("xor magic", string(raw[:4]), "XOR1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("1,a\n"))
+	zw.Close()
+
+	for _, c := range []struct {
+		data []byte
+		exp  string
+	}{
+		{[]byte("1,a\n"), CCodecNone},
+		{[]byte(""), CCodecNone},
+		{[]byte("BZh,a\n"), CCodecNone},
+		{gz.Bytes(), CCodecGzip},
+		{[]byte("BZh91AY&SY\x00"), CCodecBzip2},
+		{[]byte("BZh11AY&SY\x00"), CCodecBzip2},
+		{[]byte("BZh01AY&SY\x00"), CCodecNone},
+		{[]byte("BZh9,1AY&SY\n"), CCodecNone},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0}, CCodecZstd},
+		{[]byte("\xff\x06\x00\x00sNaPpY"), CCodecSnappy},
+		{[]byte("\xff\x06\x00\x00S2sTwO"), CCodecNone},
+		{[]byte{0x04, 0x22, 0x4d, 0x18, 0x64}, CCodecLz4},
+	} {
+		codec, err := detectCodec(bufio.NewReader(bytes.NewReader(c.data)))
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("codec", codec.Name, c.exp); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+}
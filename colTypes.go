@@ -0,0 +1,277 @@
+package csvdb
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func isValidColType(colType string) bool {
+	switch colType {
+	case cColTypeUntyped, CColTypeString, CColTypeInt64,
+		CColTypeFloat64, CColTypeBool, CColTypeTimestamp:
+		return true
+	}
+	return false
+}
+
+// normalizeColTypes() returns column types matching columns.
+// nil columnTypes means an untyped group
+func normalizeColTypes(columns, columnTypes []string) ([]string, error) {
+	if columnTypes == nil {
+		return make([]string, len(columns)), nil
+	}
+	if len(columns) != len(columnTypes) {
+		return nil, errors.Errorf("length of columns=%d does not match that of columnTypes=%d",
+			len(columns), len(columnTypes))
+	}
+	for i, colType := range columnTypes {
+		if !isValidColType(colType) {
+			return nil, errors.Errorf("column %s has an unknown type %s", columns[i], colType)
+		}
+	}
+	return columnTypes, nil
+}
+
+// sameColTypes() tells if column types a and b are the same.
+// Untyped columns are the same as string columns
+func sameColTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	stringType := func(colType string) string {
+		if colType == cColTypeUntyped {
+			return CColTypeString
+		}
+		return colType
+	}
+	for i := range a {
+		if stringType(a[i]) != stringType(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTypedGroup(columnTypes []string) bool {
+	for _, colType := range columnTypes {
+		if colType != cColTypeUntyped {
+			return true
+		}
+	}
+	return false
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTimestamp() parses RFC3339, "2006-01-02 15:04:05", "2006-01-02"
+// or epoch seconds. Times without zone are UTC
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			return tm, nil
+		}
+	}
+	epoch, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("%s is not a timestamp", s)
+	}
+	return time.Unix(epoch, 0), nil
+}
+
+// isNullValue() tells if a stored value is NULL.
+// null is the NULL string of the group. Empty values of typed columns
+// other than string are NULL too as they cannot be parsed.
+func isNullValue(null, colType, v string) bool {
+	if v == null {
+		return true
+	}
+	return v == "" && colType != CColTypeString && colType != cColTypeUntyped
+}
+
+// isNullOutput() tells if a stored value is read out as NULL.
+// Empty values of string columns of groups without a NULL string
+// are read out as empty strings.
+func isNullOutput(null, colType, v string) bool {
+	if null == "" && (colType == CColTypeString || colType == cColTypeUntyped) {
+		return false
+	}
+	return isNullValue(null, colType, v)
+}
+
+// typedString() converts v to the string stored in a column of colType
+// and returns an error if v does not fit the type.
+// An empty value is accepted for every type.
+func typedString(colType string, v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if tm, ok := v.(time.Time); ok {
+		if colType != CColTypeTimestamp && colType != cColTypeUntyped {
+			return "", errors.Errorf("time value for %s column", colType)
+		}
+		return tm.Format(time.RFC3339Nano), nil
+	}
+	s := asString(v)
+	if s == "" {
+		return s, nil
+	}
+	switch colType {
+	case CColTypeInt64:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return "", errors.Errorf("%s is not %s", s, colType)
+		}
+	case CColTypeFloat64:
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", errors.Errorf("%s is not %s", s, colType)
+		}
+	case CColTypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return "", errors.Errorf("%s is not %s", s, colType)
+		}
+		s = asString(b)
+	case CColTypeTimestamp:
+		if _, err := parseTimestamp(s); err != nil {
+			return "", err
+		}
+	}
+	return s, nil
+}
+
+// parseTyped() converts a stored string to the Go value of colType.
+// Untyped and string columns are returned as string.
+func parseTyped(colType, s string) (interface{}, error) {
+	switch colType {
+	case CColTypeInt64:
+		return strconv.ParseInt(s, 10, 64)
+	case CColTypeFloat64:
+		return strconv.ParseFloat(s, 64)
+	case CColTypeBool:
+		return strconv.ParseBool(s)
+	case CColTypeTimestamp:
+		return parseTimestamp(s)
+	}
+	return s, nil
+}
+
+// canonicalValue() returns the string of v which is the same for values
+// equal by compareTyped(), such as "01" and "1.0" of numbers
+func canonicalValue(colType, v string) string {
+	switch colType {
+	case CColTypeInt64, CColTypeFloat64, cColTypeUntyped:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return strconv.FormatInt(i, 10)
+			}
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case CColTypeBool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return strconv.FormatBool(b)
+		}
+	case CColTypeTimestamp:
+		if tm, err := parseTimestamp(v); err == nil {
+			return tm.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return v
+}
+
+// compareTyped() compares 2 stored strings as values of colType.
+// Untyped columns are compared numerically when both values are numbers.
+// Values which cannot be parsed are compared as strings.
+func compareTyped(colType, a, b string) int {
+	switch colType {
+	case CColTypeInt64:
+		r1, err1 := strconv.ParseInt(a, 10, 64)
+		r2, err2 := strconv.ParseInt(b, 10, 64)
+		if err1 == nil && err2 == nil {
+			return compareInt64(r1, r2)
+		}
+	case CColTypeFloat64, cColTypeUntyped:
+		r1, err1 := strconv.ParseFloat(a, 64)
+		r2, err2 := strconv.ParseFloat(b, 64)
+		if err1 == nil && err2 == nil {
+			return compareFloat64(r1, r2)
+		}
+	case CColTypeBool:
+		r1, err1 := strconv.ParseBool(a)
+		r2, err2 := strconv.ParseBool(b)
+		if err1 == nil && err2 == nil {
+			if r1 == r2 {
+				return 0
+			} else if r2 {
+				return -1
+			}
+			return 1
+		}
+	case CColTypeTimestamp:
+		r1, err1 := parseTimestamp(a)
+		r2, err2 := parseTimestamp(b)
+		if err1 == nil && err2 == nil {
+			if r1.Before(r2) {
+				return -1
+			} else if r1.After(r2) {
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// convTyped() sets a stored string to dest.
+// *interface{} gets the Go value of colType.
+func convTyped(colType, src string, dest interface{}) error {
+	if p, ok := dest.(*interface{}); ok {
+		if colType == cColTypeUntyped || src == "" {
+			*p = src
+			return nil
+		}
+		v, err := parseTyped(colType, src)
+		if err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+	return convFromString(src, dest)
+}
+
+func setZero(dest interface{}) error {
+	dpv := reflect.ValueOf(dest)
+	if dpv.Kind() != reflect.Ptr || dpv.IsNil() {
+		return errors.New("destination is not a valid pointer")
+	}
+	dv := reflect.Indirect(dpv)
+	dv.Set(reflect.Zero(dv.Type()))
+	return nil
+}
@@ -0,0 +1,291 @@
+package csvdb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cCondEq      = "="
+	cCondNe      = "!="
+	cCondLt      = "<"
+	cCondLe      = "<="
+	cCondGt      = ">"
+	cCondGe      = ">="
+	cCondBetween = "between"
+	cCondIn      = "in"
+	cCondLike    = "like"
+	cCondIsNull  = "isnull"
+	cCondAnd     = "and"
+	cCondOr      = "or"
+	cCondNot     = "not"
+)
+
+// Cond is a condition on named columns.
+// It can be passed wherever func([]string) bool conditions are accepted.
+type Cond struct {
+	op       string
+	column   string
+	values   []interface{}
+	children []*Cond
+}
+
+func newColCond(op, column string, values ...interface{}) *Cond {
+	c := new(Cond)
+	c.op = op
+	c.column = column
+	c.values = values
+	return c
+}
+
+func newLogicalCond(op string, children ...*Cond) *Cond {
+	c := new(Cond)
+	c.op = op
+	c.children = children
+	return c
+}
+
+func Eq(column string, v interface{}) *Cond {
+	return newColCond(cCondEq, column, v)
+}
+
+func Ne(column string, v interface{}) *Cond {
+	return newColCond(cCondNe, column, v)
+}
+
+func Lt(column string, v interface{}) *Cond {
+	return newColCond(cCondLt, column, v)
+}
+
+func Le(column string, v interface{}) *Cond {
+	return newColCond(cCondLe, column, v)
+}
+
+func Gt(column string, v interface{}) *Cond {
+	return newColCond(cCondGt, column, v)
+}
+
+func Ge(column string, v interface{}) *Cond {
+	return newColCond(cCondGe, column, v)
+}
+
+// Between(column, from, to) matches from <= column <= to
+func Between(column string, from, to interface{}) *Cond {
+	return newColCond(cCondBetween, column, from, to)
+}
+
+func In(column string, vs ...interface{}) *Cond {
+	return newColCond(cCondIn, column, vs...)
+}
+
+// Like(column, pattern) matches SQL LIKE patterns with % and _
+func Like(column, pattern string) *Cond {
+	return newColCond(cCondLike, column, pattern)
+}
+
+func IsNull(column string) *Cond {
+	return newColCond(cCondIsNull, column)
+}
+
+func IsNotNull(column string) *Cond {
+	return Not(IsNull(column))
+}
+
+func And(conds ...*Cond) *Cond {
+	return newLogicalCond(cCondAnd, conds...)
+}
+
+func Or(conds ...*Cond) *Cond {
+	return newLogicalCond(cCondOr, conds...)
+}
+
+func Not(c *Cond) *Cond {
+	return newLogicalCond(cCondNot, c)
+}
+
+// toMatcher() converts a condition to a row check function.
+// condition is nil, func([]string) bool or *Cond.
+// nullValue is the NULL string of the rows.
+func toMatcher(condition interface{}, colMap map[string]int,
+	colTypes []string, nullValue string) (func([]string) bool, error) {
+	switch c := condition.(type) {
+	case nil:
+		return nil, nil
+	case func([]string) bool:
+		return c, nil
+	case *Cond:
+		if c == nil {
+			return nil, nil
+		}
+		return c.compile(colMap, colTypes, nullValue)
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported condition type %T", condition))
+}
+
+// compile() returns the row check function of c.
+// NULL matches only IS NULL like SQL. Values are NULL if they are
+// read out as NULL, so empty strings of groups without a NULL string are not.
+// Comparisons with nil are errors as they would never match
+func (c *Cond) compile(colMap map[string]int,
+	colTypes []string, nullValue string) (func([]string) bool, error) {
+	switch c.op {
+	case cCondAnd, cCondOr, cCondNot:
+		return c.compileLogical(colMap, colTypes, nullValue)
+	}
+
+	idx, ok := colMap[c.column]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("column %s does not exist", c.column))
+	}
+	colType := colTypes[idx]
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		if v == nil {
+			return nil, errors.Errorf("condition %s on %s compares with NULL. Use IsNull() or IS NULL",
+				c.op, c.column)
+		}
+		if c.op == cCondLike {
+			values[i] = asString(v)
+			continue
+		}
+		s, err := typedString(colType, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "condition on %s", c.column)
+		}
+		values[i] = s
+	}
+
+	switch c.op {
+	case cCondEq, cCondNe, cCondLt, cCondLe, cCondGt, cCondGe:
+		if len(values) != 1 {
+			return nil, errors.Errorf("%s takes 1 value", c.op)
+		}
+		v := values[0]
+		op := c.op
+		return func(row []string) bool {
+			return !isNullOutput(nullValue, colType, row[idx]) &&
+				compareOp(op, compareTyped(colType, row[idx], v))
+		}, nil
+	case cCondBetween:
+		if len(values) != 2 {
+			return nil, errors.New("between takes 2 values")
+		}
+		from := values[0]
+		to := values[1]
+		return func(row []string) bool {
+			return !isNullOutput(nullValue, colType, row[idx]) &&
+				compareTyped(colType, row[idx], from) >= 0 &&
+				compareTyped(colType, row[idx], to) <= 0
+		}, nil
+	case cCondIn:
+		return func(row []string) bool {
+			if isNullOutput(nullValue, colType, row[idx]) {
+				return false
+			}
+			for _, v := range values {
+				if compareTyped(colType, row[idx], v) == 0 {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case cCondLike:
+		if len(values) != 1 {
+			return nil, errors.New("like takes 1 pattern")
+		}
+		re, err := likeToRegexp(values[0])
+		if err != nil {
+			return nil, err
+		}
+		return func(row []string) bool {
+			return !isNullOutput(nullValue, colType, row[idx]) &&
+				re.MatchString(row[idx])
+		}, nil
+	case cCondIsNull:
+		return func(row []string) bool {
+			return isNullOutput(nullValue, colType, row[idx])
+		}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown condition %s", c.op))
+}
+
+func (c *Cond) compileLogical(colMap map[string]int,
+	colTypes []string, nullValue string) (func([]string) bool, error) {
+	funcs := make([]func([]string) bool, 0, len(c.children))
+	for _, child := range c.children {
+		if child == nil {
+			continue
+		}
+		f, err := child.compile(colMap, colTypes, nullValue)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+
+	switch c.op {
+	case cCondAnd:
+		return func(row []string) bool {
+			for _, f := range funcs {
+				if !f(row) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case cCondOr:
+		return func(row []string) bool {
+			for _, f := range funcs {
+				if f(row) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	if len(funcs) != 1 {
+		return nil, errors.New("not takes 1 condition")
+	}
+	f := funcs[0]
+	return func(row []string) bool {
+		return !f(row)
+	}, nil
+}
+
+func compareOp(op string, c int) bool {
+	switch op {
+	case cCondEq:
+		return c == 0
+	case cCondNe:
+		return c != 0
+	case cCondLt:
+		return c < 0
+	case cCondLe:
+		return c <= 0
+	case cCondGt:
+		return c > 0
+	case cCondGe:
+		return c >= 0
+	}
+	return false
+}
+
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -1,12 +1,72 @@
 package csvdb
 
 const (
-	cRModePlain      = "plain"
-	cRModeGZip       = "gzip"
 	cTblIniExt       = "tbl.ini"
 	cDefaultBuffSize = 10000
 	CWriteModeAppend = "a"
 	CWriteModeWrite  = "w"
 	CorderByAsc      = 1
 	CorderByDesc     = -1
+	CNullsDefault    = 0
+	CNullsFirst      = 1
+	CNullsLast       = 2
+	CLockNoWait      = -1
+
+	// bytes of rows OrderBy() sorts in memory before spilling to disk
+	cDefaultSortMemory = 64 << 20
+
+	// rows of blocks of zone maps built from existing files
+	cZoneBlockRows = 10000
+
+	// bytes a goroutine of a parallel scan reads at least of a split file
+	cParallelChunkBytes = 8 << 20
+
+	// write-ahead log of transactions under baseDir
+	cWalDir     = ".wal"
+	cWalExt     = "wal"
+	cWalAppend  = "append"
+	cWalReplace = "replace"
+
+	// compression codecs of table files
+	CCodecNone   = "none"
+	CCodecGzip   = "gzip"
+	CCodecBzip2  = "bzip2"
+	CCodecZstd   = "zstd"
+	CCodecSnappy = "snappy"
+	CCodecLz4    = "lz4"
+
+	// modes of writing rows of a duplicate primary key
+	CDupReject  = "reject"
+	CDupReplace = "replace"
+
+	// types of joins
+	CJoinInner = "inner"
+	CJoinLeft  = "left"
+	CJoinSemi  = "semi"
+	CJoinAnti  = "anti"
+
+	// granularities of partitions of groups
+	CPartitionHour  = "hour"
+	CPartitionDay   = "day"
+	CPartitionMonth = "month"
+
+	cColTypeUntyped   = ""
+	CColTypeString    = "string"
+	CColTypeInt64     = "int64"
+	CColTypeFloat64   = "float64"
+	CColTypeBool      = "bool"
+	CColTypeTimestamp = "timestamp"
+
+	CAggCount = "count"
+	CAggSum   = "sum"
+	CAggMin   = "min"
+	CAggMax   = "max"
+	CAggAvg   = "avg"
+	CAggFirst = "first"
+	CAggLast  = "last"
+
+	CExportJSONL      = "jsonl"
+	CExportTSV        = "tsv"
+	CExportMarkdown   = "markdown"
+	CExportFixedWidth = "fixed"
 )
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -18,6 +19,9 @@ func NewCsvDB(baseDir string) (*CsvDB, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	if err := db.recoverWal(); err != nil {
+		return nil, err
+	}
 
 	iniFiles, err := filepath.Glob(fmt.Sprintf("%s/*.%s", baseDir, cTblIniExt))
 	if err != nil {
@@ -25,6 +29,8 @@ func NewCsvDB(baseDir string) (*CsvDB, error) {
 	}
 	for _, iniFile := range iniFiles {
 		g := new(CsvTableGroup)
+		g.lockTimeout = db.lockTimeout
+		g.parallelism = db.parallelism
 		if err := g.load(iniFile); err != nil {
 			return nil, err
 		}
@@ -36,14 +42,46 @@ func NewCsvDB(baseDir string) (*CsvDB, error) {
 
 func (db *CsvDB) CreateGroup(groupName string,
 	columns []string, useGzip bool, bufferSize int) (*CsvTableGroup, error) {
-	g, err := newCsvTableGroup(groupName, db.baseDir, columns, useGzip, bufferSize)
+	return db.CreateTypedGroup(groupName, columns, nil, useGzip, bufferSize)
+}
+
+// CreateTypedGroup() creates a group whose columns have declared types.
+// columnTypes are CColTypeString, CColTypeInt64, CColTypeFloat64,
+// CColTypeBool or CColTypeTimestamp
+func (db *CsvDB) CreateTypedGroup(groupName string,
+	columns, columnTypes []string, useGzip bool, bufferSize int) (*CsvTableGroup, error) {
+	g, err := newCsvTableGroup(groupName, db.baseDir, columns, columnTypes, useGzip, bufferSize)
 	if err != nil {
 		return nil, err
 	}
+	g.lockTimeout = db.lockTimeout
+	g.parallelism = db.parallelism
 	db.Groups[groupName] = g
 	return g, nil
 }
 
+// SetSortMemory() sets the bytes of rows ORDER BY of Query() sorts in memory
+// before spilling sorted runs to the group's data directory
+func (db *CsvDB) SetSortMemory(bytes int64) {
+	db.sortMemory = bytes
+}
+
+/*
+SetLockTimeout() sets how long reads and writes wait for locks
+held by other processes on tables and ini files.
+
+timeout:
+0: waits until the lock is released (default)
+> 0: fails with ErrLocked after timeout
+CLockNoWait: fails with ErrLocked immediately
+*/
+func (db *CsvDB) SetLockTimeout(timeout time.Duration) {
+	db.lockTimeout = timeout
+	for _, g := range db.Groups {
+		g.SetLockTimeout(timeout)
+	}
+}
+
 func (db *CsvDB) GetGroup(groupName string) (*CsvTableGroup, error) {
 	g, ok := db.Groups[groupName]
 	if !ok {
@@ -53,7 +91,7 @@ func (db *CsvDB) GetGroup(groupName string) (*CsvTableGroup, error) {
 }
 
 func (db *CsvDB) createTable(groupName, tableName string,
-	columns []string, useGzip bool, bufferSize int) (*CsvTable, error) {
+	columns, columnTypes []string, useGzip bool, bufferSize int) (*CsvTable, error) {
 
 	if groupName == "" {
 		groupName = tableName
@@ -66,10 +104,12 @@ func (db *CsvDB) createTable(groupName, tableName string,
 			return nil, errors.New(fmt.Sprintf("The table %s exists", tableName))
 		}
 	} else {
-		g, err = newCsvTableGroup(groupName, db.baseDir, columns, useGzip, bufferSize)
+		g, err = newCsvTableGroup(groupName, db.baseDir, columns, columnTypes, useGzip, bufferSize)
 		if err != nil {
 			return nil, err
 		}
+		g.lockTimeout = db.lockTimeout
+		g.parallelism = db.parallelism
 	}
 
 	t, err := g.CreateTable(tableName)
@@ -83,7 +123,13 @@ func (db *CsvDB) createTable(groupName, tableName string,
 
 func (db *CsvDB) CreateTable(tableName string,
 	columns []string, useGzip bool, bufferSize int) (*CsvTable, error) {
-	return db.createTable("", tableName, columns, useGzip, bufferSize)
+	return db.createTable("", tableName, columns, nil, useGzip, bufferSize)
+}
+
+// CreateTypedTable() creates a table whose columns have declared types
+func (db *CsvDB) CreateTypedTable(tableName string,
+	columns, columnTypes []string, useGzip bool, bufferSize int) (*CsvTable, error) {
+	return db.createTable("", tableName, columns, columnTypes, useGzip, bufferSize)
 }
 
 func (db *CsvDB) GetTable(tableName string) (*CsvTable, error) {
@@ -104,10 +150,11 @@ func (db *CsvDB) getTable(groupName, tableName string) (*CsvTable, error) {
 
 // DropAllTables() drop all tables in the CsvDB object
 func (db *CsvDB) DropAll() error {
-	for _, g := range db.Groups {
+	for groupName, g := range db.Groups {
 		if err := g.Drop(); err != nil {
 			return err
 		}
+		delete(db.Groups, groupName)
 	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package csvdb
+
+import (
+	"encoding/csv"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// CsvFormat is the CSV dialect of the table files of a group.
+// The zero value is the default of encoding/csv.
+type CsvFormat struct {
+	// field delimiter. ',' if 0
+	Delimiter rune
+	// lines starting with Comment are skipped if it is not 0
+	Comment rune
+	// allow quotes in unquoted fields
+	LazyQuotes bool
+	// ignore spaces at the beginning of fields
+	TrimLeadingSpace bool
+	// end rows with \r\n instead of \n
+	UseCRLF bool
+}
+
+func validFormatRune(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' &&
+		utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+func (f CsvFormat) validate() error {
+	if f.Delimiter != 0 && !validFormatRune(f.Delimiter) {
+		return errors.Errorf("invalid delimiter %q", f.Delimiter)
+	}
+	if f.Comment != 0 && !validFormatRune(f.Comment) {
+		return errors.Errorf("invalid comment character %q", f.Comment)
+	}
+	delimiter := f.Delimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	if f.Comment == delimiter {
+		return errors.New("the comment character is the delimiter")
+	}
+	return nil
+}
+
+func (f CsvFormat) applyReader(r *csv.Reader) {
+	if f.Delimiter != 0 {
+		r.Comma = f.Delimiter
+	}
+	r.Comment = f.Comment
+	r.LazyQuotes = f.LazyQuotes
+	r.TrimLeadingSpace = f.TrimLeadingSpace
+}
+
+func (f CsvFormat) applyWriter(w *csv.Writer) {
+	if f.Delimiter != 0 {
+		w.Comma = f.Delimiter
+	}
+	w.UseCRLF = f.UseCRLF
+}
+
+// save() writes the format to sec except default values
+func (f CsvFormat) save(sec *ini.Section) {
+	if f.Delimiter != 0 {
+		sec.Key("delimiter").SetValue(string(f.Delimiter))
+	}
+	if f.Comment != 0 {
+		sec.Key("comment").SetValue(string(f.Comment))
+	}
+	if f.LazyQuotes {
+		sec.Key("lazyQuotes").SetValue(strconv.FormatBool(f.LazyQuotes))
+	}
+	if f.TrimLeadingSpace {
+		sec.Key("trimLeadingSpace").SetValue(strconv.FormatBool(f.TrimLeadingSpace))
+	}
+	if f.UseCRLF {
+		sec.Key("useCRLF").SetValue(strconv.FormatBool(f.UseCRLF))
+	}
+}
+
+// loadKey() sets the format item of k. Other keys are ignored
+func (f *CsvFormat) loadKey(k *ini.Key) error {
+	switch k.Name() {
+	case "delimiter", "comment":
+		r, size := utf8.DecodeRuneInString(k.String())
+		if size != len(k.String()) {
+			return errors.Errorf("%s must be 1 character: %q", k.Name(), k.String())
+		}
+		if k.Name() == "delimiter" {
+			f.Delimiter = r
+		} else {
+			f.Comment = r
+		}
+	case "lazyQuotes":
+		f.LazyQuotes = k.MustBool(false)
+	case "trimLeadingSpace":
+		f.TrimLeadingSpace = k.MustBool(false)
+	case "useCRLF":
+		f.UseCRLF = k.MustBool(false)
+	}
+	return nil
+}
@@ -1,49 +1,95 @@
 package csvdb
 
 import (
-	"compress/gzip"
 	"encoding/csv"
 	"io"
 	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// newCsvReader() opens filename decompressing it by the codec
+// detected from its first bytes
 func newCsvReader(filename string) (*CsvReader, error) {
-	ext := filepath.Ext(filename)
-	var fr *os.File
-	var zr *gzip.Reader
-	var r *csv.Reader
-	var err error
-	mode := ""
+	fr, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return newFileCsvReader(fr, fr, filename)
+}
 
-	fr, err = os.Open(filename)
+/*
+newLockedCsvReader() opens filename under a shared lock and reads the
+file as it was then. The lock is released before rows are read, so
+callers iterating rows do not block writers, even in the same process.
+Rewrites replace the file by a rename which leaves the open file as it
+was, and appends only add bytes after the size taken under the lock.
+*/
+func newLockedCsvReader(filename string, lockTimeout time.Duration) (*CsvReader, error) {
+	lock, err := lockFile(filename, false, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	fr, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	st, err := fr.Stat()
 	if err != nil {
+		fr.Close()
 		return nil, errors.WithStack(err)
 	}
+	return newFileCsvReader(fr, io.NewSectionReader(fr, 0, st.Size()), filename)
+}
 
-	if ext == ".gz" || ext == ".gzip" {
-		zr, err = gzip.NewReader(fr)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		r = csv.NewReader(zr)
-		mode = cRModeGZip
-	} else {
-		r = csv.NewReader(fr)
-		mode = cRModePlain
+// newFileCsvReader() returns a reader of src read from the file fr
+// which is closed with the reader
+func newFileCsvReader(fr *os.File, src io.Reader, filename string) (*CsvReader, error) {
+	src, zr, codec, err := newDecompressor(src)
+	if err != nil {
+		fr.Close()
+		return nil, errors.Wrapf(err, "file %s", filename)
 	}
 
 	c := new(CsvReader)
 	c.fr = fr
 	c.zr = zr
-	c.reader = r
+	c.reader = csv.NewReader(src)
 	c.filename = filename
-	c.mode = mode
+	c.mode = codec.Name
 	return c, nil
 }
 
+// setFormat() sets the CSV dialect to read
+func (c *CsvReader) setFormat(format CsvFormat) {
+	format.applyReader(c.reader)
+}
+
+// checkHeader() reads the header row and checks it is columns.
+// An empty file has no header.
+func (c *CsvReader) checkHeader(columns []string) error {
+	header, err := c.reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return checkHeaderRow(header, columns, c.filename)
+}
+
+// checkHeaderRow() checks the header row of filename is columns
+func checkHeaderRow(header, columns []string, filename string) error {
+	if strings.Join(header, ",") != strings.Join(columns, ",") {
+		return errors.Errorf("the header [%s] of %s does not match the columns [%s]",
+			strings.Join(header, ","), filename, strings.Join(columns, ","))
+	}
+	return nil
+}
+
 func (c *CsvReader) next() bool {
 	values, err := c.reader.Read()
 	c.err = err
@@ -58,10 +104,21 @@ func (c *CsvReader) next() bool {
 	return true
 }
 
+func (c *CsvReader) current() []string {
+	return c.values
+}
+
+func (c *CsvReader) lastErr() error {
+	if c.err == io.EOF {
+		return nil
+	}
+	return c.err
+}
+
 func (c *CsvReader) close() {
 	if c.zr != nil {
 		c.zr.Close()
-		c.fr = nil
+		c.zr = nil
 	}
 	if c.fr != nil {
 		c.fr.Close()
@@ -1,24 +1,18 @@
 package csvdb
 
 import (
+	"database/sql"
 	"fmt"
-	"io"
-	"sort"
 
 	"github.com/pkg/errors"
 )
 
-func newCsvRows(conditionCheckFunc func([]string) bool,
-	path string, tableCols, selectedCols []string) (*CsvRows, error) {
-	reader, err := newCsvReader(path)
-	if err != nil {
-		return nil, err
-	}
+func newCsvRowsFromIter(iter rowIterator,
+	tableCols, tableColTypes, selectedCols []string) (*CsvRows, error) {
 	r := new(CsvRows)
-	r.reader = reader
-	r.conditionCheckFunc = conditionCheckFunc
+	r.iter = iter
 	r.tableCols = tableCols
-	r.orderbyExecuted = false
+	r.tableColTypes = tableColTypes
 
 	colIndexes := make([]int, len(selectedCols))
 	for i, cols := range selectedCols {
@@ -31,6 +25,7 @@ func newCsvRows(conditionCheckFunc func([]string) bool,
 			}
 		}
 		if !ok {
+			iter.close()
 			return nil, errors.New(fmt.Sprintf("col %s is not in the table", cols))
 		}
 	}
@@ -39,42 +34,51 @@ func newCsvRows(conditionCheckFunc func([]string) bool,
 }
 
 func (r *CsvRows) Next() bool {
-	if r.orderbyExecuted {
-		if r.orderbyBuffPos+1 >= r.orderbyBuff.Len() {
-			return false
-		}
-		for r.orderbyBuffPos < r.orderbyBuff.Len() {
-			r.orderbyBuffPos++
+	return r.iter.next()
+}
 
-			if r.conditionCheckFunc == nil || r.conditionCheckFunc(r.orderbyBuff[r.orderbyBuffPos].v) {
-				return true
-			}
-		}
-		r.orderbyErr = io.EOF
-	} else {
-		for r.reader.next() {
-			if r.conditionCheckFunc == nil || r.conditionCheckFunc(r.reader.values) {
-				return true
-			}
-		}
+func (r *CsvRows) Err() error {
+	return r.iter.lastErr()
+}
+
+// TableName() returns the name of the table the current row came from.
+// It is "" after OrderBy() of rows of a group and for aggregates
+func (r *CsvRows) TableName() string {
+	if it, ok := r.iter.(tableIterator); ok {
+		return it.currentTable()
 	}
-	return false
+	return r.tableName
 }
 
-func (r *CsvRows) Err() error {
-	if r.orderbyExecuted {
-		return r.orderbyErr
+// Columns() returns the names of the columns Scan() sets
+func (r *CsvRows) Columns() []string {
+	if r.labels != nil {
+		return r.labels
+	}
+	if len(r.selectedColIndexes) == 0 {
+		return r.tableCols
 	}
-	return r.reader.err
+	cols := make([]string, len(r.selectedColIndexes))
+	for i, colidx := range r.selectedColIndexes {
+		cols[i] = r.tableCols[colidx]
+	}
+	return cols
 }
 
-func (r *CsvRows) Scan(args ...interface{}) error {
-	var v []string
-	if r.orderbyExecuted {
-		v = r.orderbyBuff[r.orderbyBuffPos].v
-	} else {
-		v = r.reader.values //r.tableCols[i]
+// ColumnTypes() returns the types of the columns Scan() sets
+func (r *CsvRows) ColumnTypes() []string {
+	if len(r.selectedColIndexes) == 0 {
+		return r.tableColTypes
+	}
+	colTypes := make([]string, len(r.selectedColIndexes))
+	for i, colidx := range r.selectedColIndexes {
+		colTypes[i] = r.tableColTypes[colidx]
 	}
+	return colTypes
+}
+
+func (r *CsvRows) Scan(args ...interface{}) error {
+	v := r.iter.current()
 	if r.selectedColIndexes == nil || len(r.selectedColIndexes) == 0 {
 		if len(args) != len(r.tableCols) {
 			return errors.New(fmt.Sprintf("Got %d args while expected %d",
@@ -83,7 +87,7 @@ func (r *CsvRows) Scan(args ...interface{}) error {
 		for i, _ := range r.tableCols {
 			src := v[i]
 			dst := args[i]
-			if err := convFromString(src, dst); err != nil {
+			if err := r.scanValue(r.tableColTypes[i], src, dst); err != nil {
 				return err
 			}
 		}
@@ -95,7 +99,7 @@ func (r *CsvRows) Scan(args ...interface{}) error {
 		for argidx, colidx := range r.selectedColIndexes {
 			src := v[colidx]
 			dst := args[argidx]
-			if err := convFromString(src, dst); err != nil {
+			if err := r.scanValue(r.tableColTypes[colidx], src, dst); err != nil {
 				return err
 			}
 		}
@@ -104,48 +108,101 @@ func (r *CsvRows) Scan(args ...interface{}) error {
 }
 
 /*
-fieldTypes:
-int, int8, int32, int64,
-uint, uint8, uint16, uint32, uint64
-float32, float64,
-bool
+scanValue() sets a stored value to dest.
+dest can be a sql.Scanner such as *sql.NullString.
+NULL sets nil to sql.Scanner and *interface{} and the zero value
+to other destinations.
+*/
+func (r *CsvRows) scanValue(colType, src string, dest interface{}) error {
+	if isNullOutput(r.nullValue, colType, src) {
+		switch d := dest.(type) {
+		case sql.Scanner:
+			return errors.WithStack(d.Scan(nil))
+		case *interface{}:
+			*d = nil
+			return nil
+		}
+		return setZero(dest)
+	}
+	if d, ok := dest.(sql.Scanner); ok {
+		v, err := parseTyped(colType, src)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(d.Scan(v))
+	}
+	return convTyped(colType, src, dest)
+}
+
+// Close() closes the file and temporary files the rows are read from.
+// Rows not read to the end must be closed
+func (r *CsvRows) Close() {
+	r.iter.close()
+}
+
+func (r *CsvRows) limit(offset, limit int) {
+	r.iter = newLimitIter(r.iter, offset, limit)
+}
+
+// SetSortMemory() sets the bytes of rows OrderBy() sorts in memory.
+// Larger results are sorted in temporary files and merged.
+func (r *CsvRows) SetSortMemory(bytes int64) {
+	r.sortMemory = bytes
+}
+
+/*
+fields are compared by the types of the table columns.
 
 direction:
-asc, desc
+CorderByAsc, CorderByDesc
 */
-func (r *CsvRows) OrderBy(fields []string, fieldTypes []string, direction int) error {
-	if len(fields) != len(fieldTypes) {
-		return errors.Errorf("length of fields=%d does not match that of fieldTypes=%d",
-			len(fields), len(fieldTypes))
-	}
-	fieldIdxs := make([]int, len(fields))
+func (r *CsvRows) OrderBy(fields []string, direction int) error {
+	keys := make([]OrderSpec, len(fields))
 	for i, f := range fields {
+		keys[i] = OrderSpec{Field: f, Direction: direction}
+	}
+	return r.OrderByKeys(keys...)
+}
+
+// OrderByKeys() sorts rows by keys, each with its own direction,
+// NULL ordering and collation
+func (r *CsvRows) OrderByKeys(keys ...OrderSpec) error {
+	cmp := new(rowComparator)
+	cmp.nullValue = r.nullValue
+	cmp.keys = make([]*orderKey, len(keys))
+	for i, spec := range keys {
 		ok := false
 		for j, colt := range r.tableCols {
-			if colt == f {
-				fieldIdxs[i] = j
+			if colt == spec.Field {
+				k, err := newOrderKey(spec, j, r.tableColTypes[j])
+				if err != nil {
+					return err
+				}
+				cmp.keys[i] = k
 				ok = true
 				break
 			}
 		}
 		if !ok {
-			return errors.New(fmt.Sprintf("col %s is not in the table", f))
+			return errors.New(fmt.Sprintf("col %s is not in the table", spec.Field))
 		}
 	}
-	ov := make(orderBuffRows, 0)
-	for r.reader.next() {
-		if r.conditionCheckFunc == nil || r.conditionCheckFunc(r.reader.values) {
-			or := new(orderBuffRow)
-			or.v = r.reader.values
-			or.orderFieldTypes = fieldTypes
-			or.direction = direction
-			or.orderFieldIdxs = fieldIdxs
-			ov = append(ov, *or)
+	sorter := newExternalSorter(cmp, r.tmpDir, r.sortMemory)
+	for r.iter.next() {
+		if err := sorter.add(r.iter.current()); err != nil {
+			sorter.cleanup()
+			return err
 		}
 	}
-	sort.Sort(ov)
-	r.orderbyExecuted = true
-	r.orderbyBuff = ov
-	r.orderbyBuffPos = -1
+	if err := r.iter.lastErr(); err != nil {
+		sorter.cleanup()
+		return err
+	}
+	r.iter.close()
+	iter, err := sorter.finish()
+	if err != nil {
+		return err
+	}
+	r.iter = iter
 	return nil
 }
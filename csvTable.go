@@ -1,16 +1,19 @@
 package csvdb
 
 import (
+	"database/sql/driver"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 func newCsvTable(groupName, tableName, path string,
-	columns []string, useGzip bool,
+	columns, columnTypes []string, codec string,
 	bufferSize int) *CsvTable {
 	t := new(CsvTable)
 	t.CsvTableDef = new(CsvTableDef)
@@ -18,117 +21,281 @@ func newCsvTable(groupName, tableName, path string,
 	t.tableName = tableName
 	t.path = path
 	t.columns = columns
-	colMap := make(map[string]int)
-	for i, col := range columns {
-		colMap[col] = i
-	}
-	t.colMap = colMap
-	t.useGzip = useGzip
+	t.columnTypes = columnTypes
+	t.colMap = newColMap(columns)
+	t.codec = codec
 	t.bufferSize = bufferSize
 	t.buff = newInsertBuffer(bufferSize)
+	t.chunkBytes = cParallelChunkBytes
 	return t
 }
 
+// OpenCsvTable() opens a CSV file starting with a header row
+// as a table without an ini file. The columns are untyped.
+func OpenCsvTable(path string) (*CsvTable, error) {
+	return OpenCsvTableWithFormat(path, CsvFormat{})
+}
+
+// OpenCsvTableWithFormat() is OpenCsvTable() for a file in the CSV dialect format
+func OpenCsvTableWithFormat(path string, format CsvFormat) (*CsvTable, error) {
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	reader, err := newLockedCsvReader(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	reader.setFormat(format)
+	columns, err := reader.reader.Read()
+	reader.close()
+	if err == io.EOF {
+		return nil, errors.Errorf("%s has no header row", path)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	colMap := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		if colMap[col] {
+			return nil, errors.Errorf("column %s of %s is duplicated", col, path)
+		}
+		colMap[col] = true
+	}
+	columnTypes, err := normalizeColTypes(columns, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tableName := strings.SplitN(filepath.Base(path), ".", 2)[0]
+	t := newCsvTable(tableName, tableName, path, columns, columnTypes,
+		codecByExt(path).Name, cDefaultBuffSize)
+	t.hasHeader = true
+	t.format = format
+	return t, nil
+}
+
 func (t *CsvTable) Close() {
 	t.buff = nil
 }
 
 func (t *CsvTable) Drop() error {
+	if err := t.dropIndexes(); err != nil {
+		return err
+	}
+	if err := t.DropZoneMap(); err != nil {
+		return err
+	}
 	if pathExist(t.path) {
-		return os.Remove(t.path)
+		if err := os.Remove(t.path); err != nil {
+			return err
+		}
 	}
-	return nil
+	return removeLockFile(t.path)
 }
 
-func (t *CsvTable) Count(conditionCheckFunc func([]string) bool) int {
+// SetLockTimeout() sets how long reads and writes of the table wait for
+// locks held by other processes. 0 waits until the lock is released and
+// CLockNoWait fails immediately with ErrLocked
+func (t *CsvTable) SetLockTimeout(timeout time.Duration) {
+	t.lockTimeout = timeout
+}
+
+// lock() locks the table file for reading or writing
+func (t *CsvTable) lock(exclusive bool) (*fileLock, error) {
+	return lockFile(t.path, exclusive, t.lockTimeout)
+}
+
+func (t *CsvTable) Count(condition interface{}) int {
 	if !pathExist(t.path) {
 		return 0
 	}
-
-	reader, err := newCsvReader(t.path)
+	_, st, err := t.aggregate1(condition, AggSpec{Func: CAggCount})
 	if err != nil {
 		return -1
 	}
-	cnt := 0
-	defer reader.close()
-	for reader.next() {
-		v := reader.values
-		if conditionCheckFunc == nil {
-			cnt++
-		} else if conditionCheckFunc(v) {
-			cnt++
-		}
-	}
-	if reader.err != nil && reader.err != io.EOF {
-		return -1
-	}
-	return cnt
+	return int(st.cnt)
 }
 
-func (t *CsvTable) Sum(conditionCheckFunc func([]string) bool,
+func (t *CsvTable) Sum(condition interface{},
 	column string, s interface{}) error {
 	if !pathExist(t.path) {
 		convFromString("0", s)
 		return nil
 	}
 
-	idx, ok := t.colMap[column]
+	if _, err := sumColumn(t.colMap, t.columnTypes, column); err != nil {
+		return err
+	}
+	d, st, err := t.aggregate1(condition, AggSpec{Func: CAggSum, Column: column})
+	if err != nil {
+		return err
+	}
+	return setSum(d, st, s)
+}
+
+// sumColumn() returns the index of a column which can be summed
+func sumColumn(colMap map[string]int, columnTypes []string, column string) (int, error) {
+	idx, ok := colMap[column]
 	if !ok {
-		return errors.New(fmt.Sprintf("Column %s does not exist", column))
+		return -1, errors.New(fmt.Sprintf("Column %s does not exist", column))
 	}
+	colType := columnTypes[idx]
+	switch colType {
+	case cColTypeUntyped, CColTypeInt64, CColTypeFloat64:
+	default:
+		return -1, errors.Errorf("Column %s of type %s cannot be summed", column, colType)
+	}
+	return idx, nil
+}
 
-	reader, err := newCsvReader(t.path)
+// setSum() sets the sum of st to s. The sum of no values is 0
+func setSum(d *aggDef, st *aggState, s interface{}) error {
+	if st.cnt == 0 {
+		return convFromString("0", s)
+	}
+	return convFromString(d.result(st), s)
+}
+
+// Aggregate() computes aggs for each combination of groupBy values in 1 scan.
+// The rows have groupBy columns followed by aggs columns.
+// Without groupBy, 1 row is returned.
+func (t *CsvTable) Aggregate(condition interface{},
+	groupBy []string, aggs ...AggSpec) (*CsvRows, error) {
+	a, err := newAggregation(t.columns, t.columnTypes, t.colMap, groupBy, aggs, t.nullValue)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	res := 0.0
-	defer reader.close()
-	for reader.next() {
-		vs := reader.values
-		v, err := strconv.ParseFloat(vs[idx], 64)
-		if err != nil {
-			return err
-		}
+	p := new(scanPlan)
+	if err := t.planScan(p, condition, getParallelism(t.parallelism, condition)); err != nil {
+		return nil, err
+	}
+	groups, err := p.aggregate(getParallelism(t.parallelism, condition), a)
+	if err != nil {
+		return nil, err
+	}
+	return a.result(groups)
+}
 
-		if conditionCheckFunc == nil {
-			res += v
-		} else if conditionCheckFunc(vs) {
-			res += v
-		}
+// aggregate1() returns the state of agg over rows matching condition
+func (t *CsvTable) aggregate1(condition interface{}, agg AggSpec) (*aggDef, *aggState, error) {
+	a, err := newAggregation(t.columns, t.columnTypes, t.colMap, nil, []AggSpec{agg}, t.nullValue)
+	if err != nil {
+		return nil, nil, err
 	}
-	if reader.err != nil && reader.err != io.EOF {
-		return reader.err
+	p := new(scanPlan)
+	if err := t.planScan(p, condition, getParallelism(t.parallelism, condition)); err != nil {
+		return nil, nil, err
 	}
-	if err := convFromString(asString(res), s); err != nil {
-		return err
+	st, err := p.aggregate1(getParallelism(t.parallelism, condition), a)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
+	return a.defs[0], st, nil
 }
 
-func (t *CsvTable) SelectRows(conditionCheckFunc func([]string) bool,
+// SelectRows() returns rows matching condition. The rows are those of
+// the table when the first row is read, and writes while the rows are
+// read do not change them. Close() the rows if they are not read to the end
+func (t *CsvTable) SelectRows(condition interface{},
 	colNames []string) (*CsvRows, error) {
-	return newCsvRows(conditionCheckFunc,
-		t.path, t.columns, colNames)
+	it, err := t.scanIter(condition)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newCsvRowsFromIter(it, t.columns, t.columnTypes, colNames)
+	if err != nil {
+		return nil, err
+	}
+	r.tmpDir = filepath.Dir(t.path)
+	r.nullValue = t.nullValue
+	r.tableName = t.tableName
+	return r, nil
 }
 
-func (t *CsvTable) Select1Row(conditionCheckFunc func([]string) bool,
+/*
+scanIter() returns an iterator over rows matching condition.
+*Cond conditions are looked up by an index of a column in them,
+or skip blocks of rows by the zone map. The rows are checked
+by the whole condition anyway.
+*/
+func (t *CsvTable) scanIter(condition interface{}) (rowIterator, error) {
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return nil, err
+	}
+	var it rowIterator
+	if c, ok := condition.(*Cond); ok && c != nil {
+		if rng := t.indexRange(c); rng != nil {
+			it, err = t.newIndexIter(rng)
+		} else if t.hasZoneMap() {
+			it, err = t.newZoneIter(c)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if it == nil {
+		it = t.newScanIter()
+	}
+	return newFilterIter(it, conditionCheckFunc), nil
+}
+
+// newScanIter() returns an iterator over rows of the table file
+func (t *CsvTable) newScanIter() *multiFileIter {
+	it := newMultiFileIter([]string{t.path}, t.lockTimeout)
+	if t.hasHeader {
+		it.header = t.columns
+	}
+	it.format = t.format
+	return it
+}
+
+// openR() opens the table file skipping the header row.
+// With isLocked, the file is opened under a shared lock and read as it was then
+func (t *CsvTable) openR(isLocked bool) (*CsvReader, error) {
+	var reader *CsvReader
+	var err error
+	if isLocked {
+		reader, err = newLockedCsvReader(t.path, t.lockTimeout)
+	} else {
+		reader, err = newCsvReader(t.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader.setFormat(t.format)
+	if t.hasHeader {
+		if err := reader.checkHeader(t.columns); err != nil {
+			reader.close()
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+func (t *CsvTable) Select1Row(condition interface{},
 	colNames []string, args ...interface{}) error {
-	r, err := t.SelectRows(conditionCheckFunc, colNames)
+	r, err := t.SelectRows(condition, colNames)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 	for r.Next() {
 		return r.Scan(args...)
 	}
 	return errors.New("No record found")
 }
 
-func (t *CsvTable) readRows(conditionCheckFunc func([]string) bool) ([][]string, error) {
+func (t *CsvTable) readRows(condition interface{}) ([][]string, error) {
 	if !pathExist(t.path) {
 		return nil, nil
 	}
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return nil, err
+	}
 
-	reader, err := newCsvReader(t.path)
+	reader, err := t.openR(true)
 	if err != nil {
 		return nil, err
 	}
@@ -142,40 +309,85 @@ func (t *CsvTable) readRows(conditionCheckFunc func([]string) bool) ([][]string,
 			found = append(found, v)
 		}
 	}
-	if reader.err != nil {
-		return nil, reader.err
+	if err := reader.lastErr(); err != nil {
+		return nil, err
 	}
 	return found, nil
 }
 
 func (t *CsvTable) InsertRow(columns []string, args ...interface{}) error {
+	row, err := t.newRow(columns, args...)
+	if err != nil {
+		return err
+	}
+
+	if t.buff.register(row) {
+		return t.Flush()
+	}
+
+	return nil
+}
+
+// newRow() returns a row of all table columns with args set to columns
+func (t *CsvTable) newRow(columns []string, args ...interface{}) ([]string, error) {
 	if columns == nil && len(args) != len(t.columns) {
-		return errors.New("len of args do not match to table columns")
+		return nil, errors.New("len of args do not match to table columns")
 	}
 	if columns != nil && len(columns) != len(args) {
-		return errors.New("len of columns and args do not match")
+		return nil, errors.New("len of columns and args do not match")
 	}
 
 	row := make([]string, len(t.columns))
 	if columns == nil {
 		for i, v := range args {
-			row[i] = asString(v)
+			s, err := t.typedString(i, v)
+			if err != nil {
+				return nil, err
+			}
+			row[i] = s
 		}
 	} else {
+		// columns not set are NULL
+		for i := range row {
+			row[i] = t.nullValue
+		}
 		for i, col := range columns {
 			j, ok := t.colMap[col]
 			if !ok {
-				return errors.New(fmt.Sprintf("column %s does not exist", col))
+				return nil, errors.New(fmt.Sprintf("column %s does not exist", col))
+			}
+			s, err := t.typedString(j, args[i])
+			if err != nil {
+				return nil, err
 			}
-			row[j] = asString(args[i])
+			row[j] = s
 		}
 	}
+	return row, nil
+}
 
-	if t.buff.register(row) {
-		t.Flush()
+// typedString() converts v to the string stored in the column colIdx.
+// nil, driver.Valuer values such as sql.NullString{} and empty values
+// of typed columns are stored as the NULL string
+func (t *CsvTable) typedString(colIdx int, v interface{}) (string, error) {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = valuer.Value(); err != nil {
+			return "", errors.Wrapf(err, "column %s", t.columns[colIdx])
+		}
 	}
-
-	return nil
+	if v == nil {
+		return t.nullValue, nil
+	}
+	colType := t.columnTypes[colIdx]
+	s, err := typedString(colType, v)
+	if err != nil {
+		return "", errors.Wrapf(err, "column %s", t.columns[colIdx])
+	}
+	if s == "" && isNullValue(t.nullValue, colType, s) {
+		return t.nullValue, nil
+	}
+	return s, nil
 }
 
 func (t *CsvTable) Flush() error {
@@ -190,6 +402,56 @@ func (t *CsvTable) flush(wmode string) error {
 	if t.buff.pos < 0 {
 		return nil
 	}
+	lock, err := t.lock(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	return t.writeBuff(wmode)
+}
+
+// insertRows() writes the insert buffer and rows to the table file,
+// or nothing if a row of them is rejected by the primary key
+func (t *CsvTable) insertRows(rows [][]string) error {
+	lock, err := t.lock(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	all := append(t.buff.rows[:t.buff.pos+1:t.buff.pos+1], rows...)
+	if len(t.keyIdxs) > 0 {
+		if err := t.checkKeys(all); err != nil {
+			return err
+		}
+	}
+	t.buff.setBuff(all)
+	return t.writeBuff(CWriteModeAppend)
+}
+
+// writeBuff() writes the insert buffer to the table file.
+// Rows rejected by the primary key are not written, and the error of
+// the first of them is returned after the other rows are written.
+// The caller must hold the exclusive lock
+func (t *CsvTable) writeBuff(wmode string) error {
+	if t.buff.pos < 0 {
+		return nil
+	}
+	var rejected error
+	if len(t.keyIdxs) > 0 {
+		var err error
+		if wmode, rejected, err = t.uniqueBuff(wmode); err != nil {
+			return err
+		}
+		if t.buff.pos < 0 {
+			t.buff.init()
+			return rejected
+		}
+	}
+	indexColumns := t.indexColumns()
+	var orgSt os.FileInfo
+	if len(indexColumns) > 0 || t.hasZoneMap() {
+		orgSt, _ = os.Stat(t.path)
+	}
 	writer, err := t.openW(wmode)
 	if err != nil {
 		return err
@@ -205,57 +467,70 @@ func (t *CsvTable) flush(wmode string) error {
 		}
 	}
 	t.buff.init()
-	writer.flush()
-	return nil
+	if err := writer.commit(); err != nil {
+		return err
+	}
+	t.updateIndexes(indexColumns, wmode, orgSt)
+	t.updateZoneMap(wmode, orgSt)
+	return rejected
 }
 
 func (t *CsvTable) openW(writeMode string) (*CsvWriter, error) {
-	writer, err := newCsvWriter(t.path, writeMode)
+	codec, err := getCodec(t.codec)
 	if err != nil {
 		return nil, err
 	}
+	writer, err := openCsvWriter(t.path, writeMode, codec, t.codecLevel)
+	if err != nil {
+		return nil, err
+	}
+	writer.setFormat(t.format)
+	if t.hasHeader {
+		if err := writer.writeHeader(t.columns); err != nil {
+			writer.close()
+			return nil, err
+		}
+	}
 	return writer, nil
 }
 
-func (t *CsvTable) Max(conditionCheckFunc func([]string) bool,
+func (t *CsvTable) Max(condition interface{},
 	field string, v interface{}) error {
-	return t.minmax(conditionCheckFunc, true, field, v)
+	return t.minmax(condition, true, field, v)
 }
 
-func (t *CsvTable) Min(conditionCheckFunc func([]string) bool,
+func (t *CsvTable) Min(condition interface{},
 	field string, v interface{}) error {
-	return t.minmax(conditionCheckFunc, false, field, v)
+	return t.minmax(condition, false, field, v)
 }
 
-func (t *CsvTable) minmax(conditionCheckFunc func([]string) bool,
+func (t *CsvTable) minmax(condition interface{},
 	isMax bool, field string, v interface{}) error {
-	r, err := t.SelectRows(conditionCheckFunc, []string{field})
-
+	if _, ok := t.colMap[field]; !ok {
+		return errors.New(fmt.Sprintf("Column %s does not exist", field))
+	}
+	fn := CAggMin
+	if isMax {
+		fn = CAggMax
+	}
+	_, st, err := t.aggregate1(condition, AggSpec{Func: fn, Column: field})
 	if err != nil {
 		return err
 	}
-	var a float64
-	m := 1.0
-	if !isMax {
-		m = -1.0
-	}
-	res := 0.0
-	i := 0
-	for r.Next() {
-		if err := r.Scan(&a); err != nil {
-			return err
-		}
-		if conditionCheckFunc != nil && !conditionCheckFunc(r.reader.values) {
-			continue
-		}
-		if i == 0 || m*res < m*a {
-			res = a
-		}
-		i++
+	return setMinMax(st, v)
+}
+
+// setMinMax() sets the min or max value of st to v.
+// v is set to its zero value without values
+func setMinMax(st *aggState, v interface{}) error {
+	if st.cnt == 0 {
+		return setZero(v)
 	}
+	return convFromString(st.val, v)
+}
 
-	convFromString(asString(res), v)
-	return nil
+func (t *CsvTable) matcher(condition interface{}) (func([]string) bool, error) {
+	return toMatcher(condition, t.colMap, t.columnTypes, t.nullValue)
 }
 
 func (t *CsvTable) GetColIdx(colName string) int {
@@ -266,50 +541,86 @@ func (t *CsvTable) GetColIdx(colName string) int {
 	return -1
 }
 
-func (t *CsvTable) Delete(conditionCheckFunc func([]string) bool) error {
-	return t.update(conditionCheckFunc, nil, false)
+func (t *CsvTable) Delete(condition interface{}) error {
+	_, err := t.update(condition, nil, false)
+	return err
 }
 
-func (t *CsvTable) Upsert(conditionCheckFunc func([]string) bool,
+// Upsert() updates rows matching condition, or inserts updates
+// if no rows match. Without condition, tables with a primary key
+// match the row of the key in updates
+func (t *CsvTable) Upsert(condition interface{},
 	updates map[string]interface{}) error {
-	return t.update(conditionCheckFunc, updates, true)
+	if condition == nil && len(t.keyIdxs) > 0 {
+		conditionCheckFunc, err := t.keyUpsertCondition(updates)
+		if err != nil {
+			return err
+		}
+		condition = conditionCheckFunc
+	}
+	_, err := t.update(condition, updates, true)
+	return err
 }
 
-func (t *CsvTable) Update(conditionCheckFunc func([]string) bool,
+func (t *CsvTable) Update(condition interface{},
 	updates map[string]interface{}) error {
-	return t.update(conditionCheckFunc, updates, false)
+	_, err := t.update(condition, updates, false)
+	return err
 }
 
 func (t *CsvTable) Truncate() error {
+	lock, err := t.lock(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	return t.truncate()
+}
+
+func (t *CsvTable) truncate() error {
 	writer, err := t.openW(CWriteModeWrite)
 	if err != nil {
 		return err
 	}
 	defer writer.close()
-	writer.flush()
+	if err := writer.commit(); err != nil {
+		return err
+	}
+	t.updateIndexes(t.indexColumns(), CWriteModeWrite, nil)
+	t.updateZoneMap(CWriteModeWrite, nil)
 	return nil
 }
 
-func (t *CsvTable) update(conditionCheckFunc func([]string) bool,
-	updates map[string]interface{}, isUpsert bool) error {
+// update() returns the number of updated or deleted rows
+func (t *CsvTable) update(condition interface{},
+	updates map[string]interface{}, isUpsert bool) (int64, error) {
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return 0, err
+	}
+	lock, err := t.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.unlock()
 	if conditionCheckFunc == nil && updates == nil {
-		return t.Truncate()
+		return 0, t.truncate()
 	}
 
 	var reader *CsvReader
-	var err error
 	if pathExist(t.path) {
-		reader, err = newCsvReader(t.path)
+		reader, err = t.openR(false)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		defer reader.close()
 	} else if !isUpsert {
-		return nil
+		return 0, nil
 	}
 	rows := make([][]string, 0)
 	isUpdated := false
 	cnt := 0
+	updCnt := int64(0)
 	for reader != nil && reader.next() {
 		cnt++
 		v := reader.values
@@ -321,29 +632,54 @@ func (t *CsvTable) update(conditionCheckFunc func([]string) bool,
 		} else {
 			if conditionCheckFunc == nil || conditionCheckFunc(v) {
 				for col, updv := range updates {
-					v[t.colMap[col]] = asString(updv)
+					idx, ok := t.colMap[col]
+					if !ok {
+						return 0, errors.New(fmt.Sprintf("column %s does not exist", col))
+					}
+					s, err := t.typedString(idx, updv)
+					if err != nil {
+						return 0, err
+					}
+					v[idx] = s
 				}
 				isUpdated = true
+				updCnt++
 			}
 			rows = append(rows, v)
 		}
 	}
 	if reader != nil {
+		if err := reader.lastErr(); err != nil {
+			return 0, err
+		}
 		reader.close()
 		reader = nil
 	}
 
 	if len(rows) < cnt {
 		isUpdated = true
+		updCnt = int64(cnt - len(rows))
 	}
 
-	if isUpdated {
+	if isUpdated && len(rows) == 0 {
+		if err := t.truncate(); err != nil {
+			return 0, err
+		}
+	} else if isUpdated {
+		// updates to duplicate keys fail as a whole
+		if len(t.keyIdxs) > 0 {
+			if rows, err = t.uniqueRows(rows); err != nil {
+				return 0, err
+			}
+		}
 		buff := newInsertBuffer(len(rows))
 		buff.setBuff(rows)
+		orgBuff := t.buff
 		t.buff = buff
-
-		if err := t.flush(CWriteModeWrite); err != nil {
-			return err
+		err := t.writeBuff(CWriteModeWrite)
+		t.buff = orgBuff
+		if err != nil {
+			return 0, err
 		}
 	} else if isUpsert {
 		columns := make([]string, len(updates))
@@ -354,12 +690,15 @@ func (t *CsvTable) update(conditionCheckFunc func([]string) bool,
 			args[i] = val
 			i++
 		}
-		if err := t.InsertRow(columns, args...); err != nil {
-			return err
+		row, err := t.newRow(columns, args...)
+		if err != nil {
+			return 0, err
 		}
-		if t.buff.pos != -1 {
-			t.flush(CWriteModeAppend)
+		t.buff.register(row)
+		if err := t.writeBuff(CWriteModeAppend); err != nil {
+			return 0, err
 		}
+		updCnt = 1
 	}
-	return nil
+	return updCnt, nil
 }
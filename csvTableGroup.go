@@ -2,17 +2,25 @@ package csvdb
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-ini/ini"
 	"github.com/pkg/errors"
 )
 
 func newCsvTableGroup(groupName, rootDir string,
-	columns []string,
+	columns, columnTypes []string,
 	useGzip bool, bufferSize int) (*CsvTableGroup, error) {
+	columnTypes, err := normalizeColTypes(columns, columnTypes)
+	if err != nil {
+		return nil, err
+	}
 	g := new(CsvTableGroup)
 	g.groupName = groupName
 	g.rootDir = rootDir
@@ -24,24 +32,30 @@ func newCsvTableGroup(groupName, rootDir string,
 	//g.iniFile = fmt.Sprintf("%s/%s.%s", g.dataDir, groupName, cTblIniExt)
 	g.iniFile = fmt.Sprintf("%s/%s.%s", g.rootDir, groupName, cTblIniExt)
 	g.tableDefs = make(map[string]*CsvTableDef)
-	g.init(columns, useGzip, bufferSize)
+	codec := CCodecNone
+	if useGzip {
+		codec = CCodecGzip
+	}
+	g.init(columns, columnTypes, codec, bufferSize)
 	return g, nil
 }
 
 func (g *CsvTableGroup) getTablePath(tableName string) string {
 	path := fmt.Sprintf("%s/%s.csv", g.dataDir, tableName)
 
-	if g.useGzip {
-		path += ".gz"
+	// the codec is checked when it is set or loaded
+	if codec, err := getCodec(g.codec); err == nil {
+		path += codec.Ext
 	}
 	return path
 }
 
-func (g *CsvTableGroup) init(columns []string,
-	useGzip bool, bufferSize int) {
+func (g *CsvTableGroup) init(columns, columnTypes []string,
+	codec string, bufferSize int) {
 
 	g.columns = columns
-	g.useGzip = useGzip
+	g.columnTypes = columnTypes
+	g.codec = codec
 	g.bufferSize = bufferSize
 
 }
@@ -55,6 +69,7 @@ func (g *CsvTableGroup) load(iniFile string) error {
 			return errors.New("Not a proper path : " + iniFile)
 		}
 	}
+	g.rootDir = iniFile[:pos]
 
 	fileName := iniFile[pos+1:]
 	tokens := strings.Split(fileName, ".")
@@ -69,32 +84,94 @@ func (g *CsvTableGroup) load(iniFile string) error {
 
 	g.groupName = tokens[0]
 
+	lock, err := lockFile(iniFile, false, g.lockTimeout)
+	if err != nil {
+		return err
+	}
 	cfg, err := ini.Load(iniFile)
+	lock.unlock()
 	if err != nil {
 		return err
 	}
 	tableNames := make([]string, 0)
 	columns := make([]string, 0)
+	var columnTypes []string
 	useGzip := false
+	codec := ""
+	codecLevel := 0
 	bufferSize := cDefaultBuffSize
 	for _, k := range cfg.Section("conf").Keys() {
 		switch k.Name() {
 		case "tableNames":
-			tableNameStr := k.MustString("")
-			if tableNameStr == "" {
-				return errors.New("Not available ini file")
+			// partitioned groups may have no tables after retention
+			if tableNameStr := k.MustString(""); tableNameStr != "" {
+				tableNames = strings.Split(tableNameStr, ",")
 			}
-			tableNames = strings.Split(tableNameStr, ",")
 		case "columns":
 			columns = strings.Split(k.MustString(""), ",")
+		case "columnTypes":
+			columnTypes = strings.Split(k.MustString(""), ",")
 		case "useGzip":
 			useGzip = k.MustBool(false)
+		case "codec":
+			codec = k.MustString("")
+		case "codecLevel":
+			codecLevel = k.MustInt(0)
 		case "bufferSize":
 			bufferSize = k.MustInt(cDefaultBuffSize)
+		case "hasHeader":
+			g.hasHeader = k.MustBool(false)
+		case "nullValue":
+			g.nullValue = k.String()
+		case "primaryKey":
+			g.primaryKey = strings.Split(k.MustString(""), ",")
+		case "onDuplicate":
+			g.onDuplicate = k.MustString(CDupReject)
+		case "partitionColumn":
+			g.partitionColumn = k.MustString("")
+		case "partitionBy":
+			g.partitionBy = k.MustString("")
+		case "retention":
+			g.retention = k.MustInt(0)
+		default:
+			if err := g.format.loadKey(k); err != nil {
+				return errors.Wrapf(err, "ini file %s", iniFile)
+			}
 		}
 	}
 
-	g.init(columns, useGzip, bufferSize)
+	columnTypes, err = normalizeColTypes(columns, columnTypes)
+	if err != nil {
+		return errors.Wrapf(err, "ini file %s", iniFile)
+	}
+	if err := g.format.validate(); err != nil {
+		return errors.Wrapf(err, "ini file %s", iniFile)
+	}
+	if len(g.primaryKey) > 0 {
+		if g.onDuplicate == "" {
+			g.onDuplicate = CDupReject
+		}
+		if err := validatePrimaryKey(columns, g.primaryKey, g.onDuplicate); err != nil {
+			return errors.Wrapf(err, "ini file %s", iniFile)
+		}
+	}
+	if g.partitionColumn != "" {
+		if err := validatePartition(columns, columnTypes, g.partitionColumn, g.partitionBy); err != nil {
+			return errors.Wrapf(err, "ini file %s", iniFile)
+		}
+	}
+	// groups before codecs have only useGzip
+	if codec == "" {
+		codec = CCodecNone
+		if useGzip {
+			codec = CCodecGzip
+		}
+	}
+	if _, err := getCodec(codec); err != nil {
+		return errors.Wrapf(err, "ini file %s", iniFile)
+	}
+	g.init(columns, columnTypes, codec, bufferSize)
+	g.codecLevel = codecLevel
 	tableDefs := make(map[string]*CsvTableDef, len(tableNames))
 	for _, tableName := range tableNames {
 		tableDefs[tableName] = newCsvTableDef(g.groupName,
@@ -105,11 +182,9 @@ func (g *CsvTableGroup) load(iniFile string) error {
 	return nil
 }
 
-func (g *CsvTableGroup) save() error {
-	if len(g.tableDefs) == 0 {
-		return nil
-	}
-
+// iniConfig() returns the ini file updated by the settings of the group.
+// The caller must hold the lock of the ini file
+func (g *CsvTableGroup) iniConfig() (*ini.File, error) {
 	tableNames := make([]string, len(g.tableDefs))
 	i := 0
 	for tableName, _ := range g.tableDefs {
@@ -117,24 +192,80 @@ func (g *CsvTableGroup) save() error {
 		i++
 	}
 
-	file, err := os.OpenFile(g.iniFile, os.O_CREATE, 0640)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	cfg, err := ini.Load(g.iniFile)
-	if err != nil {
-		return errors.WithStack(err)
+	cfg := ini.Empty()
+	if pathExist(g.iniFile) {
+		var err error
+		cfg, err = ini.Load(g.iniFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
 	cfg.Section("conf").Key("groupName").SetValue(g.groupName)
 	cfg.Section("conf").Key("columns").SetValue(strings.Join(g.columns, ","))
+	if isTypedGroup(g.columnTypes) {
+		cfg.Section("conf").Key("columnTypes").SetValue(strings.Join(g.columnTypes, ","))
+	} else {
+		cfg.Section("conf").DeleteKey("columnTypes")
+	}
 	cfg.Section("conf").Key("tableNames").SetValue(strings.Join(tableNames, ","))
-	cfg.Section("conf").Key("useGzip").SetValue(strconv.FormatBool(g.useGzip))
+	cfg.Section("conf").Key("useGzip").SetValue(strconv.FormatBool(g.codec == CCodecGzip))
+	if g.codec != CCodecNone {
+		cfg.Section("conf").Key("codec").SetValue(g.codec)
+	}
+	if g.codecLevel != 0 {
+		cfg.Section("conf").Key("codecLevel").SetValue(strconv.Itoa(g.codecLevel))
+	}
 	cfg.Section("conf").Key("bufferSize").SetValue(strconv.Itoa(g.bufferSize))
+	if g.hasHeader {
+		cfg.Section("conf").Key("hasHeader").SetValue(strconv.FormatBool(g.hasHeader))
+	}
+	g.format.save(cfg.Section("conf"))
+	if g.nullValue != "" {
+		cfg.Section("conf").Key("nullValue").SetValue(g.nullValue)
+	}
+	if len(g.primaryKey) > 0 {
+		cfg.Section("conf").Key("primaryKey").SetValue(strings.Join(g.primaryKey, ","))
+		cfg.Section("conf").Key("onDuplicate").SetValue(g.onDuplicate)
+	} else {
+		cfg.Section("conf").DeleteKey("primaryKey")
+		cfg.Section("conf").DeleteKey("onDuplicate")
+	}
+	if g.partitionColumn != "" {
+		cfg.Section("conf").Key("partitionColumn").SetValue(g.partitionColumn)
+		cfg.Section("conf").Key("partitionBy").SetValue(g.partitionBy)
+	} else {
+		cfg.Section("conf").DeleteKey("partitionColumn")
+		cfg.Section("conf").DeleteKey("partitionBy")
+	}
+	if g.retention > 0 {
+		cfg.Section("conf").Key("retention").SetValue(strconv.Itoa(g.retention))
+	} else {
+		cfg.Section("conf").DeleteKey("retention")
+	}
+	return cfg, nil
+}
 
-	if err := cfg.SaveTo(g.iniFile); err != nil {
+func (g *CsvTableGroup) save() error {
+	// the ini file is written with the first table
+	if len(g.tableDefs) == 0 && !pathExist(g.iniFile) {
+		return nil
+	}
+
+	lock, err := lockFile(g.iniFile, true, g.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	cfg, err := g.iniConfig()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(g.iniFile, 0640, func(w io.Writer) error {
+		_, err := cfg.WriteTo(w)
 		return errors.WithStack(err)
+	}); err != nil {
+		return err
 	}
 
 	if _, err := os.Stat(g.dataDir); os.IsNotExist(err) {
@@ -167,7 +298,14 @@ func (g *CsvTableGroup) Drop() error {
 			return errors.WithStack(err)
 		}
 	}
-	return nil
+	return removeLockFile(g.iniFile)
+}
+
+// SetLockTimeout() sets the lock timeout of the ini file
+// and of tables got from the group afterwards.
+// See CsvTable.SetLockTimeout()
+func (g *CsvTableGroup) SetLockTimeout(timeout time.Duration) {
+	g.lockTimeout = timeout
 }
 
 func (g *CsvTableGroup) TableExists(tableName string) bool {
@@ -189,7 +327,7 @@ func (g *CsvTableGroup) GetTable(tableName string) (*CsvTable, error) {
 		return nil, err
 	}
 	if td, ok := g.tableDefs[tableName]; ok {
-		return newCsvTable(g.groupName, tableName, td.path, g.columns, g.useGzip, g.bufferSize), nil
+		return g.newTable(tableName, td.path), nil
 	} else {
 		return g.CreateTable(tableName)
 	}
@@ -200,11 +338,11 @@ func (g *CsvTableGroup) CreateTable(tableName string) (*CsvTable, error) {
 	if _, ok := g.tableDefs[tableName]; ok {
 		return nil, errors.New(fmt.Sprintf("The table %s exists", tableName))
 	}
-	t := newCsvTable(g.groupName, tableName, g.getTablePath(tableName),
-		g.columns, g.useGzip, g.bufferSize)
+	t := g.newTable(tableName, g.getTablePath(tableName))
 
 	g.tableDefs[tableName] = t.CsvTableDef
 	if err := g.save(); err != nil {
+		delete(g.tableDefs, tableName)
 		return nil, err
 	}
 	return t, nil
@@ -217,14 +355,321 @@ func (g *CsvTableGroup) CreateTableIfNotExists(tableName string) (*CsvTable, err
 	return g.CreateTable(tableName)
 }
 
-func (g *CsvTableGroup) Count(conditionCheckFunc func([]string) bool) int {
-	cnt := 0
-	for tableName := range g.tableDefs {
-		tb, err := g.GetTable(tableName)
+func (g *CsvTableGroup) Count(condition interface{}) int {
+	_, st, err := g.aggregate1(condition, AggSpec{Func: CAggCount})
+	if err != nil {
+		return -1
+	}
+	return int(st.cnt)
+}
+
+// newTable() returns a table with the settings of the group
+func (g *CsvTableGroup) newTable(tableName, path string) *CsvTable {
+	t := newCsvTable(g.groupName, tableName, path,
+		g.columns, g.columnTypes, g.codec, g.bufferSize)
+	t.codecLevel = g.codecLevel
+	t.lockTimeout = g.lockTimeout
+	t.hasHeader = g.hasHeader
+	t.format = g.format
+	t.nullValue = g.nullValue
+	t.setPrimaryKey(g.primaryKey, g.onDuplicate)
+	t.parallelism = g.parallelism
+	if g.chunkBytes > 0 {
+		t.chunkBytes = g.chunkBytes
+	}
+	return t
+}
+
+// newScanIter() returns an iterator over rows of files at paths
+func (g *CsvTableGroup) newScanIter(paths []string) *multiFileIter {
+	it := newMultiFileIter(paths, g.lockTimeout)
+	if g.hasHeader {
+		it.header = g.columns
+	}
+	it.format = g.format
+	return it
+}
+
+// SetHeader() makes table files of the group start with a header row
+// of the column names. It can be set only before tables are created.
+func (g *CsvTableGroup) SetHeader(hasHeader bool) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the header mode can be set only before tables are created")
+	}
+	g.hasHeader = hasHeader
+	return nil
+}
+
+// SetFormat() sets the delimiter, the comment character, the quoting
+// and the line terminator of table files of the group.
+// It can be set only before tables are created.
+func (g *CsvTableGroup) SetFormat(format CsvFormat) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the format can be set only before tables are created")
+	}
+	if err := format.validate(); err != nil {
+		return err
+	}
+	g.format = format
+	return nil
+}
+
+/*
+SetNull() sets the string stored for NULL in table files of the group
+such as \N. By default NULL is stored as an empty value, and empty
+values of string columns are read out as empty strings though they
+match IsNull(). Empty values of other typed columns are always NULL.
+It can be set only before tables are created.
+*/
+func (g *CsvTableGroup) SetNull(nullValue string) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the NULL string can be set only before tables are created")
+	}
+	if strings.ContainsAny(nullValue, "\r\n") {
+		return errors.New("the NULL string cannot have line breaks")
+	}
+	g.nullValue = nullValue
+	return nil
+}
+
+/*
+SetCodec() sets the compression codec of table files of the group
+and its level. level 0 is the default level of the codec.
+Table files get the extension of the codec.
+It can be set only before tables are created.
+*/
+func (g *CsvTableGroup) SetCodec(codec string, level int) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the codec can be set only before tables are created")
+	}
+	c, err := getCodec(codec)
+	if err != nil {
+		return err
+	}
+	if c.NewWriter != nil {
+		// the codec checks the level
+		zw, err := c.NewWriter(io.Discard, level)
 		if err != nil {
-			return -1
+			return err
+		}
+		zw.Close()
+	} else if level != 0 {
+		return errors.Errorf("codec %s has no level", c.Name)
+	}
+	g.codec = c.Name
+	g.codecLevel = level
+	return nil
+}
+
+// Format() returns the CSV dialect of table files of the group
+func (g *CsvTableGroup) Format() CsvFormat {
+	return g.format
+}
+
+func (g *CsvTableGroup) getColMap() map[string]int {
+	return newColMap(g.columns)
+}
+
+// getTablePaths() returns paths of tables matching the table filter
+// sorted by table name
+func (g *CsvTableGroup) getTablePaths() []string {
+	tableNames := g.tableNames()
+	paths := make([]string, len(tableNames))
+	for i, tableName := range tableNames {
+		paths[i] = g.tableDefs[tableName].path
+	}
+	return paths
+}
+
+// Aggregate() computes aggs over the tables of the group in 1 scan.
+// See CsvTable.Aggregate()
+func (g *CsvTableGroup) Aggregate(condition interface{},
+	groupBy []string, aggs ...AggSpec) (*CsvRows, error) {
+	a, err := newAggregation(g.columns, g.columnTypes, g.getColMap(), groupBy, aggs, g.nullValue)
+	if err != nil {
+		return nil, err
+	}
+	p, err := g.planScan(condition)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := p.aggregate(getParallelism(g.parallelism, condition), a)
+	if err != nil {
+		return nil, err
+	}
+	return a.result(groups)
+}
+
+// aggregate1() returns the state of agg over rows matching condition
+func (g *CsvTableGroup) aggregate1(condition interface{}, agg AggSpec) (*aggDef, *aggState, error) {
+	a, err := newAggregation(g.columns, g.columnTypes, g.getColMap(), nil, []AggSpec{agg}, g.nullValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := g.planScan(condition)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := p.aggregate1(getParallelism(g.parallelism, condition), a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.defs[0], st, nil
+}
+
+/*
+FilterTables() returns the group restricted to tables whose names match
+pattern in the syntax of filepath.Match such as sales_202610*.
+Queries, updates and deletes of the returned group see only those tables.
+It shares the tables and the settings with g, and the pattern replaces
+the one of g if any.
+*/
+func (g *CsvTableGroup) FilterTables(pattern string) (*CsvTableGroup, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "table name pattern %s", pattern)
+	}
+	fg := new(CsvTableGroup)
+	*fg = *g
+	fg.tableFilter = pattern
+	return fg, nil
+}
+
+// tableNames() returns names of tables matching the table filter sorted by name
+func (g *CsvTableGroup) tableNames() []string {
+	tableNames := make([]string, 0, len(g.tableDefs))
+	for tableName := range g.tableDefs {
+		if g.tableFilter != "" {
+			if ok, _ := filepath.Match(g.tableFilter, tableName); !ok {
+				continue
+			}
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+// tables() returns tables of the group which may match condition
+// in the order of the table names
+func (g *CsvTableGroup) tables(condition interface{}) []*CsvTable {
+	tables := make([]*CsvTable, 0, len(g.tableDefs))
+	for _, tableName := range g.tableNames() {
+		if g.partitionMayMatch(tableName, condition) {
+			tables = append(tables, g.newTable(tableName, g.tableDefs[tableName].path))
+		}
+	}
+	return tables
+}
+
+// scanIter() returns an iterator over rows matching condition
+// of the tables of the group. See CsvTable.scanIter()
+func (g *CsvTableGroup) scanIter(condition interface{}) (*groupIter, error) {
+	if _, err := toMatcher(condition, g.getColMap(), g.columnTypes, g.nullValue); err != nil {
+		return nil, err
+	}
+	return newGroupIter(g.tables(condition), condition), nil
+}
+
+// SelectRows() returns rows matching condition of the tables of the group
+// in the order of the table names. CsvRows.TableName() tells the table
+// of each row. See CsvTable.SelectRows()
+func (g *CsvTableGroup) SelectRows(condition interface{},
+	colNames []string) (*CsvRows, error) {
+	it, err := g.scanIter(condition)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newCsvRowsFromIter(it, g.columns, g.columnTypes, colNames)
+	if err != nil {
+		return nil, err
+	}
+	r.tmpDir = g.dataDir
+	r.nullValue = g.nullValue
+	return r, nil
+}
+
+// Sum() sets the sum of column over the tables of the group to s.
+// See CsvTable.Sum()
+func (g *CsvTableGroup) Sum(condition interface{},
+	column string, s interface{}) error {
+	if _, err := sumColumn(g.getColMap(), g.columnTypes, column); err != nil {
+		return err
+	}
+	d, st, err := g.aggregate1(condition, AggSpec{Func: CAggSum, Column: column})
+	if err != nil {
+		return err
+	}
+	return setSum(d, st, s)
+}
+
+func (g *CsvTableGroup) Max(condition interface{},
+	field string, v interface{}) error {
+	return g.minmax(condition, true, field, v)
+}
+
+func (g *CsvTableGroup) Min(condition interface{},
+	field string, v interface{}) error {
+	return g.minmax(condition, false, field, v)
+}
+
+func (g *CsvTableGroup) minmax(condition interface{},
+	isMax bool, field string, v interface{}) error {
+	if _, ok := g.getColMap()[field]; !ok {
+		return errors.New(fmt.Sprintf("Column %s does not exist", field))
+	}
+	fn := CAggMin
+	if isMax {
+		fn = CAggMax
+	}
+	_, st, err := g.aggregate1(condition, AggSpec{Func: fn, Column: field})
+	if err != nil {
+		return err
+	}
+	return setMinMax(st, v)
+}
+
+/*
+Update() updates rows matching condition in the tables of the group.
+The tables are changed together by a transaction, so either all of them
+or none are updated. The partition column cannot be updated as rows
+would be left in tables of other periods. See CsvTable.Update()
+*/
+func (g *CsvTableGroup) Update(condition interface{},
+	updates map[string]interface{}) error {
+	if _, ok := updates[g.partitionColumn]; ok && g.partitionColumn != "" {
+		return errors.Errorf("partition column %s cannot be updated", g.partitionColumn)
+	}
+	tx, err := newCsvTx(g.rootDir)
+	if err != nil {
+		return err
+	}
+	for _, t := range g.tables(condition) {
+		if !pathExist(t.path) {
+			continue
+		}
+		if err := tx.Update(t, condition, updates); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete() deletes rows matching condition from the tables of the group
+// by a transaction. See Update()
+func (g *CsvTableGroup) Delete(condition interface{}) error {
+	tx, err := newCsvTx(g.rootDir)
+	if err != nil {
+		return err
+	}
+	for _, t := range g.tables(condition) {
+		if !pathExist(t.path) {
+			continue
+		}
+		if err := tx.Delete(t, condition); err != nil {
+			tx.Rollback()
+			return err
 		}
-		cnt += tb.Count(conditionCheckFunc)
 	}
-	return cnt
+	return tx.Commit()
 }
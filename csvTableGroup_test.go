@@ -0,0 +1,179 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGroupQuery(t *testing.T) {
+	rootDir, err := ensureTestDir("TestGroupQuery")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	g, err := db.CreateTypedGroup("logs", []string{"id", "host", "bytes"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeInt64}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	id := 0
+	for _, tableName := range []string{"web_b", "web_a", "db_a"} {
+		tb, err := g.CreateTable(tableName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			id++
+			if err := tb.InsertRow(nil, id, tableName, id*100); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	// an index is used within its table
+	tb, err := g.GetTable("web_a")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.CreateIndex("id"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	selectRows := func(g *CsvTableGroup, condition interface{}) string {
+		r, err := g.SelectRows(condition, []string{"id"})
+		if err != nil {
+			return err.Error()
+		}
+		defer r.Close()
+		rows := []string{}
+		for r.Next() {
+			id := 0
+			if err := r.Scan(&id); err != nil {
+				return err.Error()
+			}
+			rows = append(rows, fmt.Sprintf("%s:%d", r.TableName(), id))
+		}
+		if err := r.Err(); err != nil {
+			return err.Error()
+		}
+		return strings.Join(rows, " ")
+	}
+
+	if err := getGotExpErr("all", selectRows(g, Ge("bytes", 300)),
+		"db_a:7 db_a:8 db_a:9 web_a:4 web_a:5 web_a:6 web_b:3"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("indexed", selectRows(g, In("id", 5, 8)),
+		"db_a:8 web_a:5"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	web, err := g.FilterTables("web_*")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("filtered", selectRows(web, Le("id", 4)),
+		"web_a:4 web_b:1 web_b:2 web_b:3"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("filtered count", web.Count(nil), 6); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := g.FilterTables("web_["); err == nil {
+		t.Errorf("a bad pattern must fail")
+		return
+	}
+
+	var sum int64
+	if err := web.Sum(Gt("id", 1), "bytes", &sum); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("sum", sum, int64(2000)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.Sum(nil, "host", &sum); err == nil {
+		t.Errorf("a string column must not be summed")
+		return
+	}
+	host := ""
+	if err := g.Max(nil, "host", &host); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("max", host, "web_b"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	maxID := 0
+	if err := web.Max(nil, "id", &maxID); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("filtered max", maxID, 6); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.Min(Gt("id", 3), "host", &host); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("min", host, "db_a"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if err := web.Update(Eq("bytes", 500), map[string]interface{}{"host": "moved"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.Delete(In("id", 1, 9)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("updated", selectRows(g, Eq("host", "moved")), "web_a:5"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("deleted", g.Count(nil), 7); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := web.Update(nil, map[string]interface{}{"nohost": "x"}); err == nil {
+		t.Errorf("an unknown column must fail")
+		return
+	}
+	if err := getGotExpErr("not updated", g.Count(Eq("host", "x")), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
@@ -1,9 +1,13 @@
 package csvdb
 
 import (
+	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestCsvTable1(t *testing.T) {
@@ -563,8 +567,10 @@ func TestCsvTableOrderBy(t *testing.T) {
 		return
 	}
 
-	tb, err := db.CreateTable(name,
-		[]string{"id", "name", "int1", "float2"}, false, bufferSize)
+	tb, err := db.CreateTypedTable(name,
+		[]string{"id", "name", "int1", "float2"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeInt64, CColTypeFloat64},
+		false, bufferSize)
 	if err != nil {
 		t.Errorf("%v", err)
 		return
@@ -594,8 +600,7 @@ func TestCsvTableOrderBy(t *testing.T) {
 		t.Errorf("%v", err)
 		return
 	}
-	if err := got.OrderBy([]string{"int1", "float2"},
-		[]string{"int", "float32"}, CorderByAsc); err != nil {
+	if err := got.OrderBy([]string{"int1", "float2"}, CorderByAsc); err != nil {
 		t.Errorf("%v", err)
 		return
 	}
@@ -636,8 +641,7 @@ func TestCsvTableOrderBy(t *testing.T) {
 		t.Errorf("%v", err)
 		return
 	}
-	if err := got.OrderBy([]string{"int1", "float2"},
-		[]string{"int", "float32"}, CorderByDesc); err != nil {
+	if err := got.OrderBy([]string{"int1", "float2"}, CorderByDesc); err != nil {
 		t.Errorf("%v", err)
 		return
 	}
@@ -673,3 +677,860 @@ func TestCsvTableOrderBy(t *testing.T) {
 		i++
 	}
 }
+
+func TestCsvTableTyped(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCsvTableTyped")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	name := "typed"
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if _, err := db.CreateTypedTable("badtype",
+		[]string{"id"}, []string{"decimal"}, false, 0); err == nil {
+		t.Errorf("unknown type must be rejected")
+		return
+	}
+
+	tb, err := db.CreateTypedTable(name,
+		[]string{"id", "name", "price", "valid", "ts"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64, CColTypeBool, CColTypeTimestamp},
+		false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := [][]interface{}{
+		{1, "b", 1.5, true, ts},
+		{2, "c", 2.5, false, ts.Add(time.Hour)},
+		{10, "a", 3, true, "2026-01-01T00:00:00Z"},
+	}
+	for _, row := range rows {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.InsertRow([]string{"id"}, "x"); err == nil {
+		t.Errorf("non integer id must be rejected")
+		return
+	}
+	if err := tb.InsertRow([]string{"ts"}, "yesterday"); err == nil {
+		t.Errorf("non timestamp ts must be rejected")
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if err := tb.Update(nil, map[string]interface{}{"price": "cheap"}); err == nil {
+		t.Errorf("non float price must be rejected")
+		return
+	}
+
+	var sum int64
+	if err := tb.Sum(nil, "id", &sum); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("sum", sum, int64(13)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Sum(nil, "name", &sum); err == nil {
+		t.Errorf("string column must not be summed")
+		return
+	}
+
+	var id int
+	if err := tb.Max(nil, "id", &id); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("max id", id, 10); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var minName string
+	if err := tb.Min(nil, "name", &minName); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("min name", minName, "a"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var maxTs time.Time
+	if err := tb.Max(nil, "ts", &maxTs); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("max ts", maxTs.Equal(ts.Add(time.Hour)), true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	db, err = NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err = db.GetTable(name)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("loaded type", tb.columnTypes[3], CColTypeBool); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	r, err := tb.SelectRows(nil, []string{"id", "price", "valid"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := r.OrderBy([]string{"name"}, CorderByAsc); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.Next()
+	var vid, vprice, vvalid interface{}
+	if err := r.Scan(&vid, &vprice, &vvalid); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("scanned id", vid, int64(10)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("scanned price", vprice, 3.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("scanned valid", vvalid, true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestCsvTableCond(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCsvTableCond")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	name := "cond"
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := db.CreateTypedTable(name,
+		[]string{"id", "name", "price"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64},
+		false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	rows := [][]interface{}{
+		{1, "apple", 1.5},
+		{2, "banana", 10},
+		{3, "cherry", 2.25},
+		{10, "apricot", 9.5},
+	}
+	for _, row := range rows {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	counts := []struct {
+		title string
+		cond  *Cond
+		exp   int
+	}{
+		{"eq", Eq("id", 10), 1},
+		{"ne", Ne("name", "apple"), 3},
+		{"lt numeric", Lt("id", 3), 2},
+		{"gt float", Gt("price", 2.25), 2},
+		{"between", Between("id", 2, 10), 3},
+		{"in", In("name", "apple", "cherry", "durian"), 2},
+		{"like", Like("name", "ap%"), 2},
+		{"like single", Like("name", "_pple"), 1},
+		{"and", And(Like("name", "ap%"), Ge("price", 5)), 1},
+		{"or", Or(Eq("id", 1), Eq("id", 2)), 2},
+		{"not", Not(Eq("id", 1)), 3},
+		{"is null", IsNull("name"), 0},
+		{"nil", nil, 4},
+	}
+	for _, c := range counts {
+		if err := getGotExpErr(c.title, tb.Count(c.cond), c.exp); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := getGotExpErr("unknown column", tb.Count(Eq("nothing", 1)), -1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if err := tb.Update(Eq("name", "banana"),
+		map[string]interface{}{"price": 11}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var price float64
+	if err := tb.Select1Row(Eq("id", 2), []string{"price"}, &price); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("updated price", price, 11.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if err := tb.Delete(Like("name", "ap%")); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count after delete", tb.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// empty strings of a group without a NULL string are not NULL
+	plain, err := db.CreateTable("plain", []string{"id", "name"}, false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{1, "a"}, {2, ""}, {3, "b"}} {
+		if err := plain.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := plain.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			if err := plain.CreateIndex("name"); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		for _, c := range []struct {
+			title string
+			cond  *Cond
+			exp   int
+		}{
+			{"eq empty", Eq("name", ""), 1},
+			{"ne", Ne("name", "b"), 2},
+			{"in empty", In("name", "", "a"), 2},
+			{"lt", Lt("name", "a"), 1},
+			{"is null", IsNull("name"), 0},
+		} {
+			title := fmt.Sprintf("%s indexed=%v", c.title, indexed)
+			if err := getGotExpErr(title, plain.Count(c.cond), c.exp); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+	}
+	r, err := db.Query("SELECT id FROM plain WHERE name = ''")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	ids := []int{}
+	for r.Next() {
+		var id int
+		if err := r.Scan(&id); err != nil {
+			r.Close()
+			t.Errorf("%v", err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	r.Close()
+	if err := getGotExpErr("sql empty", fmt.Sprint(ids), "[2]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// comparisons with NULL are errors rather than matching empty strings
+	for _, cond := range []*Cond{Eq("name", nil), Ne("name", nil), Lt("name", nil),
+		In("name", "a", nil), Between("name", nil, "b"), Not(Eq("name", nil))} {
+		if _, err := plain.SelectRows(cond, nil); err == nil {
+			t.Errorf("%s NULL must fail", cond.op)
+			return
+		}
+	}
+	for _, query := range []string{
+		"SELECT id FROM plain WHERE name = NULL",
+		"SELECT id FROM plain WHERE name <> NULL",
+		"SELECT id FROM plain WHERE name IN ('a', NULL)",
+	} {
+		if r, err := db.Query(query); err == nil {
+			r.Close()
+			t.Errorf("%s must fail", query)
+			return
+		}
+	}
+	if _, err := db.Exec("DELETE FROM plain WHERE name = ?", nil); err == nil {
+		t.Errorf("a NULL parameter of = must fail")
+		return
+	}
+	if err := getGotExpErr("not deleted", plain.Count(nil), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestCsvTableHeader(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCsvTableHeader")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateGroup("header", []string{"id", "name"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetHeader(true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err := g.CreateTable("header_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetHeader(false); err == nil {
+		t.Errorf("SetHeader() after creating tables must fail")
+		return
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := tb.InsertRow(nil, i, fmt.Sprintf("name%d", i)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, 4, "name4"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Delete(func(v []string) bool { return v[0] == "1" }); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	readFile := func(path string) ([][]string, error) {
+		reader, err := newCsvReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.close()
+		rows := [][]string{}
+		for reader.next() {
+			rows = append(rows, reader.values)
+		}
+		return rows, reader.lastErr()
+	}
+	rows, err := readFile(tb.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("rows in the file", len(rows), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("header", fmt.Sprint(rows[0]), "[id name]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", tb.Count(nil), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var maxID int
+	if err := tb.Max(nil, "id", &maxID); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("max", maxID, 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the header mode is kept in the ini file
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb2, err := db2.getTable("header", "header_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count after reload", tb2.Count(nil), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a headered file opened without the ini file
+	ot, err := OpenCsvTable(tb.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("opened columns", fmt.Sprint(ot.columns), "[id name]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := ot.InsertRow([]string{"name", "id"}, "name5", 5); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := ot.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var name string
+	if err := ot.Select1Row(func(v []string) bool { return v[0] == "5" },
+		[]string{"name"}, &name); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("opened name", name, "name5"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a header not matching the columns
+	mismatch := newCsvTable("header", "header_1", tb.path,
+		[]string{"id", "title"}, []string{"", ""}, CCodecNone, 10)
+	mismatch.hasHeader = true
+	if err := getGotExpErr("count of mismatch", mismatch.Count(nil), -1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err := mismatch.SelectRows(nil, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if r.Next() || r.Err() == nil {
+		t.Errorf("reading a mismatched header must fail")
+		return
+	}
+	r.Close()
+
+	// an empty table gets the header by a transaction
+	tb3, err := g.CreateTable("header_2")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.InsertRow(tb3, nil, 1, "name1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	rows, err = readFile(tb3.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("rows by tx", fmt.Sprint(rows), "[[id name] [1 name1]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("group count", g.Count(nil), 5); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestCsvTableFormat(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCsvTableFormat")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateGroup("piped", []string{"id", "name"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, format := range []CsvFormat{
+		{Delimiter: '"'},
+		{Delimiter: '\n'},
+		{Comment: ','},
+		{Delimiter: ';', Comment: ';'},
+	} {
+		if err := g.SetFormat(format); err == nil {
+			t.Errorf("SetFormat(%+v) must fail", format)
+			return
+		}
+	}
+	format := CsvFormat{Delimiter: '|', Comment: '#', TrimLeadingSpace: true, UseCRLF: true}
+	if err := g.SetFormat(format); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err := g.CreateTable("piped_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetFormat(CsvFormat{}); err == nil {
+		t.Errorf("SetFormat() after creating tables must fail")
+		return
+	}
+
+	if err := tb.InsertRow(nil, 1, "a,b"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, 2, "c|d"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Update(func(v []string) bool { return v[0] == "1" },
+		map[string]interface{}{"name": "e"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.InsertRow(tb, nil, 3, "f"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got, err := os.ReadFile(tb.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("file", string(got), "1|e\r\n2|\"c|d\"\r\n3|f\r\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// comment lines and leading spaces written by hand
+	if err := os.WriteFile(tb.path, append([]byte("# note\r\n"), append(got, " 4| g\r\n"...)...), 0644); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the format is kept in the ini file
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g2, err := db2.GetGroup("piped")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("format after reload", g2.Format(), format); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	rows, err := db2.Query("SELECT name FROM piped WHERE id >= 2")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		names = append(names, name)
+	}
+	if err := getGotExpErr("names", fmt.Sprint(names), "[c|d f g]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a file of another dialect opened without the ini file
+	path := filepath.Join(rootDir, "semicolon.csv")
+	if err := os.WriteFile(path, []byte("id;name\n1;x\n"), 0644); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	ot, err := OpenCsvTableWithFormat(path, CsvFormat{Delimiter: ';'})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("opened columns", fmt.Sprint(ot.columns), "[id name]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("opened count", ot.Count(nil), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestCsvTableNull(t *testing.T) {
+	rootDir, err := ensureTestDir("TestCsvTableNull")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateTypedGroup("nulls", []string{"id", "name", "price"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetNull("a\nb"); err == nil {
+		t.Errorf("a NULL string with a line break must fail")
+		return
+	}
+	if err := g.SetNull(`\N`); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err := g.CreateTable("nulls_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{
+		{2, "", 3.5},
+		{3, nil, sql.NullFloat64{}},
+		{4, sql.NullString{String: "x", Valid: true}, 1.5},
+	} {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.InsertRow([]string{"id"}, 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got, err := os.ReadFile(tb.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("file", string(got), "2,,3.5\n3,\\N,\\N\n4,x,1.5\n1,\\N,\\N\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	for _, c := range []struct {
+		title string
+		cond  *Cond
+		exp   int
+	}{
+		{"name is null", IsNull("name"), 2},
+		{"name is not null", IsNotNull("name"), 2},
+		{"empty name", Eq("name", ""), 1},
+		{"name is not x", Ne("name", "x"), 1},
+		{"price < 10", Lt("price", 10), 2},
+		{"price in", In("price", 1.5, 3.5), 2},
+	} {
+		if err := getGotExpErr(c.title, tb.Count(c.cond), c.exp); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	var sum float64
+	if err := tb.Sum(nil, "price", &sum); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("sum", sum, 5.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var minPrice float64
+	if err := tb.Min(nil, "price", &minPrice); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("min", minPrice, 1.5); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err := tb.Aggregate(nil, nil, AggSpec{Func: CAggCount, Column: "price"},
+		AggSpec{Func: CAggAvg, Column: "price"}, AggSpec{Func: CAggMax, Column: "name"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var cnt int
+	var avg float64
+	var maxName string
+	r.Next()
+	if err := r.Scan(&cnt, &avg, &maxName); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.Close()
+	if err := getGotExpErr("aggregates", fmt.Sprintf("%d %v %s", cnt, avg, maxName), "2 2.5 x"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = tb.Aggregate(IsNull("price"), nil, AggSpec{Func: CAggSum, Column: "price"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var nullSum sql.NullFloat64
+	r.Next()
+	if err := r.Scan(&nullSum); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.Close()
+	if nullSum.Valid {
+		t.Errorf("sum of no values must be NULL")
+		return
+	}
+
+	if err := tb.Update(Eq("id", 2), map[string]interface{}{"price": nil}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the NULL string is kept in the ini file
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = db2.Query("SELECT id, name, price FROM nulls WHERE price IS NULL ORDER BY id")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	res := []string{}
+	for r.Next() {
+		var id int
+		var name sql.NullString
+		var price interface{}
+		if err := r.Scan(&id, &name, &price); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		res = append(res, fmt.Sprintf("%d:%v:%q:%v", id, name.Valid, name.String, price))
+	}
+	if err := getGotExpErr("null rows", fmt.Sprint(res),
+		`[1:false:"":<nil> 2:true:"":<nil> 3:false:"":<nil>]`); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
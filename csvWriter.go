@@ -1,43 +1,67 @@
 package csvdb
 
 import (
-	"compress/gzip"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// newCsvWriter() opens path compressing it by the codec of its extension
 func newCsvWriter(path, writeMode string) (*CsvWriter, error) {
-	ext := filepath.Ext(path)
+	return openCsvWriter(path, writeMode, codecByExt(path), 0)
+}
+
+// openCsvWriter() opens path compressing it by codec at level
+func openCsvWriter(path, writeMode string, codec *Codec, level int) (*CsvWriter, error) {
+	if codec.Name != CCodecNone && codec.NewWriter == nil {
+		return nil, errors.Errorf("codec %s cannot write", codec.Name)
+	}
 	var fw *os.File
-	var zw *gzip.Writer
-	var writer *csv.Writer
-	mode := ""
 
-	flags := 0
+	// a rewrite goes to a temporary file renamed over path by commit()
+	tmpPath := ""
+	var err error
 	switch writeMode {
 	case CWriteModeWrite:
-		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		fw, err = newTempFileFor(path)
+		if err != nil {
+			return nil, err
+		}
+		tmpPath = fw.Name()
 	default:
-		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		fw, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
-
-	fw, err := os.OpenFile(path, flags, 0644)
+	closeFile := func() {
+		fw.Close()
+		if tmpPath != "" {
+			os.Remove(tmpPath)
+		}
+	}
+	st, err := fw.Stat()
 	if err != nil {
+		closeFile()
 		return nil, errors.WithStack(err)
 	}
 
-	if ext == ".gz" || ext == ".gzip" {
-		zw = gzip.NewWriter(fw)
+	// appending adds a new compressed stream after the existing ones
+	var zw io.WriteCloser
+	var writer *csv.Writer
+	if codec.NewWriter != nil {
+		zw, err = codec.NewWriter(fw, level)
+		if err != nil {
+			closeFile()
+			return nil, err
+		}
 		writer = csv.NewWriter(zw)
-		mode = cRModeGZip
 	} else {
 		writer = csv.NewWriter(fw)
-		mode = cRModePlain
 	}
 
 	c := new(CsvWriter)
@@ -45,7 +69,9 @@ func newCsvWriter(path, writeMode string) (*CsvWriter, error) {
 	c.writer = writer
 	c.fw = fw
 	c.zw = zw
-	c.mode = mode
+	c.mode = codec.Name
+	c.tmpPath = tmpPath
+	c.isNew = st.Size() == 0
 
 	return c, nil
 }
@@ -58,16 +84,68 @@ func (c *CsvWriter) write(record []string) error {
 	return nil
 }
 
-func (c *CsvWriter) flush() {
+// setFormat() sets the CSV dialect to write
+func (c *CsvWriter) setFormat(format CsvFormat) {
+	format.applyWriter(c.writer)
+}
+
+// out() returns the writer under the csv writer
+// for formats other than CSV
+func (c *CsvWriter) out() io.Writer {
+	if c.zw != nil {
+		return c.zw
+	}
+	return c.fw
+}
+
+// writeHeader() writes the header row if the file has nothing yet
+func (c *CsvWriter) writeHeader(columns []string) error {
+	if !c.isNew {
+		return nil
+	}
+	c.isNew = false
+	return c.write(columns)
+}
+
+// commit() flushes and closes the file.
+// A rewrite is synced and replaces the original file only here.
+func (c *CsvWriter) commit() error {
 	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		c.close()
+		return errors.WithStack(err)
+	}
+	if c.zw != nil {
+		err := c.zw.Close()
+		c.zw = nil
+		if err != nil {
+			c.close()
+			return errors.WithStack(err)
+		}
+	}
+	fw := c.fw
+	c.fw = nil
+	if c.tmpPath == "" {
+		return errors.WithStack(fw.Close())
+	}
+	c.tmpPath = ""
+	return commitTempFile(fw, c.path, 0644)
 }
 
+// close() closes the file. An uncommitted rewrite is discarded
 func (c *CsvWriter) close() {
 	if c.zw != nil {
 		c.zw.Close()
+		c.zw = nil
 	}
 
 	if c.fw != nil {
 		c.fw.Close()
+		c.fw = nil
+	}
+
+	if c.tmpPath != "" {
+		os.Remove(c.tmpPath)
+		c.tmpPath = ""
 	}
 }
@@ -0,0 +1,226 @@
+package csvdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CDriverName is the name to pass to sql.Open().
+// The data source name is the base directory of the CsvDB.
+const CDriverName = "csvdb"
+
+func init() {
+	sql.Register(CDriverName, newCsvDriver())
+}
+
+// csvDriver shares 1 CsvDB among connections to the same base directory
+type csvDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*driverDB
+}
+
+type driverDB struct {
+	mu sync.Mutex
+	db *CsvDB
+}
+
+type driverConn struct {
+	ddb *driverDB
+	// tx is the transaction in progress
+	tx *CsvTx
+}
+
+// driverTx commits or rolls back the CsvTx of a connection
+type driverTx struct {
+	conn *driverConn
+}
+
+type driverStmt struct {
+	conn    *driverConn
+	query   string
+	nParams int
+}
+
+type driverResult struct {
+	rowsAffected int64
+}
+
+type driverRows struct {
+	rows     *CsvRows
+	colTypes []string
+}
+
+func newCsvDriver() *csvDriver {
+	d := new(csvDriver)
+	d.dbs = make(map[string]*driverDB)
+	return d
+}
+
+func (d *csvDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ddb, ok := d.dbs[dsn]
+	if !ok {
+		db, err := NewCsvDB(dsn)
+		if err != nil {
+			return nil, err
+		}
+		ddb = new(driverDB)
+		ddb.db = db
+		d.dbs[dsn] = ddb
+	}
+	c := new(driverConn)
+	c.ddb = ddb
+	return c, nil
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	_, nParams, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	s := new(driverStmt)
+	s.conn = c
+	s.query = query
+	s.nParams = nParams
+	return s, nil
+}
+
+func (c *driverConn) Close() error {
+	return nil
+}
+
+// Begin() starts a CsvTx. INSERT, UPDATE and DELETE statements are
+// recorded in it until Commit(). Queries do not see them. See CsvDB.Begin()
+func (c *driverConn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("a transaction is already in progress")
+	}
+	c.ddb.mu.Lock()
+	defer c.ddb.mu.Unlock()
+	tx, err := c.ddb.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	dtx := new(driverTx)
+	dtx.conn = c
+	return dtx, nil
+}
+
+func (tx *driverTx) Commit() error {
+	c := tx.conn
+	if c.tx == nil {
+		return errors.New("the transaction is already committed or rolled back")
+	}
+	c.ddb.mu.Lock()
+	defer c.ddb.mu.Unlock()
+	err := c.tx.Commit()
+	c.tx = nil
+	return err
+}
+
+func (tx *driverTx) Rollback() error {
+	c := tx.conn
+	if c.tx == nil {
+		return errors.New("the transaction is already committed or rolled back")
+	}
+	err := c.tx.Rollback()
+	c.tx = nil
+	return err
+}
+
+func toArgs(values []driver.Value) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func (s *driverStmt) Close() error {
+	return nil
+}
+
+func (s *driverStmt) NumInput() int {
+	return s.nParams
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.ddb.mu.Lock()
+	defer s.conn.ddb.mu.Unlock()
+	var n int64
+	var err error
+	if s.conn.tx != nil {
+		n, err = s.conn.ddb.db.execTx(s.conn.tx, s.query, toArgs(args)...)
+	} else {
+		n, err = s.conn.ddb.db.Exec(s.query, toArgs(args)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := new(driverResult)
+	res.rowsAffected = n
+	return res, nil
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.ddb.mu.Lock()
+	defer s.conn.ddb.mu.Unlock()
+	rows, err := s.conn.ddb.db.Query(s.query, toArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	r := new(driverRows)
+	r.rows = rows
+	r.colTypes = rows.ColumnTypes()
+	return r, nil
+}
+
+func (res *driverResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported")
+}
+
+func (res *driverResult) RowsAffected() (int64, error) {
+	return res.rowsAffected, nil
+}
+
+func (r *driverRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r *driverRows) Close() error {
+	r.rows.Close()
+	return nil
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	values := make([]interface{}, len(dest))
+	args := make([]interface{}, len(dest))
+	for i := range values {
+		args[i] = &values[i]
+	}
+	if err := r.rows.Scan(args...); err != nil {
+		return err
+	}
+	// NULLs are scanned as nil
+	for i, v := range values {
+		dest[i] = v
+	}
+	return nil
+}
+
+func (r *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(r.colTypes[index])
+}
@@ -0,0 +1,200 @@
+package csvdb
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestDriver(t *testing.T) {
+	rootDir, err := ensureTestDir("TestDriver")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := sql.Open(CDriverName, rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("DROP TABLE IF EXISTS users"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
+id INTEGER,
+name VARCHAR(20),
+score REAL,
+joined TIMESTAMP
+)`); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	joined := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	stmt, err := db.Prepare("INSERT INTO users (id, name, score, joined) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		if _, err := stmt.Exec(i+1, name, float64(i)*1.5, joined.AddDate(0, 0, i)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	stmt.Close()
+
+	res, err := db.Exec("UPDATE users SET score = ? WHERE name = ?", 10, "bob")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("rows affected", n, int64(1)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	var name string
+	var score float64
+	var ts time.Time
+	if err := db.QueryRow("SELECT name, score, joined FROM users WHERE id = ?", 2).Scan(
+		&name, &score, &ts); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("name", name, "bob"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("score", score, 10.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("joined", ts.Equal(joined.AddDate(0, 0, 1)), true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	rows, err := db.Query("SELECT id FROM users ORDER BY score DESC")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("ids", len(ids), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("first id", ids[0], 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	var cnt int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&cnt); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", cnt, 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// transactions
+	for _, commit := range []bool{false, true} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if _, err := tx.Exec("INSERT INTO users (id, name) VALUES (?, ?)", 4, "dave"); err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		res, err := tx.Exec("UPDATE users SET score = 0 WHERE id <= ?", 2)
+		if err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 2 {
+			tx.Rollback()
+			t.Errorf("rows affected in the transaction got=%d err=%v", n, err)
+			return
+		}
+		// rows inserted in the transaction are counted
+		if res, err = tx.Exec("DELETE FROM users WHERE id >= ?", 3); err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 2 {
+			tx.Rollback()
+			t.Errorf("rows deleted in the transaction got=%d err=%v", n, err)
+			return
+		}
+		if res, err = tx.Exec("UPDATE users SET score = 1 WHERE score = 0 OR id = 4"); err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 2 {
+			tx.Rollback()
+			t.Errorf("rows updated after the delete got=%d err=%v", n, err)
+			return
+		}
+		if _, err := tx.Exec("DROP TABLE users"); err == nil {
+			tx.Rollback()
+			t.Errorf("DROP TABLE in a transaction must fail")
+			return
+		}
+		if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&cnt); err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("count before commit", cnt, 3); err != nil {
+			tx.Rollback()
+			t.Errorf("%v", err)
+			return
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE score = 1 OR id >= 3").Scan(&cnt); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count after commit", cnt, 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
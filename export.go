@@ -0,0 +1,303 @@
+package csvdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ExportOptions are options of Export()
+type ExportOptions struct {
+	// CExportJSONL, CExportTSV, CExportMarkdown or CExportFixedWidth
+	Format string
+	// NoHeader omits the header row of TSV and fixed-width text
+	NoHeader bool
+	// Widths are the widths of the columns of fixed-width text.
+	// If nil, the rows are read in memory to find the widths.
+	Widths []int
+	// Gzip compresses the output file. Paths ending with .gz or .gzip
+	// are compressed anyway
+	Gzip bool
+}
+
+// rowExporter writes rows in an export format
+type rowExporter interface {
+	writeHeader(columns []string) error
+	writeRow(values []string) error
+}
+
+type jsonlExporter struct {
+	w         *bufio.Writer
+	columns   []string
+	colTypes  []string
+	nullValue string
+}
+
+type tsvExporter struct {
+	w *bufio.Writer
+}
+
+type markdownExporter struct {
+	w *bufio.Writer
+}
+
+type fixedWidthExporter struct {
+	w      *bufio.Writer
+	widths []int
+}
+
+// Export() writes the rows left to path in opts.Format and closes the rows.
+// It returns the number of rows written.
+func (r *CsvRows) Export(path string, opts *ExportOptions) (int64, error) {
+	defer r.Close()
+	if opts == nil {
+		return 0, errors.New("no export options")
+	}
+	codec := codecByExt(path)
+	if opts.Gzip {
+		gz, err := getCodec(CCodecGzip)
+		if err != nil {
+			return 0, err
+		}
+		codec = gz
+	}
+	writer, err := openCsvWriter(path, CWriteModeWrite, codec, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.close()
+	n, err := r.ExportTo(writer.out(), opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := writer.commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ExportTo() streams the rows left to w in opts.Format.
+// It returns the number of rows written.
+func (r *CsvRows) ExportTo(w io.Writer, opts *ExportOptions) (int64, error) {
+	if opts == nil {
+		return 0, errors.New("no export options")
+	}
+	bw := bufio.NewWriter(w)
+	columns := r.Columns()
+	var e rowExporter
+	switch opts.Format {
+	case CExportJSONL:
+		e = &jsonlExporter{bw, columns, r.ColumnTypes(), r.nullValue}
+	case CExportTSV:
+		e = &tsvExporter{bw}
+	case CExportMarkdown:
+		e = &markdownExporter{bw}
+	case CExportFixedWidth:
+		widths := opts.Widths
+		if widths == nil {
+			return r.exportFixedWidthInMemory(bw, opts.NoHeader)
+		}
+		if len(widths) != len(columns) {
+			return 0, errors.Errorf("%d widths for %d columns", len(widths), len(columns))
+		}
+		e = &fixedWidthExporter{bw, widths}
+	default:
+		return 0, errors.Errorf("unknown export format %s", opts.Format)
+	}
+
+	// the header of markdown is a part of the table
+	if !opts.NoHeader || opts.Format == CExportMarkdown {
+		if err := e.writeHeader(columns); err != nil {
+			return 0, err
+		}
+	}
+	n := int64(0)
+	for r.Next() {
+		if err := e.writeRow(r.values()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := r.Err(); err != nil {
+		return n, err
+	}
+	return n, errors.WithStack(bw.Flush())
+}
+
+// exportFixedWidthInMemory() finds the widths from all rows before writing them
+func (r *CsvRows) exportFixedWidthInMemory(bw *bufio.Writer, noHeader bool) (int64, error) {
+	columns := r.Columns()
+	widths := make([]int, len(columns))
+	if !noHeader {
+		for i, col := range columns {
+			widths[i] = utf8.RuneCountInString(col)
+		}
+	}
+	rows := make([][]string, 0)
+	for r.Next() {
+		values := r.values()
+		for i, v := range values {
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+		}
+		rows = append(rows, values)
+	}
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	e := &fixedWidthExporter{bw, widths}
+	if !noHeader {
+		if err := e.writeHeader(columns); err != nil {
+			return 0, err
+		}
+	}
+	for _, values := range rows {
+		if err := e.writeRow(values); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(rows)), errors.WithStack(bw.Flush())
+}
+
+// values() returns the values of the current row Scan() would set
+func (r *CsvRows) values() []string {
+	v := r.iter.current()
+	if len(r.selectedColIndexes) == 0 {
+		return v
+	}
+	values := make([]string, len(r.selectedColIndexes))
+	for i, colidx := range r.selectedColIndexes {
+		values[i] = v[colidx]
+	}
+	return values
+}
+
+// Export() writes all rows of the table to path. See CsvRows.Export()
+func (t *CsvTable) Export(path string, opts *ExportOptions) (int64, error) {
+	r, err := t.SelectRows(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	return r.Export(path, opts)
+}
+
+// Export() writes all rows of the tables of the group to path
+// in the order of the table names. See CsvRows.Export()
+func (g *CsvTableGroup) Export(path string, opts *ExportOptions) (int64, error) {
+	r, err := newCsvRowsFromIter(g.newScanIter(g.getTablePaths()),
+		g.columns, g.columnTypes, nil)
+	if err != nil {
+		return 0, err
+	}
+	r.nullValue = g.nullValue
+	return r.Export(path, opts)
+}
+
+func (e *jsonlExporter) writeHeader(columns []string) error {
+	return nil
+}
+
+// writeRow() writes values of typed columns as JSON numbers, booleans or null
+func (e *jsonlExporter) writeRow(values []string) error {
+	e.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		key, err := json.Marshal(e.columns[i])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		e.w.Write(key)
+		e.w.WriteByte(':')
+		e.w.Write(jsonValue(e.colTypes[i], v, e.nullValue))
+	}
+	_, err := e.w.WriteString("}\n")
+	return errors.WithStack(err)
+}
+
+func jsonValue(colType, v, nullValue string) []byte {
+	if isNullOutput(nullValue, colType, v) {
+		return []byte("null")
+	}
+	var val interface{} = v
+	if colType != cColTypeUntyped && colType != CColTypeString {
+		if typed, err := parseTyped(colType, v); err == nil {
+			val = typed
+		}
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		// NaN and Inf are not JSON numbers
+		b, _ = json.Marshal(v)
+	}
+	return b
+}
+
+// tsvEscaper escapes characters which cannot be in TSV fields
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func (e *tsvExporter) writeHeader(columns []string) error {
+	return e.writeRow(columns)
+}
+
+func (e *tsvExporter) writeRow(values []string) error {
+	for i, v := range values {
+		if i > 0 {
+			e.w.WriteByte('\t')
+		}
+		tsvEscaper.WriteString(e.w, v)
+	}
+	return errors.WithStack(e.w.WriteByte('\n'))
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func (e *markdownExporter) writeHeader(columns []string) error {
+	if err := e.writeRow(columns); err != nil {
+		return err
+	}
+	e.w.WriteByte('|')
+	for range columns {
+		e.w.WriteString(" --- |")
+	}
+	return errors.WithStack(e.w.WriteByte('\n'))
+}
+
+func (e *markdownExporter) writeRow(values []string) error {
+	e.w.WriteByte('|')
+	for _, v := range values {
+		e.w.WriteByte(' ')
+		markdownEscaper.WriteString(e.w, v)
+		e.w.WriteString(" |")
+	}
+	return errors.WithStack(e.w.WriteByte('\n'))
+}
+
+func (e *fixedWidthExporter) writeHeader(columns []string) error {
+	return e.writeRow(columns)
+}
+
+// writeRow() pads values with spaces. Columns are separated by a space
+func (e *fixedWidthExporter) writeRow(values []string) error {
+	for i, v := range values {
+		n := utf8.RuneCountInString(v)
+		if n > e.widths[i] || strings.ContainsAny(v, "\r\n") {
+			return errors.New(fmt.Sprintf("value %q does not fit in width %d", v, e.widths[i]))
+		}
+		if i > 0 {
+			e.w.WriteByte(' ')
+		}
+		e.w.WriteString(v)
+		if i < len(values)-1 {
+			e.w.WriteString(strings.Repeat(" ", e.widths[i]-n))
+		}
+	}
+	return errors.WithStack(e.w.WriteByte('\n'))
+}
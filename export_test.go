@@ -0,0 +1,172 @@
+package csvdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	rootDir, err := ensureTestDir("TestExport")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateTypedGroup("goods", []string{"id", "name", "price", "onSale"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64, CColTypeBool}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	rows := map[string][][]interface{}{
+		"goods_1": {{1, "apple", 1.5, true}, {2, "tab\tand|pipe", "", false}},
+		"goods_2": {{3, "melon", 10, false}},
+	}
+	var tb *CsvTable
+	for _, tableName := range []string{"goods_1", "goods_2"} {
+		tb, err = g.CreateTable(tableName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for _, row := range rows[tableName] {
+			if err := tb.InsertRow(nil, row...); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	tb, err = g.GetTable("goods_1")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	export := func(opts *ExportOptions) (string, error) {
+		r, err := tb.SelectRows(nil, nil)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		var buf bytes.Buffer
+		if _, err := r.ExportTo(&buf, opts); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	for _, c := range []struct {
+		opts *ExportOptions
+		exp  string
+	}{
+		{&ExportOptions{Format: CExportJSONL},
+			`{"id":1,"name":"apple","price":1.5,"onSale":true}` + "\n" +
+				`{"id":2,"name":"tab\tand|pipe","price":null,"onSale":false}` + "\n"},
+		{&ExportOptions{Format: CExportTSV},
+			"id\tname\tprice\tonSale\n1\tapple\t1.5\t1\n2\ttab\\tand|pipe\t\t0\n"},
+		{&ExportOptions{Format: CExportMarkdown, NoHeader: true},
+			"| id | name | price | onSale |\n| --- | --- | --- | --- |\n" +
+				"| 1 | apple | 1.5 | 1 |\n| 2 | tab\tand\\|pipe |  | 0 |\n"},
+		{&ExportOptions{Format: CExportFixedWidth, NoHeader: true, Widths: []int{2, 14, 5, 1}},
+			"1  apple          1.5   1\n2  tab\tand|pipe         0\n"},
+		{&ExportOptions{Format: CExportFixedWidth},
+			"id name         price onSale\n1  apple        1.5   1\n2  tab\tand|pipe       0\n"},
+	} {
+		got, err := export(c.opts)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(c.opts.Format, got, c.exp); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if _, err := export(&ExportOptions{Format: CExportFixedWidth, Widths: []int{1, 1, 1, 1}}); err == nil {
+		t.Errorf("values longer than widths must fail")
+		return
+	}
+	if _, err := export(&ExportOptions{Format: "xml"}); err == nil {
+		t.Errorf("an unknown format must fail")
+		return
+	}
+
+	// a filtered result
+	r, err := db.Query("SELECT name, price FROM goods WHERE price >= 1.5 ORDER BY price DESC")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	outPath := filepath.Join(rootDir, "query.jsonl")
+	n, err := r.Export(outPath, &ExportOptions{Format: CExportJSONL})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("exported rows", n, int64(2)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("query jsonl", string(got),
+		`{"name":"melon","price":10}`+"\n"+`{"name":"apple","price":1.5}`+"\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a whole group compressed
+	outPath = filepath.Join(rootDir, "goods.tsv")
+	n, err = g.Export(outPath, &ExportOptions{Format: CExportTSV, NoHeader: true, Gzip: true})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("group rows", n, int64(3)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	f, err := os.Open(outPath)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got, err = io.ReadAll(zr)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("group tsv", string(got),
+		"1\tapple\t1.5\t1\n2\ttab\\tand|pipe\t\t0\n3\tmelon\t10\t0\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
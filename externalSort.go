@@ -0,0 +1,228 @@
+package csvdb
+
+import (
+	"container/heap"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// externalSorter sorts rows in memory up to memLimit bytes.
+// Beyond that, sorted runs are written to temporary files in tmpDir
+// and merged when the rows are read.
+type externalSorter struct {
+	cmp      *rowComparator
+	tmpDir   string
+	memLimit int64
+	memUsed  int64
+	rows     [][]string
+	runs     []string
+}
+
+// mergeIter merges sorted run files
+type mergeIter struct {
+	cmp     *rowComparator
+	paths   []string
+	readers []*CsvReader
+	h       mergeHeap
+	cur     []string
+	started bool
+	err     error
+}
+
+type mergeItem struct {
+	row []string
+	src int
+}
+
+type mergeHeap struct {
+	items []mergeItem
+	cmp   *rowComparator
+}
+
+func newExternalSorter(cmp *rowComparator, tmpDir string, memLimit int64) *externalSorter {
+	s := new(externalSorter)
+	s.cmp = cmp
+	s.tmpDir = tmpDir
+	s.memLimit = memLimit
+	if s.memLimit <= 0 {
+		s.memLimit = cDefaultSortMemory
+	}
+	s.rows = make([][]string, 0)
+	return s
+}
+
+// rowMemSize() estimates the memory a row takes
+func rowMemSize(row []string) int64 {
+	n := int64(24 + 16*len(row))
+	for _, v := range row {
+		n += int64(len(v))
+	}
+	return n
+}
+
+func (s *externalSorter) add(row []string) error {
+	s.rows = append(s.rows, row)
+	s.memUsed += rowMemSize(row)
+	if s.memUsed >= s.memLimit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill() writes the sorted rows in memory to a run file
+func (s *externalSorter) spill() error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	sort.Stable(orderBuffRows{s.rows, s.cmp})
+
+	if s.tmpDir == "" {
+		s.tmpDir = os.TempDir()
+	}
+	f, err := os.CreateTemp(s.tmpDir, ".sort-*.csv")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	path := f.Name()
+	f.Close()
+	s.runs = append(s.runs, path)
+
+	writer, err := newCsvWriter(path, CWriteModeAppend)
+	if err != nil {
+		return err
+	}
+	defer writer.close()
+	for _, row := range s.rows {
+		if err := writer.write(row); err != nil {
+			return err
+		}
+	}
+	if err := writer.commit(); err != nil {
+		return err
+	}
+	s.rows = make([][]string, 0)
+	s.memUsed = 0
+	return nil
+}
+
+// finish() returns an iterator over all added rows in sorted order
+func (s *externalSorter) finish() (rowIterator, error) {
+	if len(s.runs) == 0 {
+		sort.Stable(orderBuffRows{s.rows, s.cmp})
+		return newBufferIter(s.rows), nil
+	}
+	if err := s.spill(); err != nil {
+		s.cleanup()
+		return nil, err
+	}
+	it := new(mergeIter)
+	it.cmp = s.cmp
+	it.paths = s.runs
+	it.readers = make([]*CsvReader, len(s.runs))
+	for i, path := range s.runs {
+		reader, err := newCsvReader(path)
+		if err != nil {
+			it.close()
+			return nil, err
+		}
+		it.readers[i] = reader
+	}
+	s.runs = nil
+	return it, nil
+}
+
+// cleanup() removes run files
+func (s *externalSorter) cleanup() {
+	for _, path := range s.runs {
+		os.Remove(path)
+	}
+	s.runs = nil
+	s.rows = nil
+}
+
+func (it *mergeIter) push(src int) bool {
+	reader := it.readers[src]
+	if reader.next() {
+		heap.Push(&it.h, mergeItem{reader.values, src})
+		return true
+	}
+	if err := reader.lastErr(); err != nil {
+		it.err = err
+	}
+	return false
+}
+
+func (it *mergeIter) next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		it.h.cmp = it.cmp
+		for i := range it.readers {
+			if !it.push(i) && it.err != nil {
+				return false
+			}
+		}
+	}
+	if it.h.Len() == 0 {
+		it.close()
+		return false
+	}
+	item := heap.Pop(&it.h).(mergeItem)
+	it.cur = item.row
+	it.push(item.src)
+	return true
+}
+
+func (it *mergeIter) current() []string {
+	return it.cur
+}
+
+func (it *mergeIter) lastErr() error {
+	return it.err
+}
+
+func (it *mergeIter) close() {
+	for _, reader := range it.readers {
+		if reader != nil {
+			reader.close()
+		}
+	}
+	it.readers = nil
+	for _, path := range it.paths {
+		os.Remove(path)
+	}
+	it.paths = nil
+	it.h.items = nil
+}
+
+func (h mergeHeap) Len() int {
+	return len(h.items)
+}
+
+// Less() keeps rows of earlier runs first so that the merge is stable
+func (h mergeHeap) Less(i, j int) bool {
+	c := h.cmp.compare(h.items[i].row, h.items[j].row)
+	if c == 0 {
+		return h.items[i].src < h.items[j].src
+	}
+	return c < 0
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
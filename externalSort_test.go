@@ -0,0 +1,105 @@
+package csvdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestExternalSort(t *testing.T) {
+	rootDir, err := ensureTestDir("TestExternalSort")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := db.CreateTypedTable("extsort",
+		[]string{"id", "grp"},
+		[]string{CColTypeInt64, CColTypeInt64},
+		true, 100)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	n := 1000
+	for i := 0; i < n; i++ {
+		if err := tb.InsertRow(nil, (i*7919)%n, i%10); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	checkSorted := func(title string, r *CsvRows) error {
+		defer r.Close()
+		i := 0
+		prevGrp := -1
+		prevID := -1
+		for r.Next() {
+			var id, grp int
+			if err := r.Scan(&id, &grp); err != nil {
+				return err
+			}
+			if grp < prevGrp || (grp == prevGrp && id < prevID) {
+				return fmt.Errorf("%s: row %d (%d,%d) is out of order", title, i, grp, id)
+			}
+			prevGrp = grp
+			prevID = id
+			i++
+		}
+		if err := r.Err(); err != nil {
+			return err
+		}
+		return getGotExpErr(title+" rows", i, n)
+	}
+
+	r, err := tb.SelectRows(nil, []string{"id", "grp"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.SetSortMemory(2000)
+	if err := r.OrderBy([]string{"grp", "id"}, CorderByAsc); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, ok := r.iter.(*mergeIter); !ok {
+		t.Errorf("rows were not spilled to disk")
+		return
+	}
+	if err := checkSorted("spilled", r); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	db.SetSortMemory(5000)
+	r, err = db.Query("SELECT id, grp FROM extsort ORDER BY grp, id")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := checkSorted("sql", r); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	runs, _ := filepath.Glob(filepath.Join(filepath.Dir(tb.path), ".sort-*"))
+	if err := getGotExpErr("remaining runs", len(runs), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
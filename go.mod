@@ -4,7 +4,10 @@ go 1.16
 
 require (
 	github.com/go-ini/ini v1.62.0
+	github.com/klauspost/compress v1.15.15
+	github.com/pierrec/lz4/v4 v4.1.17
 	github.com/pkg/errors v0.9.1
 	github.com/smartystreets/goconvey v1.6.4 // indirect
+	golang.org/x/text v0.13.0
 	gopkg.in/ini.v1 v1.62.0 // indirect
 )
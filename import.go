@@ -0,0 +1,289 @@
+package csvdb
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImportOptions are options of CsvTable.Import()
+type ImportOptions struct {
+	// field delimiter of the source. ',' if 0
+	Delimiter rune
+	// lines starting with Comment are skipped if it is not 0
+	Comment          rune
+	LazyQuotes       bool
+	TrimLeadingSpace bool
+	// NoHeader means the source has no header row and
+	// its fields are in the order of the table columns
+	NoHeader bool
+	// ColumnMap maps source header names to table columns.
+	// Source columns not in ColumnMap are imported to the table column
+	// of the same name, or ignored if there is none.
+	ColumnMap map[string]string
+	// Gzip decompresses the source. Sources compressed by registered
+	// codecs are detected by their first bytes anyway
+	Gzip bool
+	// malformed rows are written to RejectPath. They are skipped if it is ""
+	RejectPath string
+}
+
+// ImportResult reports row counts of CsvTable.Import()
+type ImportResult struct {
+	// data rows read from the source
+	Read int64
+	// rows appended to the table
+	Imported int64
+	// malformed rows and rows of duplicate primary keys
+	// skipped or written to the reject file
+	Rejected int64
+}
+
+const utf8BOM = "\ufeff"
+
+/*
+Import() appends rows of the CSV file at path to the table.
+
+Source columns are mapped to the table columns by the header row.
+Rows which cannot be parsed, have a wrong number of fields or
+have values not matching the column types are rejected.
+Tables with a primary key also reject rows of keys already stored or
+imported, or replace the rows of the keys in the replace mode.
+The reject file is a CSV of the record number in the source,
+the error and the source fields. The number of fields varies by row.
+
+Rows are appended only if the whole source is read without I/O errors.
+*/
+func (t *CsvTable) Import(path string, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = new(ImportOptions)
+	}
+	fr, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer fr.Close()
+	var src io.Reader
+	if opts.Gzip {
+		zr, err := gzip.NewReader(fr)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer zr.Close()
+		src = zr
+	} else {
+		var zr io.Closer
+		src, zr, _, err = newDecompressor(fr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "file %s", path)
+		}
+		if zr != nil {
+			defer zr.Close()
+		}
+	}
+	reader := csv.NewReader(src)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	reader.Comment = opts.Comment
+	reader.LazyQuotes = opts.LazyQuotes
+	reader.TrimLeadingSpace = opts.TrimLeadingSpace
+	reader.FieldsPerRecord = -1
+
+	// srcIdxs[i] is the table column of the source field i or -1
+	var srcIdxs []int
+	if opts.NoHeader {
+		srcIdxs = make([]int, len(t.columns))
+		for i := range t.columns {
+			srcIdxs[i] = i
+		}
+	} else {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil, errors.Errorf("%s has no header row", path)
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if srcIdxs, err = t.importColumns(header, opts.ColumnMap); err != nil {
+			return nil, errors.Wrapf(err, "header of %s", path)
+		}
+	}
+
+	var rejects *CsvWriter
+	if opts.RejectPath != "" {
+		if rejects, err = newCsvWriter(opts.RejectPath, CWriteModeWrite); err != nil {
+			return nil, err
+		}
+		defer rejects.close()
+	}
+
+	lock, err := t.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	orgSize := int64(0)
+	if st, err := os.Stat(t.path); err == nil {
+		orgSize = st.Size()
+	}
+	var keys map[string]bool
+	isReplaced := false
+	if len(t.keyIdxs) > 0 {
+		if keys, err = t.storedKeys(nil); err != nil {
+			return nil, err
+		}
+	}
+	writer, err := t.openW(CWriteModeAppend)
+	if err != nil {
+		return nil, err
+	}
+	defer writer.close()
+	// appended rows are removed on errors
+	isDone := false
+	defer func() {
+		if !isDone {
+			writer.close()
+			os.Truncate(t.path, orgSize)
+		}
+	}()
+
+	res := new(ImportResult)
+	recNo := 0
+	if !opts.NoHeader {
+		recNo = 1
+	}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		recNo++
+		var rowErr error
+		var row []string
+		if perr, ok := err.(*csv.ParseError); ok {
+			rowErr = perr
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		} else {
+			row, rowErr = t.importRow(fields, srcIdxs)
+		}
+		if rowErr == nil && keys != nil {
+			key, err := t.keyOf(row)
+			if err != nil {
+				rowErr = err
+			} else if keys[key] && t.onDuplicate != CDupReplace {
+				rowErr = errors.Wrapf(ErrDuplicateKey, "key (%s)",
+					strings.Replace(key, "\x00", ",", -1))
+			} else {
+				isReplaced = isReplaced || keys[key]
+				keys[key] = true
+			}
+		}
+		res.Read++
+
+		if rowErr != nil {
+			res.Rejected++
+			if rejects != nil {
+				if err := rejects.write(append([]string{strconv.Itoa(recNo), rowErr.Error()},
+					fields...)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := writer.write(row); err != nil {
+			return nil, err
+		}
+		res.Imported++
+	}
+
+	if err := writer.commit(); err != nil {
+		return nil, err
+	}
+	if isReplaced {
+		if err := t.rewriteUnique(); err != nil {
+			return nil, err
+		}
+	}
+	if rejects != nil {
+		if err := rejects.commit(); err != nil {
+			return nil, err
+		}
+	}
+	isDone = true
+	return res, nil
+}
+
+// importColumns() returns the table column of each source column
+func (t *CsvTable) importColumns(header []string,
+	columnMap map[string]string) ([]int, error) {
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], utf8BOM)
+	}
+	srcIdxs := make([]int, len(header))
+	mapped := make(map[int]string)
+	for i, name := range header {
+		col := name
+		if mcol, ok := columnMap[name]; ok {
+			col = mcol
+		}
+		idx, ok := t.colMap[col]
+		if !ok {
+			if _, isMapped := columnMap[name]; isMapped {
+				return nil, errors.New(fmt.Sprintf("column %s mapped from %s does not exist", col, name))
+			}
+			srcIdxs[i] = -1
+			continue
+		}
+		if src, ok := mapped[idx]; ok {
+			return nil, errors.Errorf("both %s and %s are mapped to column %s", src, name, col)
+		}
+		mapped[idx] = name
+		srcIdxs[i] = idx
+	}
+	for name := range columnMap {
+		found := false
+		for _, h := range header {
+			if h == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("mapped column %s is not in the header", name)
+		}
+	}
+	if len(mapped) == 0 {
+		return nil, errors.New("no column is mapped to the table")
+	}
+	return srcIdxs, nil
+}
+
+// importRow() returns a table row of the source fields
+func (t *CsvTable) importRow(fields []string, srcIdxs []int) ([]string, error) {
+	if len(fields) != len(srcIdxs) {
+		return nil, errors.Errorf("%d fields while expected %d", len(fields), len(srcIdxs))
+	}
+	// columns not in the source are NULL
+	row := make([]string, len(t.columns))
+	for i := range row {
+		row[i] = t.nullValue
+	}
+	for i, idx := range srcIdxs {
+		if idx < 0 {
+			continue
+		}
+		s, err := t.typedString(idx, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		row[idx] = s
+	}
+	return row, nil
+}
@@ -0,0 +1,144 @@
+package csvdb
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	rootDir, err := ensureTestDir("TestImport")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := db.CreateTypedTable("items", []string{"id", "name", "price"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	srcDir := filepath.Join(rootDir, "src")
+	if err := ensureDir(srcDir); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	vendor := filepath.Join(srcDir, "vendor.csv")
+	if err := os.WriteFile(vendor, []byte(utf8BOM+"price;product;id;note\n"+
+		"# a comment\n"+
+		"1.5;apple;1;fresh\n"+
+		"2;\"orange; large\";2;\n"+
+		"3;grape;3\n"+
+		"4;melon;x;\n"+
+		"5;ba\"nana;5;\n"), 0644); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	rejectPath := filepath.Join(srcDir, "vendor.reject.csv")
+	res, err := tb.Import(vendor, &ImportOptions{
+		Delimiter:  ';',
+		Comment:    '#',
+		ColumnMap:  map[string]string{"product": "name"},
+		RejectPath: rejectPath,
+	})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("result", fmt.Sprint(*res), "{5 2 3}"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	var name string
+	if err := tb.Select1Row(Eq("id", 2), []string{"name"}, &name); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("quoted name", name, "orange; large"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	reader, err := newCsvReader(rejectPath)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	reader.reader.FieldsPerRecord = -1
+	recNos := ""
+	for reader.next() {
+		recNos += reader.values[0] + " "
+	}
+	reader.close()
+	if err := getGotExpErr("rejected records", recNos, "4 5 6 "); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the same file with lazy quotes
+	res, err = tb.Import(vendor, &ImportOptions{
+		Delimiter:  ';',
+		Comment:    '#',
+		LazyQuotes: true,
+		ColumnMap:  map[string]string{"product": "name"},
+	})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("lazy imported", res.Imported, int64(3)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// gzip TSV without header
+	tsv := filepath.Join(srcDir, "items.tsv.gz")
+	f, err := os.Create(tsv)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte("10\tkiwi\t0.5\n11\tlemon\t0.7\n"))
+	zw.Close()
+	f.Close()
+	res, err = tb.Import(tsv, &ImportOptions{Delimiter: '\t', NoHeader: true})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("tsv imported", res.Imported, int64(2)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", tb.Count(nil), 7); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if _, err := tb.Import(vendor, &ImportOptions{Delimiter: ';',
+		ColumnMap: map[string]string{"product": "title"}}); err == nil {
+		t.Errorf("a map to a missing column must fail")
+		return
+	}
+	if _, err := tb.Import(vendor, &ImportOptions{Delimiter: ';',
+		ColumnMap: map[string]string{"product": "id"}}); err == nil {
+		t.Errorf("mapping 2 source columns to 1 column must fail")
+		return
+	}
+}
@@ -0,0 +1,731 @@
+package csvdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Secondary indexes are sorted sidecar files next to the table file.
+Each entry is a value of the column and the position of its row.
+The position of plain files is the byte offset of the row.
+Compressed streams cannot be seeked into, so the position of gzip files
+is the offset of the gzip stream of the row and the row number in it.
+Every flush appends a new gzip stream, so streams work as blocks.
+
+Flush() and Update() keep the indexes up to date. Other writes such as
+transactions and imports make them stale, and they are rebuilt when used.
+An index remembers the size and the modification time of the table file
+it was built from to tell it is stale.
+*/
+
+// indexEntry is the position of a row of value
+type indexEntry struct {
+	value  string
+	offset int64
+	row    int
+}
+
+type tableIndex struct {
+	column  string
+	colType string
+	size    int64
+	mtime   int64
+	entries []*indexEntry
+}
+
+// indexRange is a range of values of an indexed column a condition matches
+type indexRange struct {
+	column string
+	// points are the values of = and IN
+	points  []string
+	from    string
+	to      string
+	hasFrom bool
+	hasTo   bool
+	// the bounds are exclusive
+	fromOpen bool
+	toOpen   bool
+}
+
+/*
+CreateIndex() creates the index of column in a sidecar file and
+builds it from the table file. SelectRows() and Select1Row() use it
+for conditions of =, IN, <, <=, >, >= and Between on column, alone or
+in And(). Range conditions use the index only on typed columns.
+Tables compressed by codecs other than gzip cannot be indexed.
+*/
+func (t *CsvTable) CreateIndex(column string) error {
+	if _, ok := t.colMap[column]; !ok {
+		return errors.Errorf("column %s does not exist", column)
+	}
+	if t.codec != CCodecNone && t.codec != CCodecGzip {
+		return errors.Errorf("tables of codec %s cannot be indexed", t.codec)
+	}
+	lock, err := t.lock(false)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	_, err = t.buildIndex(column)
+	return err
+}
+
+// DropIndex() removes the index of column
+func (t *CsvTable) DropIndex(column string) error {
+	path := t.indexPath(column)
+	if !pathExist(path) {
+		return nil
+	}
+	return errors.WithStack(os.Remove(path))
+}
+
+// indexPath() returns the path of the sidecar file of the index of column
+func (t *CsvTable) indexPath(column string) string {
+	return t.path + "." + url.PathEscape(column) + ".idx"
+}
+
+// indexColumns() returns the columns having indexes
+func (t *CsvTable) indexColumns() []string {
+	files, err := os.ReadDir(filepath.Dir(t.path))
+	if err != nil {
+		return nil
+	}
+	prefix := filepath.Base(t.path) + "."
+	columns := make([]string, 0)
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".idx") {
+			continue
+		}
+		column, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".idx"))
+		if err != nil {
+			continue
+		}
+		if _, ok := t.colMap[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// dropIndexes() removes all indexes of the table
+func (t *CsvTable) dropIndexes() error {
+	for _, column := range t.indexColumns() {
+		if err := t.DropIndex(column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIndex() builds the index of column from the whole table file.
+// The caller must hold the lock
+func (t *CsvTable) buildIndex(column string) (*tableIndex, error) {
+	idx := t.newIndex(column)
+	st, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return idx, idx.save(t.indexPath(column))
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	entries, err := t.scanIndexEntries(column, 0)
+	if err != nil {
+		return nil, err
+	}
+	idx.entries = entries
+	idx.sort()
+	idx.size = st.Size()
+	idx.mtime = st.ModTime().UnixNano()
+	return idx, idx.save(t.indexPath(column))
+}
+
+func (t *CsvTable) newIndex(column string) *tableIndex {
+	idx := new(tableIndex)
+	idx.column = column
+	idx.colType = t.columnTypes[t.colMap[column]]
+	idx.entries = make([]*indexEntry, 0)
+	return idx
+}
+
+// getIndex() returns the index of column, rebuilding it if it is stale.
+// The caller must hold the lock
+func (t *CsvTable) getIndex(column string) (*tableIndex, error) {
+	idx, err := t.loadIndex(column)
+	if err != nil {
+		return nil, err
+	}
+	if idx != nil && idx.isValid(t.path) {
+		return idx, nil
+	}
+	return t.buildIndex(column)
+}
+
+// loadIndex() reads the index of column. nil if it is broken
+func (t *CsvTable) loadIndex(column string) (*tableIndex, error) {
+	f, err := os.Open(t.indexPath(column))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(bufio.NewReader(f))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil || len(header) != 3 {
+		return nil, nil
+	}
+	idx := t.newIndex(column)
+	if idx.size, err = strconv.ParseInt(header[1], 10, 64); err != nil {
+		return nil, nil
+	}
+	if idx.mtime, err = strconv.ParseInt(header[2], 10, 64); err != nil {
+		return nil, nil
+	}
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(rec) != 3 {
+			return nil, nil
+		}
+		e := new(indexEntry)
+		e.value = rec[0]
+		if e.offset, err = strconv.ParseInt(rec[1], 10, 64); err != nil {
+			return nil, nil
+		}
+		if e.row, err = strconv.Atoi(rec[2]); err != nil {
+			return nil, nil
+		}
+		idx.entries = append(idx.entries, e)
+	}
+	return idx, nil
+}
+
+// isValid() checks the index is built from the current table file at path
+func (idx *tableIndex) isValid(path string) bool {
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return idx.size == 0 && len(idx.entries) == 0
+	}
+	return err == nil && st.Size() == idx.size && st.ModTime().UnixNano() == idx.mtime
+}
+
+func (idx *tableIndex) save(path string) error {
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{idx.column,
+			strconv.FormatInt(idx.size, 10), strconv.FormatInt(idx.mtime, 10)})
+		for _, e := range idx.entries {
+			writer.Write([]string{e.value,
+				strconv.FormatInt(e.offset, 10), strconv.Itoa(e.row)})
+		}
+		writer.Flush()
+		return errors.WithStack(writer.Error())
+	})
+}
+
+func (idx *tableIndex) sort() {
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		a, b := idx.entries[i], idx.entries[j]
+		if c := indexCompare(idx.colType, a.value, b.value); c != 0 {
+			return c < 0
+		}
+		if a.offset != b.offset {
+			return a.offset < b.offset
+		}
+		return a.row < b.row
+	})
+}
+
+/*
+indexCompare() compares values like compareTyped().
+Untyped values are numbers or strings by value, so numbers are put
+before strings to sort them in an order. The order differs from
+compareTyped() between a number and a string, thus ranges on
+untyped columns are not looked up by indexes.
+*/
+func indexCompare(colType, a, b string) int {
+	if colType != cColTypeUntyped {
+		return compareTyped(colType, a, b)
+	}
+	f1, err1 := strconv.ParseFloat(a, 64)
+	f2, err2 := strconv.ParseFloat(b, 64)
+	switch {
+	case err1 == nil && err2 == nil:
+		return compareFloat64(f1, f2)
+	case err1 == nil:
+		return -1
+	case err2 == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// updateIndexes() updates indexes of columns after a write in wmode.
+// orgSt is the table file before the write. Appended rows are added
+// to indexes valid before the write and other indexes are rebuilt.
+// Errors are ignored as stale indexes are rebuilt when they are used.
+// The caller must hold the exclusive lock
+func (t *CsvTable) updateIndexes(columns []string, wmode string, orgSt os.FileInfo) {
+	for _, column := range columns {
+		if wmode == CWriteModeAppend && orgSt != nil && orgSt.Size() > 0 {
+			if idx, err := t.loadIndex(column); err == nil && idx != nil &&
+				idx.size == orgSt.Size() && idx.mtime == orgSt.ModTime().UnixNano() {
+				if t.appendIndex(idx) == nil {
+					continue
+				}
+			}
+		}
+		t.buildIndex(column)
+	}
+}
+
+// appendIndex() adds rows appended after the rows idx has to it
+func (t *CsvTable) appendIndex(idx *tableIndex) error {
+	st, err := os.Stat(t.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	entries, err := t.scanIndexEntries(idx.column, idx.size)
+	if err != nil {
+		return err
+	}
+	idx.entries = append(idx.entries, entries...)
+	idx.sort()
+	idx.size = st.Size()
+	idx.mtime = st.ModTime().UnixNano()
+	return idx.save(t.indexPath(idx.column))
+}
+
+// scanIndexEntries() returns index entries of column of rows
+// from the byte offset start of the table file. NULLs are not indexed
+func (t *CsvTable) scanIndexEntries(column string, start int64) ([]*indexEntry, error) {
+	colIdx := t.colMap[column]
+	colType := t.columnTypes[colIdx]
+	entries := make([]*indexEntry, 0)
+	isHeader := t.hasHeader && start == 0
+	err := t.scanPositions(start, func(record []string, offset int64, row int) {
+		if isHeader {
+			isHeader = false
+			return
+		}
+		if colIdx >= len(record) || isNullOutput(t.nullValue, colType, record[colIdx]) {
+			return
+		}
+		e := new(indexEntry)
+		e.value = record[colIdx]
+		e.offset = offset
+		e.row = row
+		entries = append(entries, e)
+	})
+	return entries, err
+}
+
+// scanPositions() calls f with each record from the byte offset start
+// of the table file and its position
+func (t *CsvTable) scanPositions(start int64, f func([]string, int64, int)) error {
+	fr, err := os.Open(t.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer fr.Close()
+	if _, err := fr.Seek(start, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if t.codec == CCodecNone {
+		s := newRecordScanner(bufio.NewReader(fr), t.format, start)
+		for {
+			record, offset, err := s.next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			f(record, offset, 0)
+		}
+	}
+	if t.codec != CCodecGzip {
+		return errors.Errorf("tables of codec %s cannot be indexed", t.codec)
+	}
+
+	// gzip streams are read one by one to know their offsets
+	cr := &countingReader{r: bufio.NewReader(fr), n: start}
+	block := cr.n
+	zr, err := gzip.NewReader(cr)
+	for err == nil {
+		zr.Multistream(false)
+		s := newRecordScanner(bufio.NewReader(zr), t.format, 0)
+		for row := 0; ; row++ {
+			record, _, err := s.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			f(record, block, row)
+		}
+		block = cr.n
+		err = zr.Reset(cr)
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// countingReader counts bytes read. It is an io.ByteReader so that
+// gzip reads no bytes after the end of a stream
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// recordScanner reads CSV records with the byte offsets they start at.
+// encoding/csv does not tell offsets, so lines of a record are
+// read here and parsed by encoding/csv one record at a time
+type recordScanner struct {
+	br     *bufio.Reader
+	format CsvFormat
+	offset int64
+}
+
+func newRecordScanner(br *bufio.Reader, format CsvFormat, offset int64) *recordScanner {
+	s := new(recordScanner)
+	s.br = br
+	s.format = format
+	s.offset = offset
+	return s
+}
+
+// next() returns the next record and its offset, or io.EOF.
+// Empty lines and comments are skipped like encoding/csv
+func (s *recordScanner) next() ([]string, int64, error) {
+	for {
+		start := s.offset
+		var b strings.Builder
+		inQuote := false
+		isEOF := false
+		for {
+			line, err := s.br.ReadString('\n')
+			s.offset += int64(len(line))
+			b.WriteString(line)
+			if err == io.EOF {
+				isEOF = true
+				break
+			}
+			if err != nil {
+				return nil, start, errors.WithStack(err)
+			}
+			if b.Len() == len(line) && s.format.Comment != 0 &&
+				strings.HasPrefix(line, string(s.format.Comment)) {
+				break
+			}
+			if inQuote = s.inQuotes(line, inQuote); !inQuote {
+				break
+			}
+		}
+		reader := csv.NewReader(strings.NewReader(b.String()))
+		s.format.applyReader(reader)
+		record, err := reader.Read()
+		if err == io.EOF {
+			if isEOF {
+				return nil, start, io.EOF
+			}
+			continue
+		}
+		if err != nil {
+			return nil, start, errors.WithStack(err)
+		}
+		return record, start, nil
+	}
+}
+
+// inQuotes() returns if a record continues to the next line
+// because line ends in a quoted field
+func (s *recordScanner) inQuotes(line string, inQuote bool) bool {
+	delimiter := s.format.Delimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	runes := []rune(line)
+	atStart := !inQuote
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if inQuote {
+			if r == '"' {
+				if i+1 < len(runes) && runes[i+1] == '"' {
+					i++
+				} else {
+					inQuote = false
+				}
+			}
+			continue
+		}
+		switch {
+		case r == '"' && atStart:
+			inQuote = true
+			atStart = false
+		case r == delimiter:
+			atStart = true
+		case atStart && s.format.TrimLeadingSpace && unicode.IsSpace(r):
+		default:
+			atStart = false
+		}
+	}
+	return inQuote
+}
+
+// indexRange() returns the range of an indexed column the condition
+// restricts rows to, or nil if no index can be used
+func (t *CsvTable) indexRange(c *Cond) *indexRange {
+	if c == nil {
+		return nil
+	}
+	if c.op == cCondAnd {
+		for _, child := range c.children {
+			if rng := t.indexRange(child); rng != nil {
+				return rng
+			}
+		}
+		return nil
+	}
+	colIdx, ok := t.colMap[c.column]
+	if !ok || c.op == cCondOr || c.op == cCondNot {
+		return nil
+	}
+	colType := t.columnTypes[colIdx]
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		s, err := typedString(colType, v)
+		if err != nil {
+			return nil
+		}
+		values[i] = s
+	}
+
+	rng := new(indexRange)
+	rng.column = c.column
+	switch c.op {
+	case cCondEq, cCondIn:
+		if len(values) == 0 || (c.op == cCondEq && len(values) != 1) {
+			return nil
+		}
+		rng.points = values
+	case cCondLt, cCondLe:
+		if len(values) != 1 {
+			return nil
+		}
+		rng.to, rng.hasTo, rng.toOpen = values[0], true, c.op == cCondLt
+	case cCondGt, cCondGe:
+		if len(values) != 1 {
+			return nil
+		}
+		rng.from, rng.hasFrom, rng.fromOpen = values[0], true, c.op == cCondGt
+	case cCondBetween:
+		if len(values) != 2 {
+			return nil
+		}
+		rng.from, rng.hasFrom = values[0], true
+		rng.to, rng.hasTo = values[1], true
+	default:
+		return nil
+	}
+	if rng.points == nil && colType == cColTypeUntyped {
+		return nil
+	}
+	if !pathExist(t.indexPath(c.column)) {
+		return nil
+	}
+	return rng
+}
+
+// lookup() returns entries of rng in the order of their positions
+func (idx *tableIndex) lookup(rng *indexRange) []*indexEntry {
+	n := len(idx.entries)
+	search := func(v string, orEqual bool) int {
+		return sort.Search(n, func(i int) bool {
+			c := indexCompare(idx.colType, idx.entries[i].value, v)
+			if orEqual {
+				return c >= 0
+			}
+			return c > 0
+		})
+	}
+	found := make([]*indexEntry, 0)
+	if rng.points != nil {
+		seen := make(map[string]bool, len(rng.points))
+		for _, v := range rng.points {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			found = append(found, idx.entries[search(v, true):search(v, false)]...)
+		}
+	} else {
+		from, to := 0, n
+		if rng.hasFrom {
+			from = search(rng.from, !rng.fromOpen)
+		}
+		if rng.hasTo {
+			to = search(rng.to, rng.toOpen)
+		}
+		if from < to {
+			found = append(found, idx.entries[from:to]...)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].offset != found[j].offset {
+			return found[i].offset < found[j].offset
+		}
+		return found[i].row < found[j].row
+	})
+	return found
+}
+
+// indexIter reads rows at the positions of index entries.
+// The table file is opened under a shared lock which is released before
+// rows are read. See newLockedCsvReader()
+type indexIter struct {
+	t       *CsvTable
+	f       *os.File
+	entries []*indexEntry
+	pos     int
+	values  []string
+	err     error
+
+	// the gzip stream being read
+	zr     *gzip.Reader
+	reader *csv.Reader
+	block  int64
+	row    int
+}
+
+// newIndexIter() returns an iterator over rows in rng looked up by the index
+func (t *CsvTable) newIndexIter(rng *indexRange) (*indexIter, error) {
+	lock, err := t.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	idx, err := t.getIndex(rng.column)
+	if err != nil {
+		return nil, err
+	}
+	it := new(indexIter)
+	it.t = t
+	it.entries = idx.lookup(rng)
+	if len(it.entries) > 0 {
+		if it.f, err = os.Open(t.path); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return it, nil
+}
+
+func (it *indexIter) next() bool {
+	if it.err != nil || it.pos >= len(it.entries) {
+		return false
+	}
+	e := it.entries[it.pos]
+	it.pos++
+	if it.t.codec == CCodecNone {
+		if _, err := it.f.Seek(e.offset, io.SeekStart); err != nil {
+			it.err = errors.WithStack(err)
+			return false
+		}
+		it.reader = csv.NewReader(bufio.NewReader(it.f))
+		it.t.format.applyReader(it.reader)
+		it.values, it.err = it.reader.Read()
+		it.err = errors.WithStack(it.err)
+		return it.err == nil
+	}
+
+	if it.reader == nil || e.offset != it.block || e.row < it.row {
+		if err := it.openBlock(e.offset); err != nil {
+			it.err = err
+			return false
+		}
+	}
+	for it.row <= e.row {
+		values, err := it.reader.Read()
+		if err != nil {
+			it.err = errors.WithStack(err)
+			return false
+		}
+		it.values = values
+		it.row++
+	}
+	return true
+}
+
+// openBlock() starts reading the gzip stream at offset
+func (it *indexIter) openBlock(offset int64) error {
+	if _, err := it.f.Seek(offset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	br := bufio.NewReader(it.f)
+	var err error
+	if it.zr == nil {
+		it.zr, err = gzip.NewReader(br)
+	} else {
+		err = it.zr.Reset(br)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	it.zr.Multistream(false)
+	it.reader = csv.NewReader(it.zr)
+	it.t.format.applyReader(it.reader)
+	it.block = offset
+	it.row = 0
+	return nil
+}
+
+func (it *indexIter) current() []string {
+	return it.values
+}
+
+func (it *indexIter) lastErr() error {
+	return it.err
+}
+
+func (it *indexIter) close() {
+	if it.zr != nil {
+		it.zr.Close()
+		it.zr = nil
+	}
+	if it.f != nil {
+		it.f.Close()
+		it.f = nil
+	}
+}
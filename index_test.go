@@ -0,0 +1,238 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	rootDir, err := ensureTestDir("TestIndex")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	selectIds := func(tb *CsvTable, condition interface{}) string {
+		r, err := tb.SelectRows(condition, []string{"id"})
+		if err != nil {
+			return err.Error()
+		}
+		defer r.Close()
+		ids := []int{}
+		for r.Next() {
+			id := 0
+			if err := r.Scan(&id); err != nil {
+				return err.Error()
+			}
+			ids = append(ids, id)
+		}
+		if err := r.Err(); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(ids)
+	}
+
+	for _, useGzip := range []bool{false, true} {
+		groupName := fmt.Sprintf("items%v", useGzip)
+		g, err := db.CreateTypedGroup(groupName, []string{"id", "name", "price"},
+			[]string{CColTypeInt64, CColTypeString, CColTypeFloat64}, useGzip, 10)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := g.SetHeader(true); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		tb, err := g.CreateTable(groupName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		// each flush is a gzip stream
+		for i := 1; i <= 30; i++ {
+			if err := tb.InsertRow(nil, i, fmt.Sprintf("line1\nline2,\"%d\"", i), float64(i%10)/2); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+			if i%7 == 0 {
+				if err := tb.Flush(); err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.CreateIndex("id"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.CreateIndex("price"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		check := func(title string) error {
+			for _, c := range []struct {
+				cond *Cond
+				exp  string
+			}{
+				{Eq("id", 12), "[12]"},
+				{Eq("id", 99), "[]"},
+				{In("id", 3, 28, 3, 15), "[3 15 28]"},
+				{Between("id", 9, 11), "[9 10 11]"},
+				{Gt("id", 28), "[29 30]"},
+				{Le("id", 2), "[1 2]"},
+				{And(Lt("price", 1), Gt("id", 15)), "[20 21 30]"},
+			} {
+				if tb.indexRange(c.cond) == nil {
+					return fmt.Errorf("%s %v must use the index", title, c.exp)
+				}
+				if err := getGotExpErr(fmt.Sprintf("%s %s", title, c.exp),
+					selectIds(tb, c.cond), c.exp); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := check(fmt.Sprintf("gzip=%v", useGzip)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		name := ""
+		if err := tb.Select1Row(Eq("id", 23), []string{"name"}, &name); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("name", name, "line1\nline2,\"23\""); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		// appends, rewrites and writes without updating the index
+		for i := 31; i <= 32; i++ {
+			if err := tb.InsertRow(nil, i, "x", 0); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("appended", selectIds(tb, Ge("id", 30)), "[30 31 32]"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Delete(Eq("id", 31)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("deleted", selectIds(tb, Ge("id", 30)), "[30 32]"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tx.Update(tb, Eq("id", 32), map[string]interface{}{"id": 33}); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("committed", selectIds(tb, Ge("id", 30)), "[30 33]"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Truncate(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("truncated", selectIds(tb, Ge("id", 1)), "[]"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		if err := tb.Drop(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("indexes after drop", len(tb.indexColumns()), 0); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	// untyped columns use the index only for = and IN
+	tb, err := db.CreateTable("untyped", []string{"code"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, code := range []string{"10", "a", "9", "1.0"} {
+		if err := tb.InsertRow(nil, code); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.CreateIndex("code"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if tb.indexRange(Gt("code", 1)) != nil {
+		t.Errorf("ranges of untyped columns must not use the index")
+		return
+	}
+	if err := getGotExpErr("untyped", tb.Count(nil), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	codes := []string{}
+	r, err := tb.SelectRows(In("code", 1, "a"), nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for r.Next() {
+		code := ""
+		if err := r.Scan(&code); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		codes = append(codes, code)
+	}
+	r.Close()
+	if err := getGotExpErr("untyped codes", fmt.Sprint(codes), "[a 1.0]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.CreateIndex("price"); err == nil {
+		t.Errorf("an index of an unknown column must fail")
+		return
+	}
+}
@@ -0,0 +1,564 @@
+package csvdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JoinOptions are options of Join()
+type JoinOptions struct {
+	// CJoinInner, CJoinLeft, CJoinSemi or CJoinAnti. "" is CJoinInner
+	Type string
+	// bytes of rows read in memory to build the hash table.
+	// Beyond that, both sides are sorted by the keys and merged.
+	// 0 is the default sort memory
+	Memory int64
+}
+
+/*
+Join() joins rows of left and right where the values of leftKeys
+equal those of rightKeys. NULL keys match nothing.
+Keys are compared by the types of the columns, so int64 1 matches float64 1.0.
+
+Inner and left joins return the columns of left followed by those of right.
+Columns of right with the names of columns of left are named
+<table name>.<column>, or right.<column> for rows not of a table.
+Right columns of left rows without a match are NULL.
+Semi and anti joins return left rows with a match and without one.
+
+Rows are read from both sides in turn until one side ends, and a hash
+table is built from that side, the smaller one. If the rows read exceed
+opts.Memory first, both sides are sorted in temporary files by the keys
+and merged, and the joined rows are in the order of the keys.
+Right rows of a key are held in memory by a merge join.
+Both left and right are closed by Close() of the returned rows.
+*/
+func Join(left, right *CsvRows, leftKeys, rightKeys []string,
+	opts *JoinOptions) (*CsvRows, error) {
+	if opts == nil {
+		opts = new(JoinOptions)
+	}
+	j, err := newJoin(left, right, leftKeys, rightKeys, opts)
+	if err != nil {
+		left.Close()
+		right.Close()
+		return nil, err
+	}
+	it, err := j.iter()
+	if err != nil {
+		left.Close()
+		right.Close()
+		return nil, err
+	}
+	columns, columnTypes := j.columns()
+	r, err := newCsvRowsFromIter(it, columns, columnTypes, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.tmpDir = left.tmpDir
+	r.nullValue = left.nullValue
+	return r, nil
+}
+
+// Join() joins all rows of the table and right. See Join()
+func (t *CsvTable) Join(right *CsvTable, leftKeys, rightKeys []string,
+	opts *JoinOptions) (*CsvRows, error) {
+	l, err := t.SelectRows(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := right.SelectRows(nil, nil)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return Join(l, r, leftKeys, rightKeys, opts)
+}
+
+// joinSide is the rows of a side of a join and its key columns
+type joinSide struct {
+	rows     *CsvRows
+	keyIdxs  []int
+	keyTypes []string
+	// rows read before the join method is decided
+	buff [][]string
+	done bool
+}
+
+func newJoinSide(rows *CsvRows, keys []string) (*joinSide, error) {
+	s := new(joinSide)
+	s.rows = rows
+	colMap := newColMap(rows.Columns())
+	colTypes := rows.ColumnTypes()
+	s.keyIdxs = make([]int, len(keys))
+	s.keyTypes = make([]string, len(keys))
+	for i, key := range keys {
+		idx, ok := colMap[key]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("column %s does not exist", key))
+		}
+		s.keyIdxs[i] = idx
+		s.keyTypes[i] = colTypes[idx]
+	}
+	return s, nil
+}
+
+// read() reads the next row to buff and returns its memory size.
+// It returns 0 after the last row
+func (s *joinSide) read() (int64, error) {
+	if s.done {
+		return 0, nil
+	}
+	if !s.rows.Next() {
+		s.done = true
+		return 0, s.rows.Err()
+	}
+	row := s.rows.values()
+	s.buff = append(s.buff, row)
+	return rowMemSize(row), nil
+}
+
+// key() returns the key of a row comparable between the sides.
+// It is "" if a key value is NULL
+func (s *joinSide) key(row []string) string {
+	var b strings.Builder
+	for i, idx := range s.keyIdxs {
+		v := row[idx]
+		if isNullOutput(s.rows.nullValue, s.keyTypes[i], v) {
+			return ""
+		}
+		v = canonicalValue(s.keyTypes[i], v)
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteString(":")
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// rest() returns an iterator over rows of buff followed by rows not read yet
+func (s *joinSide) rest() rowIterator {
+	it := new(joinSideIter)
+	it.s = s
+	it.pos = -1
+	return it
+}
+
+// joinSideIter reads rows of a join side. See joinSide.rest()
+type joinSideIter struct {
+	s   *joinSide
+	pos int
+	cur []string
+}
+
+func (it *joinSideIter) next() bool {
+	it.pos++
+	if it.pos < len(it.s.buff) {
+		it.cur = it.s.buff[it.pos]
+		return true
+	}
+	it.s.buff = nil
+	it.pos = 0
+	if it.s.done || !it.s.rows.Next() {
+		it.s.done = true
+		return false
+	}
+	it.cur = it.s.rows.values()
+	return true
+}
+
+func (it *joinSideIter) current() []string {
+	return it.cur
+}
+
+func (it *joinSideIter) lastErr() error {
+	return it.s.rows.Err()
+}
+
+func (it *joinSideIter) close() {
+	it.s.rows.Close()
+}
+
+type joinDef struct {
+	joinType  string
+	memory    int64
+	left      *joinSide
+	right     *joinSide
+	nullValue string
+	// pending joined rows of the current row
+	pending [][]string
+}
+
+func newJoin(left, right *CsvRows, leftKeys, rightKeys []string,
+	opts *JoinOptions) (*joinDef, error) {
+	j := new(joinDef)
+	j.joinType = strings.ToLower(opts.Type)
+	switch j.joinType {
+	case "":
+		j.joinType = CJoinInner
+	case CJoinInner, CJoinLeft, CJoinSemi, CJoinAnti:
+	default:
+		return nil, errors.Errorf("unknown join type %s", opts.Type)
+	}
+	if len(leftKeys) == 0 || len(leftKeys) != len(rightKeys) {
+		return nil, errors.Errorf("%d left keys while %d right keys", len(leftKeys), len(rightKeys))
+	}
+	j.memory = opts.Memory
+	if j.memory <= 0 {
+		j.memory = cDefaultSortMemory
+	}
+	var err error
+	if j.left, err = newJoinSide(left, leftKeys); err != nil {
+		return nil, err
+	}
+	if j.right, err = newJoinSide(right, rightKeys); err != nil {
+		return nil, err
+	}
+	j.nullValue = left.nullValue
+	return j, nil
+}
+
+// columns() returns the columns and their types of joined rows
+func (j *joinDef) columns() ([]string, []string) {
+	columns := append([]string{}, j.left.rows.Columns()...)
+	columnTypes := append([]string{}, j.left.rows.ColumnTypes()...)
+	if j.joinType == CJoinSemi || j.joinType == CJoinAnti {
+		return columns, columnTypes
+	}
+	colMap := newColMap(columns)
+	prefix := j.right.rows.tableName
+	if prefix == "" {
+		prefix = "right"
+	}
+	for _, col := range j.right.rows.Columns() {
+		if _, ok := colMap[col]; ok {
+			col = prefix + "." + col
+		}
+		columns = append(columns, col)
+	}
+	return columns, append(columnTypes, j.right.rows.ColumnTypes()...)
+}
+
+// iter() reads rows of both sides in turn to decide the join method
+// and returns the iterator over joined rows
+func (j *joinDef) iter() (rowIterator, error) {
+	used := int64(0)
+	for used < j.memory {
+		n, err := j.left.read()
+		if err != nil {
+			return nil, err
+		}
+		if j.left.done {
+			return j.newHashJoinIter(true), nil
+		}
+		used += n
+		if n, err = j.right.read(); err != nil {
+			return nil, err
+		}
+		if j.right.done {
+			return j.newHashJoinIter(false), nil
+		}
+		used += n
+	}
+	return j.newMergeJoinIter()
+}
+
+// rightValues() returns values of a right row with NULLs of the left side
+func (j *joinDef) rightValues(row []string) []string {
+	if row == nil {
+		values := make([]string, len(j.right.rows.Columns()))
+		for i := range values {
+			values[i] = j.nullValue
+		}
+		return values
+	}
+	if j.right.rows.nullValue == j.nullValue {
+		return row
+	}
+	colTypes := j.right.rows.ColumnTypes()
+	values := make([]string, len(row))
+	for i, v := range row {
+		if isNullOutput(j.right.rows.nullValue, colTypes[i], v) {
+			v = j.nullValue
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// joinRow() returns a left row followed by a right row. nil right is NULLs
+func (j *joinDef) joinRow(l, r []string) []string {
+	rv := j.rightValues(r)
+	row := make([]string, 0, len(l)+len(rv))
+	return append(append(row, l...), rv...)
+}
+
+// emit() adds the joined rows of a left row and its matches to pending
+func (j *joinDef) emit(l []string, matches [][]string) {
+	switch j.joinType {
+	case CJoinInner:
+		for _, r := range matches {
+			j.pending = append(j.pending, j.joinRow(l, r))
+		}
+	case CJoinLeft:
+		if len(matches) == 0 {
+			j.pending = append(j.pending, j.joinRow(l, nil))
+		}
+		for _, r := range matches {
+			j.pending = append(j.pending, j.joinRow(l, r))
+		}
+	case CJoinSemi:
+		if len(matches) > 0 {
+			j.pending = append(j.pending, l)
+		}
+	case CJoinAnti:
+		if len(matches) == 0 {
+			j.pending = append(j.pending, l)
+		}
+	}
+}
+
+// pop() returns the next pending row
+func (j *joinDef) pop() ([]string, bool) {
+	if len(j.pending) == 0 {
+		return nil, false
+	}
+	row := j.pending[0]
+	j.pending = j.pending[1:]
+	return row, true
+}
+
+/*
+hashJoinIter joins rows of the probe side with a hash table of the
+build side. Built from the right side, left rows are joined one by one.
+Built from the left side, right rows are joined one by one and left
+rows of left, semi and anti joins are returned after the last right row
+by whether they matched.
+*/
+type hashJoinIter struct {
+	j         *joinDef
+	buildLeft bool
+	build     [][]string
+	table     map[string][]int
+	matched   []bool
+	probe     rowIterator
+	probeDone bool
+	tailPos   int
+	cur       []string
+	err       error
+}
+
+func (j *joinDef) newHashJoinIter(buildLeft bool) *hashJoinIter {
+	it := new(hashJoinIter)
+	it.j = j
+	it.buildLeft = buildLeft
+	build, probe := j.right, j.left
+	if buildLeft {
+		build, probe = j.left, j.right
+	}
+	it.build = build.buff
+	build.buff = nil
+	build.rows.Close()
+	it.table = make(map[string][]int)
+	for i, row := range it.build {
+		if k := build.key(row); k != "" {
+			it.table[k] = append(it.table[k], i)
+		}
+	}
+	if buildLeft {
+		it.matched = make([]bool, len(it.build))
+	}
+	it.probe = probe.rest()
+	return it
+}
+
+func (it *hashJoinIter) next() bool {
+	j := it.j
+	for it.err == nil {
+		if row, ok := j.pop(); ok {
+			it.cur = row
+			return true
+		}
+		if !it.probeDone {
+			if it.probe.next() {
+				it.join(it.probe.current())
+				continue
+			}
+			if it.err = it.probe.lastErr(); it.err != nil {
+				return false
+			}
+			it.probeDone = true
+		}
+		if !it.buildLeft || j.joinType == CJoinInner || it.tailPos >= len(it.build) {
+			return false
+		}
+		// left rows without a match for left and anti joins, with one for semi joins
+		if l := it.build[it.tailPos]; it.matched[it.tailPos] == (j.joinType == CJoinSemi) {
+			if j.joinType == CJoinLeft {
+				l = j.joinRow(l, nil)
+			}
+			j.pending = append(j.pending, l)
+		}
+		it.tailPos++
+	}
+	return false
+}
+
+// join() joins a probe row with the rows of the hash table
+func (it *hashJoinIter) join(row []string) {
+	if !it.buildLeft {
+		idxs := it.table[it.j.left.key(row)]
+		matches := make([][]string, len(idxs))
+		for i, idx := range idxs {
+			matches[i] = it.build[idx]
+		}
+		it.j.emit(row, matches)
+		return
+	}
+	for _, idx := range it.table[it.j.right.key(row)] {
+		it.matched[idx] = true
+		switch it.j.joinType {
+		case CJoinInner, CJoinLeft:
+			it.j.pending = append(it.j.pending, it.j.joinRow(it.build[idx], row))
+		}
+	}
+}
+
+func (it *hashJoinIter) current() []string {
+	return it.cur
+}
+
+func (it *hashJoinIter) lastErr() error {
+	return it.err
+}
+
+func (it *hashJoinIter) close() {
+	it.probe.close()
+	it.build = nil
+	it.table = nil
+}
+
+// mergeJoinIter merges rows of both sides sorted by their keys.
+// The keys are appended to the sorted rows
+type mergeJoinIter struct {
+	j        *joinDef
+	left     rowIterator
+	right    rowIterator
+	rightCur []string
+	// right rows of groupKey
+	group    [][]string
+	groupKey string
+	started  bool
+	cur      []string
+	err      error
+}
+
+func (j *joinDef) newMergeJoinIter() (*mergeJoinIter, error) {
+	it := new(mergeJoinIter)
+	it.j = j
+	var err error
+	if it.left, err = j.sortSide(j.left, true); err != nil {
+		return nil, err
+	}
+	if it.right, err = j.sortSide(j.right, false); err != nil {
+		it.left.close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// sortSide() sorts rows of s by their keys in half the join memory.
+// Right rows with NULL keys are dropped as they match nothing
+func (j *joinDef) sortSide(s *joinSide, isLeft bool) (rowIterator, error) {
+	k, err := newOrderKey(OrderSpec{}, len(s.rows.Columns()), CColTypeString)
+	if err != nil {
+		return nil, err
+	}
+	cmp := new(rowComparator)
+	cmp.keys = []*orderKey{k}
+	sorter := newExternalSorter(cmp, j.left.rows.tmpDir, j.memory/2)
+	it := s.rest()
+	defer it.close()
+	for it.next() {
+		row := it.current()
+		key := s.key(row)
+		if key == "" && !isLeft {
+			continue
+		}
+		if err := sorter.add(append(append(make([]string, 0, len(row)+1), row...), key)); err != nil {
+			sorter.cleanup()
+			return nil, err
+		}
+	}
+	if err := it.lastErr(); err != nil {
+		sorter.cleanup()
+		return nil, err
+	}
+	return sorter.finish()
+}
+
+func (it *mergeJoinIter) next() bool {
+	j := it.j
+	if !it.started {
+		it.started = true
+		it.advanceRight()
+	}
+	for it.err == nil {
+		if row, ok := j.pop(); ok {
+			it.cur = row
+			return true
+		}
+		if !it.left.next() {
+			it.err = it.left.lastErr()
+			return false
+		}
+		l := it.left.current()
+		key := l[len(l)-1]
+		l = l[:len(l)-1]
+		if key == "" {
+			j.emit(l, nil)
+			continue
+		}
+		if key != it.groupKey {
+			it.groupKey = key
+			it.group = it.group[:0]
+			for it.rightCur != nil && it.rightCur[len(it.rightCur)-1] < key {
+				it.advanceRight()
+			}
+			for it.rightCur != nil && it.rightCur[len(it.rightCur)-1] == key {
+				it.group = append(it.group, it.rightCur[:len(it.rightCur)-1])
+				it.advanceRight()
+			}
+		}
+		j.emit(l, it.group)
+	}
+	return false
+}
+
+// advanceRight() reads the next right row to rightCur, or nil after the last
+func (it *mergeJoinIter) advanceRight() {
+	it.rightCur = nil
+	if it.right.next() {
+		it.rightCur = it.right.current()
+		return
+	}
+	if err := it.right.lastErr(); err != nil && it.err == nil {
+		it.err = err
+	}
+}
+
+func (it *mergeJoinIter) current() []string {
+	return it.cur
+}
+
+func (it *mergeJoinIter) lastErr() error {
+	return it.err
+}
+
+func (it *mergeJoinIter) close() {
+	it.left.close()
+	it.right.close()
+	it.group = nil
+}
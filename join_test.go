@@ -0,0 +1,198 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	rootDir, err := ensureTestDir("TestJoin")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	users, err := db.CreateTypedTable("users", []string{"id", "name"},
+		[]string{CColTypeInt64, CColTypeString}, false, 100)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i, name := range []string{"alice", "bob", "carol", "dave"} {
+		if err := users.InsertRow(nil, i+1, name); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := users.InsertRow([]string{"name"}, "nobody"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := users.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// user ids of orders are floats
+	orders, err := db.CreateTypedTable("orders", []string{"id", "user_id", "amount"},
+		[]string{CColTypeInt64, CColTypeFloat64, CColTypeInt64}, false, 100)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i, userID := range []interface{}{1, 3, 1, 9, nil, "3.0", 1} {
+		if err := orders.InsertRow(nil, i+1, userID, (i+1)*10); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := orders.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	readRows := func(r *CsvRows, sorted bool) string {
+		defer r.Close()
+		rows := []string{}
+		for r.Next() {
+			values := make([]string, len(r.Columns()))
+			for i, v := range r.values() {
+				if isNullOutput(r.nullValue, r.ColumnTypes()[i], v) {
+					v = "NULL"
+				}
+				values[i] = v
+			}
+			rows = append(rows, strings.Join(values, ","))
+		}
+		if err := r.Err(); err != nil {
+			return err.Error()
+		}
+		if sorted {
+			sort.Strings(rows)
+		}
+		return strings.Join(rows, " ")
+	}
+
+	for _, c := range []struct {
+		joinType string
+		columns  string
+		exp      string
+	}{
+		{CJoinInner, "id,user_id,amount,users.id,name",
+			"1,1,10,1,alice 2,3,20,3,carol 3,1,30,1,alice 6,3.0,60,3,carol 7,1,70,1,alice"},
+		{CJoinLeft, "id,user_id,amount,users.id,name",
+			"1,1,10,1,alice 2,3,20,3,carol 3,1,30,1,alice 4,9,40,NULL, 5,NULL,50,NULL, " +
+				"6,3.0,60,3,carol 7,1,70,1,alice"},
+		{CJoinSemi, "id,user_id,amount", "1,1,10 2,3,20 3,1,30 6,3.0,60 7,1,70"},
+		{CJoinAnti, "id,user_id,amount", "4,9,40 5,NULL,50"},
+	} {
+		// hash tables of users, orders and merge joins
+		for _, memory := range []int64{0, 1} {
+			for _, swap := range []bool{false, true} {
+				title := fmt.Sprintf("%s memory=%d swap=%v", c.joinType, memory, swap)
+				left, err := orders.SelectRows(nil, nil)
+				if err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+				if swap {
+					// more rows of users than orders
+					left.Close()
+					if left, err = orders.SelectRows(Le("id", 2), nil); err != nil {
+						t.Errorf("%v", err)
+						return
+					}
+				}
+				right, err := users.SelectRows(nil, nil)
+				if err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+				r, err := Join(left, right, []string{"user_id"}, []string{"id"},
+					&JoinOptions{Type: c.joinType, Memory: memory})
+				if err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+				if err := getGotExpErr(title+" columns", strings.Join(r.Columns(), ","), c.columns); err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+				exp := c.exp
+				if swap {
+					rows := []string{}
+					for _, row := range strings.Split(exp, " ") {
+						if strings.HasPrefix(row, "1,") || strings.HasPrefix(row, "2,") {
+							rows = append(rows, row)
+						}
+					}
+					exp = strings.Join(rows, " ")
+				}
+				if err := getGotExpErr(title, readRows(r, true), exp); err != nil {
+					t.Errorf("%v", err)
+					return
+				}
+			}
+		}
+	}
+
+	// the hash table of users keeps the order of orders
+	r, err := orders.Join(users, []string{"user_id"}, []string{"id"}, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("table join", readRows(r, false),
+		"1,1,10,1,alice 2,3,20,3,carol 3,1,30,1,alice 6,3.0,60,3,carol 7,1,70,1,alice"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// merge joins spill sorted runs and are in the order of the keys
+	left, err := orders.SelectRows(nil, []string{"amount", "user_id"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	right, err := users.SelectRows(nil, []string{"name", "id"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = Join(left, right, []string{"user_id"}, []string{"id"},
+		&JoinOptions{Type: CJoinLeft, Memory: 100})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("merge join", readRows(r, false),
+		"50,NULL,,NULL 10,1,alice,1 30,1,alice,1 70,1,alice,1 20,3,carol,3 60,3.0,carol,3 40,9,,NULL"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if _, err := orders.Join(users, []string{"user_id"}, []string{"name", "id"}, nil); err == nil {
+		t.Errorf("keys of different lengths must fail")
+		return
+	}
+	if _, err := orders.Join(users, []string{"user_id"}, []string{"id"},
+		&JoinOptions{Type: "outer"}); err == nil {
+		t.Errorf("an unknown join type must fail")
+		return
+	}
+}
@@ -193,6 +193,15 @@ func convFromString(src string, dest interface{}) error {
 
 	dv := reflect.Indirect(dpv)
 
+	if dv.Type() == reflect.TypeOf(time.Time{}) {
+		tm, err := parseTimestamp(src)
+		if err != nil {
+			return err
+		}
+		dv.Set(reflect.ValueOf(tm))
+		return nil
+	}
+
 	if dv.Kind() == sv.Kind() && sv.Type().ConvertibleTo(dv.Type()) {
 		dv.Set(sv.Convert(dv.Type()))
 		return nil
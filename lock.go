@@ -0,0 +1,87 @@
+package csvdb
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is the cause of errors returned when a lock
+// could not be acquired within the lock timeout.
+// Check it with errors.Cause(err) == ErrLocked
+var ErrLocked = errors.New("the file is locked by another process")
+
+// errLockBusy is returned by flock() when a non-blocking lock fails
+var errLockBusy = errors.New("lock busy")
+
+// how often a lock with a timeout is retried
+const cLockRetryInterval = 10 * time.Millisecond
+
+// fileLock is an advisory lock on the sidecar lock file of a data or ini file.
+// The lock is not taken on the file itself because rewrites replace the file.
+type fileLock struct {
+	f *os.File
+}
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+/*
+lockFile() locks path for reading (shared) or writing (exclusive)
+
+timeout:
+0: waits until the lock is available
+> 0: waits up to timeout
+CLockNoWait: fails immediately if the lock is held by another process
+*/
+func lockFile(path string, exclusive bool, timeout time.Duration) (*fileLock, error) {
+	f, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if timeout == 0 {
+		err = flock(f, exclusive, true)
+	} else {
+		deadline := time.Now().Add(timeout)
+		for {
+			err = flock(f, exclusive, false)
+			if err != errLockBusy || timeout < 0 || !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(cLockRetryInterval)
+		}
+	}
+	if err == errLockBusy {
+		f.Close()
+		return nil, errors.Wrapf(ErrLocked, "%s", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	l := new(fileLock)
+	l.f = f
+	return l, nil
+}
+
+// unlock() releases the lock. It is safe to call on nil
+func (l *fileLock) unlock() {
+	if l == nil || l.f == nil {
+		return
+	}
+	funlock(l.f)
+	l.f.Close()
+	l.f = nil
+}
+
+// removeLockFile() removes the lock file of a dropped file
+func removeLockFile(path string) error {
+	if err := os.Remove(lockPath(path)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
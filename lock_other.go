@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package csvdb
+
+import "os"
+
+// flock() is not available on this platform. Locks always succeed
+func flock(f *os.File, exclusive, block bool) error {
+	return nil
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,202 @@
+package csvdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestLock(t *testing.T) {
+	rootDir, err := ensureTestDir("TestLock")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := db.CreateTable("test_lock", []string{"id", "name"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, 1, "name1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a lock by another process
+	other, err := lockFile(tb.path, true, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb.SetLockTimeout(CLockNoWait)
+	r, err := tb.SelectRows(nil, nil)
+	if err != nil {
+		t.Errorf("SelectRows() must not lock before reading: %v", err)
+		return
+	}
+	if r.Next() || errors.Cause(r.Err()) != ErrLocked {
+		t.Errorf("reading rows must fail with ErrLocked, got %v", r.Err())
+		return
+	}
+	r.Close()
+	if err := getGotExpErr("count while locked", tb.Count(nil), -1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Update(nil, map[string]interface{}{"name": "x"}); errors.Cause(err) != ErrLocked {
+		t.Errorf("Update() must fail with ErrLocked, got %v", err)
+		return
+	}
+
+	tb.SetLockTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if err := tb.Truncate(); errors.Cause(err) != ErrLocked {
+		t.Errorf("Truncate() must fail with ErrLocked, got %v", err)
+		return
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Truncate() must wait for the timeout")
+		return
+	}
+
+	// a lock released while waiting
+	tb.SetLockTimeout(0)
+	released := make(chan bool, 1)
+	go func(l *fileLock) {
+		time.Sleep(20 * time.Millisecond)
+		l.unlock()
+		released <- true
+	}(other)
+	if err := tb.Update(nil, map[string]interface{}{"name": "name2"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	<-released
+
+	// shared locks do not block each other
+	other, err = lockFile(tb.path, false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb.SetLockTimeout(CLockNoWait)
+	var name string
+	if err := tb.Select1Row(nil, []string{"name"}, &name); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("name", name, "name2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, 2, "name2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); errors.Cause(err) != ErrLocked {
+		t.Errorf("Flush() must fail with ErrLocked, got %v", err)
+		return
+	}
+	other.unlock()
+
+	// rows being read do not lock the table
+	tb.SetLockTimeout(time.Second)
+	r, err = tb.SelectRows(nil, []string{"id", "name"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if !r.Next() {
+		t.Errorf("no rows: %v", r.Err())
+		return
+	}
+	if err := tb.Update(Eq("id", "1"), map[string]interface{}{"name": "name3"}); err != nil {
+		r.Close()
+		t.Errorf("Update() while reading rows: %v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, 3, "name3"); err != nil {
+		r.Close()
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		r.Close()
+		t.Errorf("Flush() while reading rows: %v", err)
+		return
+	}
+	names := []string{}
+	for ok := true; ok; ok = r.Next() {
+		var id int
+		if err := r.Scan(&id, &name); err != nil {
+			r.Close()
+			t.Errorf("%v", err)
+			return
+		}
+		names = append(names, name)
+	}
+	r.Close()
+	if err := getGotExpErr("rows before the writes", fmt.Sprint(names), "[name2]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("rows after the writes", tb.Count(Eq("name", "name3")), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// rows left before the end
+	r, err = tb.SelectRows(nil, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r.Next()
+	r.Close()
+	tb.SetLockTimeout(CLockNoWait)
+	if err := tb.Truncate(); err != nil {
+		t.Errorf("Truncate() after closing rows: %v", err)
+		return
+	}
+
+	// the group ini file
+	g, err := db.GetGroup("test_lock")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	db.SetLockTimeout(CLockNoWait)
+	other, err = lockFile(g.iniFile, true, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := g.CreateTable("test_lock2"); errors.Cause(err) != ErrLocked {
+		t.Errorf("save() must fail with ErrLocked, got %v", err)
+		return
+	}
+	other.unlock()
+	if _, err := g.CreateTable("test_lock2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
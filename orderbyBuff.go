@@ -1,45 +1,117 @@
 package csvdb
 
-import "strconv"
+import (
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 
-func (ov orderBuffRows) Len() int {
-	return len(ov)
+	"github.com/pkg/errors"
+)
+
+// OrderSpec is a sort key of CsvRows.OrderByKeys()
+type OrderSpec struct {
+	Field string
+	// CorderByAsc or CorderByDesc. 0 means CorderByAsc
+	Direction int
+	// CNullsDefault, CNullsFirst or CNullsLast.
+	// By default NULLs are smaller than any value
+	Nulls int
+	// a BCP 47 language tag such as "en" or "ja-u-ks-level2" to compare
+	// strings by the collation of the language. "" compares bytes
+	Collation string
+	// overrides the column type to compare values, e.g. CColTypeTimestamp
+	Type string
 }
-func (ov orderBuffRows) Swap(i, j int) {
-	ov[i], ov[j] = ov[j], ov[i]
+
+type orderKey struct {
+	idx       int
+	colType   string
+	direction int
+	nullsLast bool
+	collator  *collate.Collator
 }
-func (ov orderBuffRows) Less(i, j int) bool {
-	for k, fieldt := range ov[i].orderFieldTypes {
-		idx := ov[i].orderFieldIdxs[k]
-		switch fieldt {
-		case "int", "int8", "int32", "int64", "bool":
-			d := int64(ov[i].direction)
-			r1, _ := strconv.ParseInt(ov[i].v[idx], 10, 64)
-			r2, _ := strconv.ParseInt(ov[j].v[idx], 10, 64)
-			if r1*d < r2*d {
-				return true
-			} else if r1*d > r2*d {
-				return false
-			}
-		case "uint", "uint8", "uint16", "uint32", "uint64":
-			d := uint64(ov[i].direction)
-			r1, _ := strconv.ParseUint(ov[i].v[idx], 10, 64)
-			r2, _ := strconv.ParseUint(ov[j].v[idx], 10, 64)
-			if r1*d < r2*d {
-				return true
-			} else if r1*d > r2*d {
-				return false
+
+// rowComparator compares rows by keys
+type rowComparator struct {
+	keys      []*orderKey
+	nullValue string
+}
+
+func newOrderKey(spec OrderSpec, idx int, colType string) (*orderKey, error) {
+	k := new(orderKey)
+	k.idx = idx
+	k.colType = colType
+	if spec.Type != "" {
+		if !isValidColType(spec.Type) {
+			return nil, errors.Errorf("unknown type %s to order %s", spec.Type, spec.Field)
+		}
+		k.colType = spec.Type
+	}
+
+	switch spec.Direction {
+	case 0, CorderByAsc:
+		k.direction = CorderByAsc
+	case CorderByDesc:
+		k.direction = CorderByDesc
+	default:
+		return nil, errors.Errorf("unknown direction %d to order %s", spec.Direction, spec.Field)
+	}
+
+	switch spec.Nulls {
+	case CNullsDefault:
+		k.nullsLast = k.direction == CorderByDesc
+	case CNullsFirst:
+		k.nullsLast = false
+	case CNullsLast:
+		k.nullsLast = true
+	default:
+		return nil, errors.Errorf("unknown nulls order %d to order %s", spec.Nulls, spec.Field)
+	}
+
+	if spec.Collation != "" {
+		tag, err := language.Parse(spec.Collation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "collation of %s", spec.Field)
+		}
+		k.collator = collate.New(tag)
+	}
+	return k, nil
+}
+
+func (c *rowComparator) compare(a, b []string) int {
+	for _, k := range c.keys {
+		va := a[k.idx]
+		vb := b[k.idx]
+		na := isNullOutput(c.nullValue, k.colType, va)
+		nb := isNullOutput(c.nullValue, k.colType, vb)
+		if na || nb {
+			if na && nb {
+				continue
 			}
-		case "float32", "float64":
-			d := float64(ov[i].direction)
-			r1, _ := strconv.ParseFloat(ov[i].v[idx], 64)
-			r2, _ := strconv.ParseFloat(ov[j].v[idx], 64)
-			if r1*d < r2*d {
-				return true
-			} else if r1*d > r2*d {
-				return false
+			if na == k.nullsLast {
+				return 1
 			}
+			return -1
+		}
+
+		var r int
+		if k.collator != nil {
+			r = k.collator.CompareString(va, vb)
+		} else {
+			r = compareTyped(k.colType, va, vb)
+		}
+		if r != 0 {
+			return r * k.direction
 		}
 	}
-	return false
+	return 0
+}
+
+func (ov orderBuffRows) Len() int {
+	return len(ov.rows)
+}
+func (ov orderBuffRows) Swap(i, j int) {
+	ov.rows[i], ov.rows[j] = ov.rows[j], ov.rows[i]
+}
+func (ov orderBuffRows) Less(i, j int) bool {
+	return ov.cmp.compare(ov.rows[i], ov.rows[j]) < 0
 }
@@ -0,0 +1,126 @@
+package csvdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOrderByKeys(t *testing.T) {
+	rootDir, err := ensureTestDir("TestOrderByKeys")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tb, err := db.CreateTypedTable("test_orderby",
+		[]string{"id", "name", "ts", "score"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeTimestamp, CColTypeInt64},
+		false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	rows := [][]interface{}{
+		{1, "b", "2026-01-02T00:00:00Z", 3},
+		{2, "B", "2026-01-01", ""},
+		{3, "a", "1767139200", 3},
+		{4, "ä", "2026-01-03 00:00:00", 1},
+	}
+	for _, row := range rows {
+		if err := tb.InsertRow([]string{"id", "name", "ts", "score"}, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	orderedIds := func(keys ...OrderSpec) (string, error) {
+		r, err := tb.SelectRows(nil, []string{"id"})
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		if err := r.OrderByKeys(keys...); err != nil {
+			return "", err
+		}
+		ids := ""
+		for r.Next() {
+			var id int
+			if err := r.Scan(&id); err != nil {
+				return "", err
+			}
+			ids += fmt.Sprint(id)
+		}
+		return ids, r.Err()
+	}
+
+	for _, c := range []struct {
+		title string
+		keys  []OrderSpec
+		exp   string
+	}{
+		{"nulls first", []OrderSpec{
+			{Field: "score", Direction: CorderByDesc, Nulls: CNullsFirst},
+			{Field: "id", Direction: CorderByAsc}}, "2134"},
+		{"nulls default", []OrderSpec{
+			{Field: "score", Direction: CorderByDesc},
+			{Field: "id", Direction: CorderByDesc}}, "3142"},
+		{"nulls last", []OrderSpec{
+			{Field: "score", Direction: CorderByAsc, Nulls: CNullsLast}}, "4132"},
+		{"bytewise", []OrderSpec{{Field: "name"}}, "2314"},
+		{"collation", []OrderSpec{{Field: "name", Collation: "en"}}, "3412"},
+		{"timestamp", []OrderSpec{{Field: "ts"}}, "3214"},
+		{"timestamp desc", []OrderSpec{{Field: "ts", Direction: CorderByDesc}}, "4123"},
+	} {
+		got, err := orderedIds(c.keys...)
+		if err != nil {
+			t.Errorf("%s: %v", c.title, err)
+			return
+		}
+		if err := getGotExpErr(c.title, got, c.exp); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	if _, err := orderedIds(OrderSpec{Field: "name", Collation: "??"}); err == nil {
+		t.Errorf("a bad collation must fail")
+		return
+	}
+
+	// empty strings are not NULLs in groups without a NULL string
+	for _, id := range []int{5, 6} {
+		if err := tb.InsertRow([]string{"id", "name"}, id, ""); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got, err := orderedIds(OrderSpec{Field: "name", Nulls: CNullsLast}, OrderSpec{Field: "id"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("empty strings", got, "562314"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
@@ -0,0 +1,404 @@
+package csvdb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+/*
+SetParallelism() sets how many goroutines Count(), Sum(), Min(), Max()
+and Aggregate() of tables and groups scan rows with.
+Groups scan their tables in parallel, and large uncompressed table files
+are split into byte ranges starting at records when there are fewer
+tables than goroutines. Partial aggregates are combined in the order
+of the rows, though sums of floats may differ in the last digits from
+sums of 1 goroutine.
+
+n:
+0: runtime.GOMAXPROCS(0) goroutines (default)
+1: scans rows in 1 goroutine
+n > 1: n goroutines
+
+func([]string) bool conditions are called in 1 goroutine unless n is set,
+as they may not be safe for concurrent calls.
+*/
+func (db *CsvDB) SetParallelism(n int) {
+	db.parallelism = n
+	for _, g := range db.Groups {
+		g.SetParallelism(n)
+	}
+}
+
+// SetParallelism() sets the parallelism of scans of the group and
+// of tables got from the group afterwards. See CsvDB.SetParallelism()
+func (g *CsvTableGroup) SetParallelism(n int) {
+	g.parallelism = n
+}
+
+// SetParallelism() sets the parallelism of scans of the table.
+// See CsvDB.SetParallelism()
+func (t *CsvTable) SetParallelism(n int) {
+	t.parallelism = n
+}
+
+// getParallelism() returns the number of goroutines scanning rows of
+// condition with a parallelism setting.
+// Function conditions are called in 1 goroutine unless n is set
+func getParallelism(n int, condition interface{}) int {
+	if n > 0 {
+		return n
+	}
+	if _, ok := condition.(func([]string) bool); ok {
+		return 1
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// runParallel() calls f(i) for i in [0, n) on up to parallelism goroutines.
+// Calls not started yet are skipped after an error.
+// The error of the smallest i is returned
+func runParallel(n, parallelism int, f func(i int) error) error {
+	if parallelism > n {
+		parallelism = n
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	errs := make([]error, n)
+	failed := int32(0)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				if errs[i] = f(i); errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanTask opens an iterator over a part of the rows of a scan
+type scanTask func() (rowIterator, error)
+
+// scanPlan is the tasks of a scan in the order of the rows
+// and the locks held until the scan ends
+type scanPlan struct {
+	tasks []scanTask
+	locks []*fileLock
+}
+
+func (p *scanPlan) close() {
+	for _, lock := range p.locks {
+		lock.unlock()
+	}
+	p.locks = nil
+}
+
+// aggregate() aggregates rows of the tasks on up to parallelism goroutines
+// and closes the plan. Partial aggregates are merged in the order of
+// the tasks, so groups are in the order they first appear like aggregateRows()
+func (p *scanPlan) aggregate(parallelism int, a *aggregation) ([]*aggGroup, error) {
+	defer p.close()
+	parts := make([][]*aggGroup, len(p.tasks))
+	if err := runParallel(len(p.tasks), parallelism, func(i int) error {
+		it, err := p.tasks[i]()
+		if err != nil {
+			return err
+		}
+		defer it.close()
+		parts[i], err = aggregateRows(it, a.groupIdxs, a.defs)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	groups := make([]*aggGroup, 0)
+	groupMap := make(map[string]*aggGroup)
+	for _, part := range parts {
+		for _, pg := range part {
+			k := groupKey(pg.keys)
+			ag, ok := groupMap[k]
+			if !ok {
+				groupMap[k] = pg
+				groups = append(groups, pg)
+				continue
+			}
+			for i, d := range a.defs {
+				d.merge(ag.states[i], pg.states[i])
+			}
+		}
+	}
+	if len(a.groupIdxs) == 0 && len(groups) == 0 {
+		groups = append(groups, newAggGroup([]string{}, len(a.defs)))
+	}
+	return groups, nil
+}
+
+// aggregate1() returns the state of the only aggregate of a over rows of the plan
+func (p *scanPlan) aggregate1(parallelism int, a *aggregation) (*aggState, error) {
+	groups, err := p.aggregate(parallelism, a)
+	if err != nil {
+		return nil, err
+	}
+	return groups[0].states[0], nil
+}
+
+/*
+planScan() adds tasks scanning rows of the table matching condition to p.
+An uncompressed file of 2 chunks or more is split into up to nchunks
+byte ranges starting at records. Files are not split when condition
+uses an index or the zone map, or the format allows quotes or comments
+which make record boundaries ambiguous.
+A split file is share-locked until p.close()
+*/
+func (t *CsvTable) planScan(p *scanPlan, condition interface{}, nchunks int) error {
+	if _, err := t.matcher(condition); err != nil {
+		return err
+	}
+	if !pathExist(t.path) {
+		return nil
+	}
+	scanAll := func() (rowIterator, error) {
+		return t.scanIter(condition)
+	}
+	if nchunks < 2 || !t.isSplittable(condition) {
+		p.tasks = append(p.tasks, scanAll)
+		return nil
+	}
+
+	lock, err := t.lock(false)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(t.path)
+	if err != nil {
+		lock.unlock()
+		return errors.WithStack(err)
+	}
+	if n := st.Size() / t.chunkBytes; n < int64(nchunks) {
+		nchunks = int(n)
+	}
+	if nchunks < 2 {
+		lock.unlock()
+		p.tasks = append(p.tasks, scanAll)
+		return nil
+	}
+	bounds, err := t.chunkBounds(st.Size(), nchunks)
+	if err != nil {
+		lock.unlock()
+		return err
+	}
+	p.locks = append(p.locks, lock)
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		p.tasks = append(p.tasks, func() (rowIterator, error) {
+			conditionCheckFunc, err := t.matcher(condition)
+			if err != nil {
+				return nil, err
+			}
+			it, err := t.newChunkIter(start, end)
+			if err != nil {
+				return nil, err
+			}
+			return newFilterIter(it, conditionCheckFunc), nil
+		})
+	}
+	return nil
+}
+
+// isSplittable() tells if scans of condition can read the table file by chunks
+func (t *CsvTable) isSplittable(condition interface{}) bool {
+	if t.codec != CCodecNone || t.format.LazyQuotes || t.format.Comment != 0 {
+		return false
+	}
+	if c, ok := condition.(*Cond); ok && c != nil {
+		return t.indexRange(c) == nil && !t.hasZoneMap()
+	}
+	return true
+}
+
+/*
+chunkBounds() splits the table file of size bytes into up to n ranges
+starting at records and returns the offsets of the ranges followed by size.
+Quotes of valid CSV come in pairs, so the parity of the quotes before
+an offset tells if it is in a quoted field. Quotes of the ranges are
+counted in parallel, then each range starts after the first line break
+out of quotes from its even offset.
+*/
+func (t *CsvTable) chunkBounds(size int64, n int) ([]int64, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	quotes := make([]int64, n)
+	if err := runParallel(n, n, func(i int) error {
+		start, end := size*int64(i)/int64(n), size*int64(i+1)/int64(n)
+		buf := make([]byte, 64<<10)
+		r := io.NewSectionReader(f, start, end-start)
+		for {
+			m, err := r.Read(buf)
+			quotes[i] += int64(bytes.Count(buf[:m], []byte{'"'}))
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	bounds := []int64{0}
+	nquotes := int64(0)
+	for i := 1; i < n; i++ {
+		nquotes += quotes[i-1]
+		start := size * int64(i) / int64(n)
+		if last := bounds[len(bounds)-1]; start <= last {
+			continue
+		}
+		next, err := nextRecord(f, start, size, nquotes%2 == 1)
+		if err != nil {
+			return nil, err
+		}
+		if next > bounds[len(bounds)-1] && next < size {
+			bounds = append(bounds, next)
+		}
+	}
+	return append(bounds, size), nil
+}
+
+// nextRecord() returns the offset after the first line break out of quotes
+// from offset, or size if there is none
+func nextRecord(f *os.File, offset, size int64, inQuote bool) (int64, error) {
+	buf := make([]byte, 64<<10)
+	r := io.NewSectionReader(f, offset, size-offset)
+	for {
+		m, err := r.Read(buf)
+		for i, b := range buf[:m] {
+			switch {
+			case b == '"':
+				inQuote = !inQuote
+			case b == '\n' && !inQuote:
+				return offset + int64(i) + 1, nil
+			}
+		}
+		offset += int64(m)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+}
+
+// chunkIter reads rows of the byte range [start, end) of the table file.
+// start must be at a record. The caller holds the lock of the file
+type chunkIter struct {
+	f      *os.File
+	reader *csv.Reader
+	values []string
+	err    error
+}
+
+func (t *CsvTable) newChunkIter(start, end int64) (*chunkIter, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	it := new(chunkIter)
+	it.f = f
+	it.reader = csv.NewReader(io.NewSectionReader(f, start, end-start))
+	t.format.applyReader(it.reader)
+	if start == 0 && t.hasHeader {
+		header, err := it.reader.Read()
+		if err == nil {
+			err = checkHeaderRow(header, t.columns, t.path)
+		}
+		if err != nil && err != io.EOF {
+			it.close()
+			return nil, errors.WithStack(err)
+		}
+	}
+	return it, nil
+}
+
+func (it *chunkIter) next() bool {
+	if it.err != nil {
+		return false
+	}
+	values, err := it.reader.Read()
+	if err != nil {
+		if err != io.EOF {
+			it.err = errors.WithStack(err)
+		}
+		return false
+	}
+	it.values = values
+	return true
+}
+
+func (it *chunkIter) current() []string {
+	return it.values
+}
+
+func (it *chunkIter) lastErr() error {
+	return it.err
+}
+
+func (it *chunkIter) close() {
+	if it.f != nil {
+		it.f.Close()
+		it.f = nil
+	}
+}
+
+// planScan() returns the tasks scanning rows of the tables of the group
+// matching condition. Tables are split into chunks when there are fewer
+// tables than goroutines
+func (g *CsvTableGroup) planScan(condition interface{}) (*scanPlan, error) {
+	if _, err := toMatcher(condition, g.getColMap(), g.columnTypes, g.nullValue); err != nil {
+		return nil, err
+	}
+	p := new(scanPlan)
+	tables := g.tables(condition)
+	nchunks := getParallelism(g.parallelism, condition)
+	if len(tables) > 1 {
+		nchunks /= len(tables)
+	}
+	for _, t := range tables {
+		if err := t.planScan(p, condition, nchunks); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
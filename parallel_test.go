@@ -0,0 +1,293 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRunParallel(t *testing.T) {
+	done := make([]bool, 10)
+	if err := runParallel(len(done), 3, func(i int) error {
+		done[i] = true
+		return nil
+	}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("%d is not called", i)
+			return
+		}
+	}
+	err := runParallel(10, 1, func(i int) error {
+		if i >= 4 {
+			return errors.Errorf("error %d", i)
+		}
+		return nil
+	})
+	if err := getGotExpErr("first error", fmt.Sprint(err), "error 4"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	rootDir, err := ensureTestDir("TestParallelScan")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+	db.SetParallelism(4)
+
+	g, err := db.CreateTypedGroup("events", []string{"id", "kind", "memo", "score"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeString, CColTypeFloat64}, false, 500)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetHeader(true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g.chunkBytes = 1 << 10
+
+	// memos have line breaks and quotes across chunks
+	for _, tableName := range []string{"events_a", "events_b"} {
+		tb, err := g.CreateTable(tableName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for i := 1; i <= 2000; i++ {
+			memo := fmt.Sprintf("memo %d", i)
+			if i%3 == 0 {
+				memo = strings.Repeat(fmt.Sprintf("line \"%d\",\n", i), i%7)
+			}
+			if err := tb.InsertRow(nil, i, fmt.Sprintf("k%d", i%5), memo, float64(i%100)/4); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	tb, err := g.GetTable("events_a")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	st, err := os.Stat(tb.path)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	bounds, err := tb.chunkBounds(st.Size(), 8)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("chunks", len(bounds), 9); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	offsets := map[int64]bool{st.Size(): true}
+	if err := tb.scanPositions(0, func(record []string, offset int64, row int) {
+		offsets[offset] = true
+	}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, bound := range bounds {
+		if !offsets[bound] {
+			t.Errorf("chunk at %d is not at a record", bound)
+			return
+		}
+	}
+	p := new(scanPlan)
+	if err := tb.planScan(p, nil, 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	p.close()
+	if err := getGotExpErr("table tasks", len(p.tasks), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if p, err = g.planScan(nil); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	p.close()
+	if err := getGotExpErr("group tasks", len(p.tasks), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	results := func(g *CsvTableGroup, tb *CsvTable) (string, error) {
+		res := []string{fmt.Sprint(tb.Count(nil), " ", g.Count(Like("memo", "%\"3\"%")))}
+		var sum float64
+		if err := g.Sum(Gt("id", 100), "score", &sum); err != nil {
+			return "", err
+		}
+		id := 0
+		if err := tb.Max(Lt("score", 10), "id", &id); err != nil {
+			return "", err
+		}
+		memo := ""
+		if err := g.Min(nil, "memo", &memo); err != nil {
+			return "", err
+		}
+		res = append(res, fmt.Sprint(sum, " ", id, " ", memo))
+		for _, a := range []interface {
+			Aggregate(interface{}, []string, ...AggSpec) (*CsvRows, error)
+		}{tb, g} {
+			r, err := a.Aggregate(nil, []string{"kind"},
+				AggSpec{Func: CAggCount}, AggSpec{Func: CAggAvg, Column: "score"},
+				AggSpec{Func: CAggFirst, Column: "id"}, AggSpec{Func: CAggLast, Column: "memo"})
+			if err != nil {
+				return "", err
+			}
+			for r.Next() {
+				var kind, last string
+				var cnt, first int
+				var avg float64
+				if err := r.Scan(&kind, &cnt, &avg, &first, &last); err != nil {
+					r.Close()
+					return "", err
+				}
+				res = append(res, fmt.Sprintf("%s %d %.3f %d %q", kind, cnt, avg, first, last))
+			}
+			r.Close()
+		}
+		return strings.Join(res, "\n"), nil
+	}
+	got, err := results(g, tb)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g.SetParallelism(1)
+	tb.SetParallelism(1)
+	exp, err := results(g, tb)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("parallel", got, exp); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", tb.Count(nil), 2000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// compressed files and indexed conditions are not split
+	tb.SetParallelism(4)
+	if err := tb.CreateIndex("id"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	p = new(scanPlan)
+	if err := tb.planScan(p, Eq("id", 3), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	p.close()
+	if err := getGotExpErr("indexed tasks", len(p.tasks), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("indexed count", tb.Count(Eq("id", 3)), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	gz, err := db.CreateTable("gzevents", []string{"id"}, true, 100)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i := 0; i < 1000; i++ {
+		if err := gz.InsertRow(nil, i); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := gz.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	gz.chunkBytes = 16
+	p = new(scanPlan)
+	if err := gz.planScan(p, nil, 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	p.close()
+	if err := getGotExpErr("gzip tasks", len(p.tasks), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("gzip count", gz.Count(nil), 1000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// function conditions are called in 1 goroutine by default
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	g.SetParallelism(0)
+	tb.SetParallelism(0)
+	tb.DropIndex("id")
+	if err := getGotExpErr("function parallelism", getParallelism(0, func([]string) bool { return true }), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("cond parallelism", getParallelism(0, Gt("id", 0)), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	calls := 0
+	odd := func(v []string) bool {
+		calls++
+		return strings.HasSuffix(v[0], "1") || strings.HasSuffix(v[0], "3") ||
+			strings.HasSuffix(v[0], "5") || strings.HasSuffix(v[0], "7") || strings.HasSuffix(v[0], "9")
+	}
+	if err := getGotExpErr("table function count", tb.Count(odd), 1000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("table function calls", calls, 2000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	calls = 0
+	if err := getGotExpErr("group function count", g.Count(odd), 2000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("group function calls", calls, 4000); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
@@ -0,0 +1,341 @@
+package csvdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+SetPartition() partitions tables of the group by the timestamp column.
+CsvTableGroup.InsertRow() routes each row to the table of its period,
+<groupName>_<period>, and creates the table on demand.
+Periods are in UTC and named by granularity:
+CPartitionHour: yyyymmddhh
+CPartitionDay: yyyymmdd
+CPartitionMonth: yyyymm
+
+Queries, updates and deletes of the group and SELECT skip tables of
+periods which cannot match *Cond conditions on the column.
+An empty column removes the partitioning.
+It can be set only before tables are created.
+*/
+func (g *CsvTableGroup) SetPartition(column, granularity string) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the partition can be set only before tables are created")
+	}
+	if column == "" {
+		g.partitionColumn = ""
+		g.partitionBy = ""
+		return nil
+	}
+	if err := validatePartition(g.columns, g.columnTypes, column, granularity); err != nil {
+		return err
+	}
+	g.partitionColumn = column
+	g.partitionBy = granularity
+	return nil
+}
+
+// Partition() returns the partition column and the granularity of the group
+func (g *CsvTableGroup) Partition() (string, string) {
+	return g.partitionColumn, g.partitionBy
+}
+
+/*
+SetRetention() keeps partitions of the latest n periods up to now,
+the current one included, and ApplyRetention() drops the older ones.
+0 keeps every partition. The retention is kept in the ini file.
+*/
+func (g *CsvTableGroup) SetRetention(n int) error {
+	if n < 0 {
+		return errors.Errorf("retention %d must not be negative", n)
+	}
+	g.retention = n
+	return g.save()
+}
+
+// Retention() returns the number of periods the group keeps
+func (g *CsvTableGroup) Retention() int {
+	return g.retention
+}
+
+func validatePartition(columns, columnTypes []string, column, granularity string) error {
+	if _, ok := partitionLayout(granularity); !ok {
+		return errors.Errorf("unknown partition granularity %s", granularity)
+	}
+	idx, ok := newColMap(columns)[column]
+	if !ok {
+		return errors.New(fmt.Sprintf("column %s does not exist", column))
+	}
+	if columnTypes[idx] != CColTypeTimestamp {
+		return errors.Errorf("partition column %s must be %s", column, CColTypeTimestamp)
+	}
+	return nil
+}
+
+// partitionLayout() returns the time layout of period names of granularity
+func partitionLayout(granularity string) (string, bool) {
+	switch granularity {
+	case CPartitionHour:
+		return "2006010215", true
+	case CPartitionDay:
+		return "20060102", true
+	case CPartitionMonth:
+		return "200601", true
+	}
+	return "", false
+}
+
+// truncatePeriod() returns the start of the period of tm
+func (g *CsvTableGroup) truncatePeriod(tm time.Time) time.Time {
+	tm = tm.UTC()
+	switch g.partitionBy {
+	case CPartitionHour:
+		return tm.Truncate(time.Hour)
+	case CPartitionMonth:
+		return time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// addPeriods() returns the start of the n-th period after start
+func (g *CsvTableGroup) addPeriods(start time.Time, n int) time.Time {
+	switch g.partitionBy {
+	case CPartitionHour:
+		return start.Add(time.Duration(n) * time.Hour)
+	case CPartitionMonth:
+		return start.AddDate(0, n, 0)
+	}
+	return start.AddDate(0, 0, n)
+}
+
+// partitionName() returns the table name of the period of tm
+func (g *CsvTableGroup) partitionName(tm time.Time) string {
+	layout, _ := partitionLayout(g.partitionBy)
+	return fmt.Sprintf("%s_%s", g.groupName, g.truncatePeriod(tm).Format(layout))
+}
+
+// partitionStart() returns the start of the period of a partition table.
+// ok is false for other tables
+func (g *CsvTableGroup) partitionStart(tableName string) (time.Time, bool) {
+	layout, ok := partitionLayout(g.partitionBy)
+	if !ok || g.partitionColumn == "" {
+		return time.Time{}, false
+	}
+	prefix := g.groupName + "_"
+	if !strings.HasPrefix(tableName, prefix) || len(tableName) != len(prefix)+len(layout) {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(layout, tableName[len(prefix):])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+/*
+InsertRow() inserts a row to the partition table of the period of
+the partition column and creates the table if it does not exist.
+Rows are buffered by table. Call Flush() after the last row.
+See CsvTable.InsertRow()
+*/
+func (g *CsvTableGroup) InsertRow(columns []string, args ...interface{}) error {
+	if g.partitionColumn == "" {
+		return errors.New(fmt.Sprintf("the group %s is not partitioned", g.groupName))
+	}
+	var v interface{}
+	if columns == nil {
+		if len(args) != len(g.columns) {
+			return errors.New("len of args do not match to table columns")
+		}
+		v = args[g.getColMap()[g.partitionColumn]]
+	} else {
+		if len(columns) != len(args) {
+			return errors.New("len of columns and args do not match")
+		}
+		for i, col := range columns {
+			if col == g.partitionColumn {
+				v = args[i]
+			}
+		}
+	}
+	s, err := typedString(CColTypeTimestamp, v)
+	if err != nil {
+		return err
+	}
+	if isNullOutput(g.nullValue, CColTypeTimestamp, s) {
+		return errors.Errorf("partition column %s cannot be NULL", g.partitionColumn)
+	}
+	tm, err := parseTimestamp(s)
+	if err != nil {
+		return err
+	}
+	t, err := g.partitionTable(g.partitionName(tm))
+	if err != nil {
+		return err
+	}
+	return t.InsertRow(columns, args...)
+}
+
+// Flush() writes rows buffered by InsertRow() of the group
+func (g *CsvTableGroup) Flush() error {
+	for _, t := range g.partTables {
+		if err := t.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitionTable() returns the table InsertRow() buffers rows of tableName to
+func (g *CsvTableGroup) partitionTable(tableName string) (*CsvTable, error) {
+	if t, ok := g.partTables[tableName]; ok {
+		return t, nil
+	}
+	t, err := g.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if g.partTables == nil {
+		g.partTables = make(map[string]*CsvTable)
+	}
+	g.partTables[tableName] = t
+	return t, nil
+}
+
+/*
+ApplyRetention() drops partition tables of periods before
+the retention of the group and returns their names.
+Partition files of the group without an entry in the ini file are
+dropped too. Rows buffered for dropped tables are discarded.
+*/
+func (g *CsvTableGroup) ApplyRetention() ([]string, error) {
+	return g.applyRetention(time.Now())
+}
+
+func (g *CsvTableGroup) applyRetention(now time.Time) ([]string, error) {
+	if g.retention == 0 || g.partitionColumn == "" {
+		return nil, nil
+	}
+	cutoff := g.addPeriods(g.truncatePeriod(now), 1-g.retention)
+
+	// oldest files first
+	_, paths := getSortedGlob(g.getTablePath(g.groupName + "_*"))
+	ext := filepath.Base(g.getTablePath(""))
+	tableNames := make([]string, 0, len(paths)+len(g.tableDefs))
+	found := make(map[string]bool, len(paths)+len(g.tableDefs))
+	for _, path := range paths {
+		tableName := strings.TrimSuffix(filepath.Base(path), ext)
+		tableNames = append(tableNames, tableName)
+		found[tableName] = true
+	}
+	// then tables not written yet
+	unwritten := make([]string, 0)
+	for tableName := range g.tableDefs {
+		if !found[tableName] {
+			unwritten = append(unwritten, tableName)
+		}
+	}
+	sort.Strings(unwritten)
+	tableNames = append(tableNames, unwritten...)
+
+	dropped := make([]string, 0)
+	for _, tableName := range tableNames {
+		start, ok := g.partitionStart(tableName)
+		if !ok || !start.Before(cutoff) {
+			continue
+		}
+		if err := g.newTable(tableName, g.getTablePath(tableName)).Drop(); err != nil {
+			return dropped, err
+		}
+		delete(g.tableDefs, tableName)
+		delete(g.partTables, tableName)
+		dropped = append(dropped, tableName)
+	}
+	if len(dropped) == 0 {
+		return dropped, nil
+	}
+	return dropped, g.save()
+}
+
+// partitionMayMatch() tells if rows of tableName may match condition.
+// Tables other than partitions may always match
+func (g *CsvTableGroup) partitionMayMatch(tableName string, condition interface{}) bool {
+	c, ok := condition.(*Cond)
+	if !ok || c == nil {
+		return true
+	}
+	start, ok := g.partitionStart(tableName)
+	if !ok {
+		return true
+	}
+	return g.periodMayMatch(c, start, g.addPeriods(start, 1))
+}
+
+// periodMayMatch() tells if timestamps in [start, end) may match c
+func (g *CsvTableGroup) periodMayMatch(c *Cond, start, end time.Time) bool {
+	if c == nil {
+		return true
+	}
+	switch c.op {
+	case cCondAnd:
+		for _, child := range c.children {
+			if !g.periodMayMatch(child, start, end) {
+				return false
+			}
+		}
+		return true
+	case cCondOr:
+		for _, child := range c.children {
+			if child == nil || g.periodMayMatch(child, start, end) {
+				return true
+			}
+		}
+		return len(c.children) == 0
+	case cCondNot:
+		return true
+	}
+	if c.column != g.partitionColumn {
+		return true
+	}
+	values := make([]time.Time, len(c.values))
+	for i, v := range c.values {
+		s, err := typedString(CColTypeTimestamp, v)
+		if err != nil || s == "" {
+			return true
+		}
+		tm, err := parseTimestamp(s)
+		if err != nil {
+			return true
+		}
+		values[i] = tm
+	}
+	inPeriod := func(tm time.Time) bool {
+		return !tm.Before(start) && tm.Before(end)
+	}
+	switch c.op {
+	case cCondEq:
+		return len(values) != 1 || inPeriod(values[0])
+	case cCondIn:
+		for _, tm := range values {
+			if inPeriod(tm) {
+				return true
+			}
+		}
+		return false
+	case cCondLt:
+		return len(values) != 1 || start.Before(values[0])
+	case cCondLe:
+		return len(values) != 1 || !start.After(values[0])
+	case cCondGt, cCondGe:
+		return len(values) != 1 || values[0].Before(end)
+	case cCondBetween:
+		return len(values) != 2 || (values[0].Before(end) && !values[1].Before(start))
+	}
+	return true
+}
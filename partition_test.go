@@ -0,0 +1,207 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	rootDir, err := ensureTestDir("TestPartition")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	g, err := db.CreateTypedGroup("sales", []string{"ts", "item", "amount"},
+		[]string{CColTypeTimestamp, CColTypeString, CColTypeInt64}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetPartition("item", CPartitionDay); err == nil {
+		t.Errorf("a string partition column must fail")
+		return
+	}
+	if err := g.SetPartition("ts", "week"); err == nil {
+		t.Errorf("an unknown granularity must fail")
+		return
+	}
+	if err := g.SetPartition("ts", CPartitionDay); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.InsertRow([]string{"item"}, "apple"); err == nil {
+		t.Errorf("a NULL partition value must fail")
+		return
+	}
+
+	day := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < 3; d++ {
+		for h := 0; h < 24; h += 6 {
+			if err := g.InsertRow(nil, day.AddDate(0, 0, d).Add(time.Duration(h)*time.Hour),
+				"apple", d+1); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+	}
+	// not in UTC
+	if err := g.InsertRow([]string{"amount", "ts"}, 10,
+		"2026-10-18T01:00:00+09:00"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetPartition("ts", CPartitionMonth); err == nil {
+		t.Errorf("the partition must not change after tables are created")
+		return
+	}
+	if err := getGotExpErr("tables", fmt.Sprint(g.getTablePaths()),
+		fmt.Sprintf("[%s/sales_20261015.csv %s/sales_20261016.csv %s/sales_20261017.csv]",
+			g.dataDir, g.dataDir, g.dataDir)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", g.Count(nil), 13); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	for _, c := range []struct {
+		cond   *Cond
+		tables int
+		count  int
+	}{
+		{Eq("ts", day.Add(6*time.Hour)), 1, 1},
+		{Ge("ts", day.AddDate(0, 0, 1)), 2, 9},
+		{Lt("ts", day.AddDate(0, 0, 1)), 1, 4},
+		{Between("ts", day.Add(18*time.Hour), day.AddDate(0, 0, 1).Add(time.Hour)), 2, 2},
+		{And(Gt("ts", day.AddDate(0, 0, 5)), Eq("item", "apple")), 0, 0},
+		{Or(Eq("item", "pear"), Lt("ts", day)), 3, 0},
+		{In("ts", day, day.AddDate(0, 0, 2)), 2, 2},
+		{Eq("amount", 10), 3, 1},
+	} {
+		title := fmt.Sprintf("%s %v", c.cond.op, c.cond.values)
+		if err := getGotExpErr(title+" tables", len(g.tables(c.cond)), c.tables); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" count", g.Count(c.cond), c.count); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	r, err := db.Query("SELECT SUM(amount) FROM sales WHERE ts >= ?", "2026-10-16")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	total := 0
+	if r.Next() {
+		if err := r.Scan(&total); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	r.Close()
+	if err := getGotExpErr("sql", total, 30); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the retention is kept in the ini file
+	if err := g.SetRetention(2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	db, err = NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g, err = db.GetGroup("sales")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	column, granularity := g.Partition()
+	if err := getGotExpErr("loaded", fmt.Sprint(column, " ", granularity, " ", g.Retention()),
+		"ts day 2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	dropped, err := g.applyRetention(day.AddDate(0, 0, 2).Add(time.Hour))
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("dropped", fmt.Sprint(dropped), "[sales_20261015]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count after retention", g.Count(nil), 9); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a partition created and dropped before its first flush
+	if err := g.InsertRow(nil, day.AddDate(0, 0, 3), "pear", 7); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	dropped, err = g.applyRetention(day.AddDate(1, 0, 0))
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	sort.Strings(dropped)
+	if err := getGotExpErr("dropped all", fmt.Sprint(dropped),
+		"[sales_20261016 sales_20261017 sales_20261018]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	db, err = NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g, err = db.GetGroup("sales")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("no tables", g.Count(nil), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.InsertRow(nil, day.AddDate(1, 0, 0), "pear", 8); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("inserted after retention", g.Count(nil), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
@@ -0,0 +1,345 @@
+package csvdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDuplicateKey is the cause of errors returned when rows of the same
+// primary key are written to a table rejecting duplicates.
+// Check it with errors.Cause(err) == ErrDuplicateKey
+var ErrDuplicateKey = errors.New("duplicate primary key")
+
+/*
+SetPrimaryKey() makes columns the primary key of tables of the group.
+Rows of a table cannot have the same key values nor NULL in them.
+Keys are unique within each table, not across tables of the group.
+
+onDuplicate:
+CDupReject: writes with duplicates fail with ErrDuplicateKey.
+Flush() writes the other rows of the buffer
+CDupReplace: a row replaces the stored row of the same key
+
+Duplicates are checked when rows are written to the table file,
+i.e. by Flush(), Update(), Upsert(), Import() and CsvTx.Commit().
+nil columns removes the primary key.
+It can be set only before tables are created.
+*/
+func (g *CsvTableGroup) SetPrimaryKey(columns []string, onDuplicate string) error {
+	if len(g.tableDefs) > 0 {
+		return errors.New("the primary key can be set only before tables are created")
+	}
+	if len(columns) == 0 {
+		g.primaryKey = nil
+		g.onDuplicate = ""
+		return nil
+	}
+	if err := validatePrimaryKey(g.columns, columns, onDuplicate); err != nil {
+		return err
+	}
+	g.primaryKey = columns
+	g.onDuplicate = onDuplicate
+	return nil
+}
+
+// PrimaryKey() returns the primary key columns of the group
+func (g *CsvTableGroup) PrimaryKey() []string {
+	return g.primaryKey
+}
+
+func validatePrimaryKey(columns, primaryKey []string, onDuplicate string) error {
+	if onDuplicate != CDupReject && onDuplicate != CDupReplace {
+		return errors.Errorf("unknown duplicate mode %s", onDuplicate)
+	}
+	colMap := newColMap(columns)
+	found := make(map[string]bool, len(primaryKey))
+	for _, col := range primaryKey {
+		if _, ok := colMap[col]; !ok {
+			return errors.New(fmt.Sprintf("column %s does not exist", col))
+		}
+		if found[col] {
+			return errors.Errorf("primary key column %s is duplicated", col)
+		}
+		found[col] = true
+	}
+	return nil
+}
+
+// setPrimaryKey() sets the primary key of the group to the table
+func (t *CsvTable) setPrimaryKey(primaryKey []string, onDuplicate string) {
+	t.primaryKey = primaryKey
+	t.onDuplicate = onDuplicate
+	t.keyIdxs = make([]int, len(primaryKey))
+	for i, col := range primaryKey {
+		t.keyIdxs[i] = t.colMap[col]
+	}
+}
+
+// keyOf() returns the primary key of row. Values equal as their types
+// such as "01" and "1" of int64 columns are the same key
+func (t *CsvTable) keyOf(row []string) (string, error) {
+	parts := make([]string, len(t.keyIdxs))
+	for i, idx := range t.keyIdxs {
+		if isNullOutput(t.nullValue, t.columnTypes[idx], row[idx]) {
+			return "", errors.Errorf("primary key column %s of %s is NULL",
+				t.columns[idx], t.tableName)
+		}
+		parts[i] = canonicalValue(t.columnTypes[idx], row[idx])
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+func (t *CsvTable) duplicateKeyError(key string) error {
+	return errors.Wrapf(ErrDuplicateKey, "table %s key (%s)",
+		t.tableName, strings.Replace(key, "\x00", ",", -1))
+}
+
+// uniqueRows() returns rows without duplicate keys. In the replace mode,
+// a row replaces the earlier row of the same key at its position
+func (t *CsvTable) uniqueRows(rows [][]string) ([][]string, error) {
+	found := make(map[string]int, len(rows))
+	uniq := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		key, err := t.keyOf(row)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := found[key]
+		if !ok {
+			found[key] = len(uniq)
+			uniq = append(uniq, row)
+			continue
+		}
+		if t.onDuplicate != CDupReplace {
+			return nil, t.duplicateKeyError(key)
+		}
+		uniq[i] = row
+	}
+	return uniq, nil
+}
+
+/*
+uniqueBuff() checks keys of the insert buffer and returns the write mode
+to write it. In the append mode, the table file is read once for the
+keys of the buffer only.
+Rows of NULL keys and, in the reject mode, rows of keys stored or buffered
+before are removed from the buffer, and the error of the first of them
+is returned as rejected. The rest of the buffer is left to be written.
+In the replace mode, rows replace buffered rows of the same key. If they
+replace stored rows, the buffer is set all rows and the file is rewritten.
+The caller must hold the exclusive lock
+*/
+func (t *CsvTable) uniqueBuff(wmode string) (string, error, error) {
+	var rejected error
+	reject := func(err error) {
+		if rejected == nil {
+			rejected = err
+		}
+	}
+	found := make(map[string]int, t.buff.pos+1)
+	keys := make([]string, 0, t.buff.pos+1)
+	uniq := make([][]string, 0, t.buff.pos+1)
+	for _, row := range t.buff.rows[:t.buff.pos+1] {
+		key, err := t.keyOf(row)
+		if err != nil {
+			reject(err)
+			continue
+		}
+		if i, ok := found[key]; ok {
+			if t.onDuplicate == CDupReplace {
+				uniq[i] = row
+			} else {
+				reject(t.duplicateKeyError(key))
+			}
+			continue
+		}
+		found[key] = len(uniq)
+		keys = append(keys, key)
+		uniq = append(uniq, row)
+	}
+
+	var stored map[string]bool
+	if wmode == CWriteModeAppend && len(uniq) > 0 {
+		var err error
+		if stored, err = t.storedKeys(found); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(stored) > 0 && t.onDuplicate == CDupReplace {
+		rows, err := t.readAll()
+		if err != nil {
+			return "", nil, err
+		}
+		for i, row := range rows {
+			if key, err := t.keyOf(row); err == nil && stored[key] {
+				rows[i] = uniq[found[key]]
+			}
+		}
+		for i, row := range uniq {
+			if !stored[keys[i]] {
+				rows = append(rows, row)
+			}
+		}
+		t.buff.setBuff(rows)
+		return CWriteModeWrite, rejected, nil
+	}
+	if len(stored) > 0 {
+		kept := make([][]string, 0, len(uniq))
+		for i, row := range uniq {
+			if stored[keys[i]] {
+				reject(t.duplicateKeyError(keys[i]))
+				continue
+			}
+			kept = append(kept, row)
+		}
+		uniq = kept
+	}
+	t.buff.setBuff(uniq)
+	return wmode, rejected, nil
+}
+
+// checkKeys() returns the error of the first of rows rejected by
+// the keys of rows before it or stored rows. The caller must hold the lock
+func (t *CsvTable) checkKeys(rows [][]string) error {
+	uniq, err := t.uniqueRows(rows)
+	if err != nil {
+		return err
+	}
+	if t.onDuplicate == CDupReplace {
+		return nil
+	}
+	found := make(map[string]int, len(uniq))
+	keys := make([]string, len(uniq))
+	for i, row := range uniq {
+		if keys[i], err = t.keyOf(row); err != nil {
+			return err
+		}
+		found[keys[i]] = i
+	}
+	stored, err := t.storedKeys(found)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if stored[key] {
+			return t.duplicateKeyError(key)
+		}
+	}
+	return nil
+}
+
+// readAll() returns all rows of the table file.
+// The caller must hold the lock
+func (t *CsvTable) readAll() ([][]string, error) {
+	reader, err := t.openR(false)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+	rows := make([][]string, 0)
+	for reader.next() {
+		rows = append(rows, reader.values)
+	}
+	if err := reader.lastErr(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// storedKeys() returns the primary keys of the stored rows in only,
+// or all of them if only is nil. The caller must hold the lock
+func (t *CsvTable) storedKeys(only map[string]int) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	if !pathExist(t.path) {
+		return keys, nil
+	}
+	reader, err := t.openR(false)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+	for reader.next() {
+		key, err := t.keyOf(reader.values)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := only[key]; ok || only == nil {
+			keys[key] = true
+		}
+	}
+	if err := reader.lastErr(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// rewriteUnique() rewrites the table file without duplicate keys,
+// the later rows replacing the earlier ones. The caller must hold the exclusive lock
+func (t *CsvTable) rewriteUnique() error {
+	rows, err := t.readAll()
+	if err != nil {
+		return err
+	}
+	buff := newInsertBuffer(len(rows))
+	buff.setBuff(rows)
+	orgBuff := t.buff
+	t.buff = buff
+	err = t.writeBuff(CWriteModeWrite)
+	t.buff = orgBuff
+	return err
+}
+
+// keyCondition() returns the condition matching the primary key keyvals.
+// It is a *Cond so that scans can use an index or the zone map
+func (t *CsvTable) keyCondition(keyvals []interface{}) (*Cond, error) {
+	if len(t.keyIdxs) == 0 {
+		return nil, errors.Errorf("table %s has no primary key", t.tableName)
+	}
+	if len(keyvals) != len(t.keyIdxs) {
+		return nil, errors.Errorf("%d key values while the primary key has %d columns",
+			len(keyvals), len(t.keyIdxs))
+	}
+	conds := make([]*Cond, len(keyvals))
+	for i, idx := range t.keyIdxs {
+		s, err := t.typedString(idx, keyvals[i])
+		if err != nil {
+			return nil, err
+		}
+		if keyvals[i] == nil || isNullOutput(t.nullValue, t.columnTypes[idx], s) {
+			return nil, errors.Errorf("primary key column %s is NULL", t.columns[idx])
+		}
+		conds[i] = Eq(t.columns[idx], s)
+	}
+	return And(conds...), nil
+}
+
+// GetByKey() returns the row of the primary key keyvals.
+// The rows are empty if the key is not found
+func (t *CsvTable) GetByKey(keyvals ...interface{}) (*CsvRows, error) {
+	condition, err := t.keyCondition(keyvals)
+	if err != nil {
+		return nil, err
+	}
+	r, err := t.SelectRows(condition, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.limit(0, 1)
+	return r, nil
+}
+
+// keyUpsertCondition() returns the condition matching the primary key
+// in updates for Upsert() without a condition
+func (t *CsvTable) keyUpsertCondition(updates map[string]interface{}) (*Cond, error) {
+	keyvals := make([]interface{}, len(t.primaryKey))
+	for i, col := range t.primaryKey {
+		v, ok := updates[col]
+		if !ok {
+			return nil, errors.Errorf("updates have no primary key column %s", col)
+		}
+		keyvals[i] = v
+	}
+	return t.keyCondition(keyvals)
+}
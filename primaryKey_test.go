@@ -0,0 +1,352 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestPrimaryKey(t *testing.T) {
+	rootDir, err := ensureTestDir("TestPrimaryKey")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	newGroup := func(groupName, onDuplicate string) (*CsvTable, error) {
+		g, err := db.CreateTypedGroup(groupName, []string{"shop", "id", "name"},
+			[]string{CColTypeString, CColTypeInt64, CColTypeString}, false, 10)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.SetPrimaryKey([]string{"shop", "id"}, onDuplicate); err != nil {
+			return nil, err
+		}
+		return g.CreateTable(groupName)
+	}
+	names := func(tb *CsvTable) string {
+		rows, err := tb.readRows(nil)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(rows)
+	}
+
+	// reject
+	tb, err := newGroup("rejected", CDupReject)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{"a", 1, "apple"}, {"a", 2, "orange"}, {"b", 1, "lemon"}} {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "a", 2, "grape"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); errors.Cause(err) != ErrDuplicateKey {
+		t.Errorf("a duplicate key must fail with ErrDuplicateKey, got %v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "a", nil, "grape"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err == nil {
+		t.Errorf("a NULL key must fail")
+		return
+	}
+	if err := getGotExpErr("rejected", names(tb), "[[a 1 apple] [a 2 orange] [b 1 lemon]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Update(Eq("name", "lemon"), map[string]interface{}{"shop": "a"}); errors.Cause(err) != ErrDuplicateKey {
+		t.Errorf("an update to a duplicate key must fail with ErrDuplicateKey, got %v", err)
+		return
+	}
+
+	// upsert by the key
+	if err := tb.Upsert(nil, map[string]interface{}{"shop": "a", "id": 2, "name": "peach"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Upsert(nil, map[string]interface{}{"shop": "c", "id": 1, "name": "melon"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Upsert(nil, map[string]interface{}{"name": "melon"}); err == nil {
+		t.Errorf("an upsert without the key must fail")
+		return
+	}
+	if err := getGotExpErr("upserted", names(tb),
+		"[[a 1 apple] [a 2 peach] [b 1 lemon] [c 1 melon]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	r, err := tb.GetByKey("a", 2)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	shop, id, name := "", 0, ""
+	for r.Next() {
+		if err := r.Scan(&shop, &id, &name); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	r.Close()
+	if err := getGotExpErr("got by key", name, "peach"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err = tb.GetByKey("x", 9)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if r.Next() {
+		t.Errorf("an unknown key must have no rows")
+	}
+	r.Close()
+	if _, err := tb.GetByKey("a"); err == nil {
+		t.Errorf("a partial key must fail")
+		return
+	}
+
+	// transactions
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.InsertRow(tb, nil, "b", 1, "grape"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Commit(); errors.Cause(err) != ErrDuplicateKey {
+		t.Errorf("a transaction of a duplicate key must fail with ErrDuplicateKey, got %v", err)
+		return
+	}
+
+	// replace
+	tb, err = newGroup("replaced", CDupReplace)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{"a", 1, "apple"}, {"a", 2, "orange"}, {"a", 1, "lemon"}} {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "a", 2, "grape"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("replaced", names(tb), "[[a 1 lemon] [a 2 grape]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	src := filepath.Join(rootDir, "src.csv")
+	if err := os.WriteFile(src, []byte("shop,id,name\na,1,peach\nb,1,melon\n"), 0644); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	res, err := tb.Import(src, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("imported", res.Imported, int64(2)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("imported rows", names(tb), "[[a 1 peach] [a 2 grape] [b 1 melon]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// the primary key is in the ini file
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g2, err := db2.GetGroup("replaced")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("primary key", fmt.Sprintf("%v %s", g2.PrimaryKey(), g2.onDuplicate),
+		"[shop id] replace"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g2.DropColumn("id"); err == nil {
+		t.Errorf("dropping a key column must fail")
+		return
+	}
+	if err := g2.RenameColumn("shop", "store"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	db3, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g3, err := db3.GetGroup("replaced")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("renamed primary key", fmt.Sprint(g3.PrimaryKey()), "[store id]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// only rows of duplicate keys are rejected. "02" and "2" are the same id
+	tb, err = newGroup("partial", CDupReject)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "a", 2, "apple"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{"a", 3, "kiwi"}, {"a", "02", "grape"},
+		{"b", 1, "plum"}, {"b", "01", "fig"}, {"a", nil, "lime"}} {
+		if err := tb.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := tb.Flush(); errors.Cause(err) != ErrDuplicateKey {
+		t.Errorf("a duplicate key must fail with ErrDuplicateKey, got %v", err)
+		return
+	}
+	if err := getGotExpErr("partially rejected", names(tb),
+		"[[a 2 apple] [a 3 kiwi] [b 1 plum]]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("the buffer must be empty after a flush: %v", err)
+		return
+	}
+
+	// keys are looked up by the index
+	if err := tb.CreateIndex("id"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	cond, err := tb.keyCondition([]interface{}{"b", "01"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if tb.indexRange(cond) == nil {
+		t.Errorf("the key condition must use the index")
+		return
+	}
+	if r, err = tb.GetByKey("b", "01"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	name = ""
+	for r.Next() {
+		if err := r.Scan(&shop, &id, &name); err != nil {
+			r.Close()
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	r.Close()
+	if err := getGotExpErr("got by the index", name, "plum"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// empty strings are keys in groups without a NULL string
+	if tb, err = newGroup("emptykey", CDupReject); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "", 1, "melon"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertRow(nil, "", 1, "peach"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); errors.Cause(err) != ErrDuplicateKey {
+		t.Errorf("a duplicate empty key must fail with ErrDuplicateKey, got %v", err)
+		return
+	}
+	if r, err = tb.GetByKey("", 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	name = ""
+	for r.Next() {
+		if err := r.Scan(&shop, &id, &name); err != nil {
+			r.Close()
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	r.Close()
+	if err := getGotExpErr("got by an empty key", name, "melon"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := tb.GetByKey(nil, 1); err == nil {
+		t.Errorf("a nil key must fail")
+		return
+	}
+}
@@ -0,0 +1,299 @@
+package csvdb
+
+import "time"
+
+// rowIterator is a source of rows for CsvRows
+type rowIterator interface {
+	next() bool
+	current() []string
+	// lastErr() returns nil after the last row
+	lastErr() error
+	close()
+}
+
+// tableIterator is a rowIterator which tells the table of the current row
+type tableIterator interface {
+	rowIterator
+	currentTable() string
+}
+
+// multiFileIter reads files one after another.
+// Files which do not exist are skipped.
+// Each file is read as it was when it was opened under a shared lock.
+type multiFileIter struct {
+	paths       []string
+	pos         int
+	reader      *CsvReader
+	err         error
+	lockTimeout time.Duration
+	// header is checked against the first row of each file if it is not nil
+	header []string
+	format CsvFormat
+}
+
+func newMultiFileIter(paths []string, lockTimeout time.Duration) *multiFileIter {
+	it := new(multiFileIter)
+	it.paths = paths
+	it.lockTimeout = lockTimeout
+	it.pos = -1
+	return it
+}
+
+func (it *multiFileIter) next() bool {
+	for it.err == nil {
+		if it.reader != nil {
+			if it.reader.next() {
+				return true
+			}
+			if err := it.reader.lastErr(); err != nil {
+				it.err = err
+				return false
+			}
+			it.reader.close()
+			it.reader = nil
+		}
+		it.pos++
+		if it.pos >= len(it.paths) {
+			return false
+		}
+		if !pathExist(it.paths[it.pos]) {
+			continue
+		}
+		reader, err := newLockedCsvReader(it.paths[it.pos], it.lockTimeout)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		reader.setFormat(it.format)
+		if it.header != nil {
+			if err := reader.checkHeader(it.header); err != nil {
+				reader.close()
+				it.err = err
+				return false
+			}
+		}
+		it.reader = reader
+	}
+	return false
+}
+
+func (it *multiFileIter) current() []string {
+	return it.reader.values
+}
+
+// currentPath() returns the path of the file the current row came from
+func (it *multiFileIter) currentPath() string {
+	return it.paths[it.pos]
+}
+
+func (it *multiFileIter) lastErr() error {
+	return it.err
+}
+
+func (it *multiFileIter) close() {
+	if it.reader != nil {
+		it.reader.close()
+		it.reader = nil
+	}
+	it.pos = len(it.paths)
+}
+
+// groupIter reads rows matching a condition from tables one after another.
+// Each table is scanned by CsvTable.scanIter() and tables which do not
+// exist are skipped.
+type groupIter struct {
+	tables    []*CsvTable
+	condition interface{}
+	pos       int
+	src       rowIterator
+	err       error
+}
+
+func newGroupIter(tables []*CsvTable, condition interface{}) *groupIter {
+	it := new(groupIter)
+	it.tables = tables
+	it.condition = condition
+	it.pos = -1
+	return it
+}
+
+func (it *groupIter) next() bool {
+	for it.err == nil {
+		if it.src != nil {
+			if it.src.next() {
+				return true
+			}
+			if err := it.src.lastErr(); err != nil {
+				it.err = err
+				return false
+			}
+			it.src.close()
+			it.src = nil
+		}
+		it.pos++
+		if it.pos >= len(it.tables) {
+			return false
+		}
+		if !pathExist(it.tables[it.pos].path) {
+			continue
+		}
+		src, err := it.tables[it.pos].scanIter(it.condition)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.src = src
+	}
+	return false
+}
+
+func (it *groupIter) current() []string {
+	return it.src.current()
+}
+
+func (it *groupIter) currentTable() string {
+	return it.tables[it.pos].tableName
+}
+
+func (it *groupIter) lastErr() error {
+	return it.err
+}
+
+func (it *groupIter) close() {
+	if it.src != nil {
+		it.src.close()
+		it.src = nil
+	}
+	it.pos = len(it.tables)
+}
+
+// filterIter passes rows matching conditionCheckFunc
+type filterIter struct {
+	src                rowIterator
+	conditionCheckFunc func([]string) bool
+}
+
+func newFilterIter(src rowIterator,
+	conditionCheckFunc func([]string) bool) rowIterator {
+	if conditionCheckFunc == nil {
+		return src
+	}
+	it := new(filterIter)
+	it.src = src
+	it.conditionCheckFunc = conditionCheckFunc
+	return it
+}
+
+func (it *filterIter) next() bool {
+	for it.src.next() {
+		if it.conditionCheckFunc(it.src.current()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *filterIter) current() []string {
+	return it.src.current()
+}
+
+func (it *filterIter) lastErr() error {
+	return it.src.lastErr()
+}
+
+func (it *filterIter) close() {
+	it.src.close()
+}
+
+// bufferIter iterates rows held in memory
+type bufferIter struct {
+	rows [][]string
+	pos  int
+}
+
+func newBufferIter(rows [][]string) *bufferIter {
+	it := new(bufferIter)
+	it.rows = rows
+	it.pos = -1
+	return it
+}
+
+func (it *bufferIter) next() bool {
+	if it.pos+1 >= len(it.rows) {
+		it.pos = len(it.rows)
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *bufferIter) current() []string {
+	return it.rows[it.pos]
+}
+
+func (it *bufferIter) lastErr() error {
+	return nil
+}
+
+func (it *bufferIter) close() {
+	it.rows = nil
+	it.pos = -1
+}
+
+// limitIter skips offset rows and stops after limit rows.
+// limit < 0 means no limit
+type limitIter struct {
+	src    rowIterator
+	offset int
+	limit  int
+	cnt    int
+}
+
+func newLimitIter(src rowIterator, offset, limit int) *limitIter {
+	it := new(limitIter)
+	it.src = src
+	it.offset = offset
+	it.limit = limit
+	return it
+}
+
+func (it *limitIter) next() bool {
+	for it.offset > 0 {
+		if !it.src.next() {
+			return false
+		}
+		it.offset--
+	}
+	if it.limit >= 0 && it.cnt >= it.limit {
+		return false
+	}
+	if !it.src.next() {
+		return false
+	}
+	it.cnt++
+	return true
+}
+
+func (it *limitIter) current() []string {
+	return it.src.current()
+}
+
+func (it *limitIter) lastErr() error {
+	return it.src.lastErr()
+}
+
+func (it *limitIter) close() {
+	it.src.close()
+}
+
+// drainIter reads all remaining rows of it
+func drainIter(it rowIterator) ([][]string, error) {
+	rows := make([][]string, 0)
+	for it.next() {
+		rows = append(rows, it.current())
+	}
+	if err := it.lastErr(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
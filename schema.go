@@ -0,0 +1,307 @@
+package csvdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AddColumn() adds an untyped column at the end of the columns.
+// See AddTypedColumn()
+func (g *CsvTableGroup) AddColumn(name string, defaultValue interface{}) error {
+	return g.AddTypedColumn(name, cColTypeUntyped, defaultValue)
+}
+
+// AddTypedColumn() adds a column of colType at the end of the columns.
+// Existing rows get defaultValue. nil is NULL
+func (g *CsvTableGroup) AddTypedColumn(name, colType string, defaultValue interface{}) error {
+	if !isValidColType(colType) {
+		return errors.Errorf("column %s has an unknown type %s", name, colType)
+	}
+	columns := append(append([]string{}, g.columns...), name)
+	columnTypes := append(append([]string{}, g.columnTypes...), colType)
+	t := g.newTable("", "")
+	t.columns = columns
+	t.columnTypes = columnTypes
+	t.colMap = newColMap(columns)
+	s, err := t.typedString(len(columns)-1, defaultValue)
+	if err != nil {
+		return err
+	}
+	return g.alterColumns(columns, columnTypes, g.primaryKey, func(row []string) []string {
+		return append(row, s)
+	})
+}
+
+// DropColumn() removes a column and its values
+func (g *CsvTableGroup) DropColumn(name string) error {
+	idx, ok := g.getColMap()[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("column %s does not exist", name))
+	}
+	if len(g.columns) == 1 {
+		return errors.Errorf("column %s is the last column", name)
+	}
+	for _, col := range g.primaryKey {
+		if col == name {
+			return errors.Errorf("column %s is in the primary key", name)
+		}
+	}
+	if name == g.partitionColumn {
+		return errors.Errorf("column %s is the partition column", name)
+	}
+	columns := make([]string, 0, len(g.columns)-1)
+	columnTypes := make([]string, 0, len(g.columns)-1)
+	for i := range g.columns {
+		if i != idx {
+			columns = append(columns, g.columns[i])
+			columnTypes = append(columnTypes, g.columnTypes[i])
+		}
+	}
+	return g.alterColumns(columns, columnTypes, g.primaryKey, func(row []string) []string {
+		return append(row[:idx], row[idx+1:]...)
+	})
+}
+
+// RenameColumn() renames a column. Only the ini file and header rows are rewritten
+func (g *CsvTableGroup) RenameColumn(oldName, newName string) error {
+	idx, ok := g.getColMap()[oldName]
+	if !ok {
+		return errors.New(fmt.Sprintf("column %s does not exist", oldName))
+	}
+	columns := append([]string{}, g.columns...)
+	columns[idx] = newName
+	primaryKey := append([]string{}, g.primaryKey...)
+	for i, col := range primaryKey {
+		if col == oldName {
+			primaryKey[i] = newName
+		}
+	}
+	partitionColumn := g.partitionColumn
+	if partitionColumn == oldName {
+		g.partitionColumn = newName
+	}
+	if err := g.alterColumns(columns, g.columnTypes, primaryKey, nil); err != nil {
+		g.partitionColumn = partitionColumn
+		return err
+	}
+	return nil
+}
+
+// ReorderColumns() reorders the columns to columns, which must have
+// every column of the group once
+func (g *CsvTableGroup) ReorderColumns(columns []string) error {
+	if len(columns) != len(g.columns) {
+		return errors.Errorf("%d columns while the group has %d", len(columns), len(g.columns))
+	}
+	colMap := g.getColMap()
+	idxs := make([]int, len(columns))
+	columnTypes := make([]string, len(columns))
+	for i, col := range columns {
+		idx, ok := colMap[col]
+		if !ok {
+			return errors.New(fmt.Sprintf("column %s does not exist", col))
+		}
+		idxs[i] = idx
+		columnTypes[i] = g.columnTypes[idx]
+	}
+	return g.alterColumns(columns, columnTypes, g.primaryKey, func(row []string) []string {
+		newRow := make([]string, len(idxs))
+		for i, idx := range idxs {
+			newRow[i] = row[idx]
+		}
+		return newRow
+	})
+}
+
+/*
+alterColumns() changes the columns of the group to columns.
+
+Every table file is rewritten by mapRow, which maps a row of the old
+columns to the new ones. Without mapRow, only header rows are rewritten.
+primaryKey is the primary key of the new columns.
+The new table files and the ini file are staged in a WAL and replace
+the old ones together, so NewCsvDB() completes a change interrupted by a crash.
+Indexes and zone maps are then rebuilt for the new columns, and those
+of dropped columns are removed.
+Tables got before the change must not be used after it.
+*/
+func (g *CsvTableGroup) alterColumns(columns, columnTypes, primaryKey []string,
+	mapRow func([]string) []string) error {
+	if err := validateColumns(columns); err != nil {
+		return err
+	}
+	columnTypes, err := normalizeColTypes(columns, columnTypes)
+	if err != nil {
+		return err
+	}
+	// the ini file is written with the first table
+	if len(g.tableDefs) == 0 {
+		g.columns, g.columnTypes, g.primaryKey = columns, columnTypes, primaryKey
+		return nil
+	}
+
+	if err := ensureDir(filepath.Join(g.rootDir, cWalDir)); err != nil {
+		return err
+	}
+	txid := fmt.Sprintf("%020d-%d", time.Now().UnixNano(), os.Getpid())
+	w := newWal(g.rootDir, txid)
+	txLock, err := lockFile(w.dir, true, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		txLock.unlock()
+		removeLockFile(w.dir)
+	}()
+
+	// the ini file first, then the tables in the order of the paths
+	iniLock, err := lockFile(g.iniFile, true, g.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer iniLock.unlock()
+	tableNames := make([]string, 0, len(g.tableDefs))
+	for tableName := range g.tableDefs {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Slice(tableNames, func(i, j int) bool {
+		return g.tableDefs[tableNames[i]].path < g.tableDefs[tableNames[j]].path
+	})
+	tables := make([]*CsvTable, len(tableNames))
+	for i, tableName := range tableNames {
+		tables[i] = g.newTable(tableName, g.tableDefs[tableName].path)
+		lock, err := tables[i].lock(true)
+		if err != nil {
+			return err
+		}
+		defer lock.unlock()
+	}
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := g.stageColumns(w, tables, columns, columnTypes, primaryKey, mapRow); err != nil {
+		w.remove()
+		return err
+	}
+	if err := w.writeManifest(); err != nil {
+		w.remove()
+		return err
+	}
+	g.columns, g.columnTypes, g.primaryKey = columns, columnTypes, primaryKey
+	if err := w.apply(); err != nil {
+		// the WAL is kept for NewCsvDB() to apply
+		return err
+	}
+	if err := w.remove(); err != nil {
+		return err
+	}
+
+	// rows moved, so indexes and zone maps are rebuilt for the new columns.
+	// Columns keep their positions when they are renamed
+	colMap := newColMap(columns)
+	for _, t := range tables {
+		newt := g.newTable(t.tableName, t.path)
+		for _, column := range t.indexColumns() {
+			if err := t.DropIndex(column); err != nil {
+				return err
+			}
+			if mapRow == nil {
+				column = columns[t.colMap[column]]
+			} else if _, ok := colMap[column]; !ok {
+				continue
+			}
+			if _, err := newt.buildIndex(column); err != nil {
+				return err
+			}
+		}
+		if newt.hasZoneMap() {
+			if _, err := newt.buildZoneMap(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stageColumns() stages the tables and the ini file of the new columns to w
+func (g *CsvTableGroup) stageColumns(w *wal, tables []*CsvTable,
+	columns, columnTypes, primaryKey []string, mapRow func([]string) []string) error {
+	for i, t := range tables {
+		if !pathExist(t.path) || (mapRow == nil && !t.hasHeader) {
+			continue
+		}
+		reader, err := t.openR(false)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0)
+		for reader.next() {
+			row := reader.values
+			if mapRow != nil {
+				row = mapRow(row)
+			}
+			rows = append(rows, row)
+		}
+		err = reader.lastErr()
+		reader.close()
+		if err != nil {
+			return err
+		}
+		newt := g.newTable(t.tableName, t.path)
+		newt.columns = columns
+		newt.columnTypes = columnTypes
+		newt.colMap = newColMap(columns)
+		e := &walEntry{op: cWalReplace, target: t.path, staged: w.stagedPath(i, t.path)}
+		if err := w.stageRows(e, newt, rows); err != nil {
+			return err
+		}
+	}
+
+	orgColumns, orgColumnTypes, orgPrimaryKey := g.columns, g.columnTypes, g.primaryKey
+	g.columns, g.columnTypes, g.primaryKey = columns, columnTypes, primaryKey
+	cfg, err := g.iniConfig()
+	g.columns, g.columnTypes, g.primaryKey = orgColumns, orgColumnTypes, orgPrimaryKey
+	if err != nil {
+		return err
+	}
+	e := &walEntry{op: cWalReplace, target: g.iniFile, staged: w.stagedPath(len(tables), g.iniFile)}
+	if err := writeFileAtomic(e.staged, 0640, func(wr io.Writer) error {
+		_, err := cfg.WriteTo(wr)
+		return errors.WithStack(err)
+	}); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, e)
+	return nil
+}
+
+// validateColumns() checks column names can be written in the ini file
+func validateColumns(columns []string) error {
+	found := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		if col == "" || strings.ContainsAny(col, ",\r\n") {
+			return errors.Errorf("invalid column name %q", col)
+		}
+		if found[col] {
+			return errors.Errorf("column %s is duplicated", col)
+		}
+		found[col] = true
+	}
+	return nil
+}
+
+func newColMap(columns []string) map[string]int {
+	colMap := make(map[string]int, len(columns))
+	for i, col := range columns {
+		colMap[col] = i
+	}
+	return colMap
+}
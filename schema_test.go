@@ -0,0 +1,243 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestAlterColumns(t *testing.T) {
+	rootDir, err := ensureTestDir("TestAlterColumns")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateTypedGroup("items", []string{"id", "name"},
+		[]string{CColTypeInt64, CColTypeString}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.SetHeader(true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i, tableName := range []string{"items_1", "items_2"} {
+		tb, err := g.CreateTable(tableName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.InsertRow(nil, i+1, fmt.Sprintf("name%d", i+1)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Flush(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	readFile := func(tableName string) string {
+		b, err := os.ReadFile(g.tableDefs[tableName].path)
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	}
+
+	if err := g.AddTypedColumn("price", CColTypeFloat64, 1.5); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.AddColumn("memo", nil); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("added", readFile("items_1"), "id,name,price,memo\n1,name1,1.5,\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.RenameColumn("name", "title"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.ReorderColumns([]string{"price", "id", "title", "memo"}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := g.DropColumn("memo"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("altered", readFile("items_2"), "price,id,title\n1.5,2,name2\n"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	for _, c := range []struct {
+		title string
+		f     func() error
+	}{
+		{"add an existing column", func() error { return g.AddColumn("id", nil) }},
+		{"add a column of an unknown type", func() error { return g.AddTypedColumn("x", "date", nil) }},
+		{"add a bad default", func() error { return g.AddTypedColumn("x", CColTypeInt64, "a") }},
+		{"add a bad name", func() error { return g.AddColumn("a,b", nil) }},
+		{"drop an unknown column", func() error { return g.DropColumn("memo") }},
+		{"rename to an existing column", func() error { return g.RenameColumn("id", "price") }},
+		{"reorder missing a column", func() error { return g.ReorderColumns([]string{"price", "id"}) }},
+		{"reorder a column twice", func() error { return g.ReorderColumns([]string{"price", "id", "id"}) }},
+	} {
+		if err := c.f(); err == nil {
+			t.Errorf("%s must fail", c.title)
+			return
+		}
+	}
+
+	// the new columns are in the ini file
+	db2, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	g2, err := db2.GetGroup("items")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("columns", fmt.Sprint(g2.columns, g2.columnTypes),
+		"[price id title] [float64 int64 string]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	r, err := db2.Query("SELECT title FROM items WHERE price = 1.5 ORDER BY id DESC")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	titles := []string{}
+	for r.Next() {
+		var title string
+		if err := r.Scan(&title); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		titles = append(titles, title)
+	}
+	if err := getGotExpErr("titles", fmt.Sprint(titles), "[name2 name1]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	files, err := os.ReadDir(db.walDir())
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("WAL files left", len(files), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// indexes and zone maps follow the columns
+	ig, err := db.CreateTypedGroup("indexed", []string{"id", "name"},
+		[]string{CColTypeInt64, CColTypeString}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	it, err := ig.CreateTable("indexed")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for i := 1; i <= 3; i++ {
+		if err := it.InsertRow(nil, i, fmt.Sprintf("name%d", i)); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := it.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, column := range []string{"id", "name"} {
+		if err := it.CreateIndex(column); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := it.CreateZoneMap(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	indexes := func() string {
+		it, err := ig.GetTable("indexed")
+		if err != nil {
+			return err.Error()
+		}
+		for _, column := range it.indexColumns() {
+			idx, err := it.loadIndex(column)
+			if err != nil || idx == nil || !idx.isValid(it.path) {
+				return fmt.Sprintf("the index of %s is not valid", column)
+			}
+		}
+		zm, err := it.loadZoneMap()
+		if err != nil || zm == nil || !zm.isValid(it.path) {
+			return "the zone map is not valid"
+		}
+		return fmt.Sprint(it.indexColumns())
+	}
+	if err := ig.RenameColumn("name", "title"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("renamed indexes", indexes(), "[id title]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if !pathExist(it.path+".title.idx") || pathExist(it.path+".name.idx") {
+		t.Errorf("the index sidecar must be renamed")
+		return
+	}
+	if err := ig.DropColumn("title"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if pathExist(it.path + ".title.idx") {
+		t.Errorf("the index sidecar of a dropped column must be removed")
+		return
+	}
+	if err := ig.AddColumn("title", "new"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("indexes after adding", indexes(), "[id]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	it, err = ig.GetTable("indexed")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count of the added column", it.Count(Eq("title", "new")), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count by the index", it.Count(Eq("id", 2)), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+}
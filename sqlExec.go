@@ -0,0 +1,507 @@
+package csvdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Query() runs a SELECT statement and returns its rows.
+// Table names are either a group name, which scans every table of the group,
+// or groupName.tableName.
+// ? placeholders are replaced by args.
+func (db *CsvDB) Query(query string, args ...interface{}) (*CsvRows, error) {
+	stmt, nParams, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if nParams != len(args) {
+		return nil, errors.Errorf("got %d args while expected %d", len(args), nParams)
+	}
+	s, ok := stmt.(*sqlSelect)
+	if !ok {
+		return nil, errors.New("Query() accepts only SELECT statements")
+	}
+	return db.execSelect(s, args)
+}
+
+// Exec() runs an INSERT, UPDATE, DELETE, CREATE TABLE or DROP TABLE
+// statement and returns the number of affected rows
+func (db *CsvDB) Exec(query string, args ...interface{}) (int64, error) {
+	stmt, nParams, err := parseSQL(query)
+	if err != nil {
+		return 0, err
+	}
+	if nParams != len(args) {
+		return 0, errors.Errorf("got %d args while expected %d", len(args), nParams)
+	}
+	switch s := stmt.(type) {
+	case *sqlInsert:
+		return db.execInsert(s, args)
+	case *sqlUpdate:
+		return db.execUpdate(s, args)
+	case *sqlDelete:
+		return db.execDelete(s, args)
+	case *sqlCreate:
+		return 0, db.execCreate(s)
+	case *sqlDrop:
+		return 0, db.execDrop(s)
+	}
+	return 0, errors.New("Exec() does not accept SELECT statements")
+}
+
+/*
+execTx() records an INSERT, UPDATE or DELETE statement in tx.
+The number of affected rows of UPDATE and DELETE is counted on the
+tables as they are, without operations of tx not committed yet.
+*/
+func (db *CsvDB) execTx(tx *CsvTx, query string, args ...interface{}) (int64, error) {
+	stmt, nParams, err := parseSQL(query)
+	if err != nil {
+		return 0, err
+	}
+	if nParams != len(args) {
+		return 0, errors.Errorf("got %d args while expected %d", len(args), nParams)
+	}
+	var table string
+	var where *Cond
+	switch s := stmt.(type) {
+	case *sqlInsert:
+		_, tables, err := db.resolveTables(s.table)
+		if err != nil {
+			return 0, err
+		}
+		t, err := singleTable(s.table, tables)
+		if err != nil {
+			return 0, err
+		}
+		for _, row := range s.rows {
+			if err := tx.InsertRow(t, s.columns, bindValues(row, args)...); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(s.rows)), nil
+	case *sqlUpdate:
+		table = s.table
+		where = bindCond(s.where, args)
+	case *sqlDelete:
+		table = s.table
+		where = bindCond(s.where, args)
+	default:
+		return 0, errors.New("only INSERT, UPDATE and DELETE can be run in transactions")
+	}
+
+	_, tables, err := db.resolveTables(table)
+	if err != nil {
+		return 0, err
+	}
+	cnt := int64(0)
+	for _, t := range tables {
+		// rows affected as the transaction sees them
+		n, err := tx.count(t, where)
+		if err != nil {
+			return 0, err
+		}
+		if s, ok := stmt.(*sqlUpdate); ok {
+			updates := make(map[string]interface{}, len(s.columns))
+			for i, col := range s.columns {
+				updates[col] = bindValue(s.values[i], args)
+			}
+			err = tx.Update(t, where, updates)
+		} else {
+			err = tx.Delete(t, where)
+		}
+		if err != nil {
+			return 0, err
+		}
+		cnt += int64(n)
+	}
+	return cnt, nil
+}
+
+// resolveTables() returns the group and the tables a statement refers to
+func (db *CsvDB) resolveTables(name string) (*CsvTableGroup, []*CsvTable, error) {
+	groupName := name
+	tableName := ""
+	if pos := strings.Index(name, "."); pos >= 0 {
+		groupName = name[:pos]
+		tableName = name[pos+1:]
+	}
+	g, err := db.GetGroup(groupName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tableNames := make([]string, 0, len(g.tableDefs))
+	if tableName != "" {
+		if _, ok := g.tableDefs[tableName]; !ok {
+			return nil, nil, errors.New(fmt.Sprintf("The table %s does not exist", name))
+		}
+		tableNames = append(tableNames, tableName)
+	} else {
+		for tableName := range g.tableDefs {
+			tableNames = append(tableNames, tableName)
+		}
+		sort.Strings(tableNames)
+	}
+
+	tables := make([]*CsvTable, len(tableNames))
+	for i, tableName := range tableNames {
+		t, err := g.GetTable(tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables[i] = t
+	}
+	return g, tables, nil
+}
+
+func bindValue(v interface{}, args []interface{}) interface{} {
+	if p, ok := v.(sqlParam); ok {
+		return args[p]
+	}
+	return v
+}
+
+func bindValues(vs []interface{}, args []interface{}) []interface{} {
+	bound := make([]interface{}, len(vs))
+	for i, v := range vs {
+		bound[i] = bindValue(v, args)
+	}
+	return bound
+}
+
+// bindCond() returns a copy of c with placeholders replaced by args
+func bindCond(c *Cond, args []interface{}) *Cond {
+	if c == nil {
+		return nil
+	}
+	b := new(Cond)
+	b.op = c.op
+	b.column = c.column
+	b.values = bindValues(c.values, args)
+	if c.children != nil {
+		b.children = make([]*Cond, len(c.children))
+		for i, child := range c.children {
+			b.children[i] = bindCond(child, args)
+		}
+	}
+	return b
+}
+
+func bindInt(v interface{}, args []interface{}) (int, error) {
+	var i int
+	if err := convFromString(asString(bindValue(v, args)), &i); err != nil {
+		return 0, errors.Wrap(err, "LIMIT and OFFSET must be integers")
+	}
+	return i, nil
+}
+
+func (db *CsvDB) execSelect(s *sqlSelect, args []interface{}) (*CsvRows, error) {
+	g, tables, err := db.resolveTables(s.from)
+	if err != nil {
+		return nil, err
+	}
+	colMap := g.getColMap()
+	where := bindCond(s.where, args)
+	conditionCheckFunc, err := toMatcher(where, colMap, g.columnTypes, g.nullValue)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := bindInt(s.limit, args)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := bindInt(s.offset, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// partitions of periods out of the condition are skipped
+	paths := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if g.partitionMayMatch(t.tableName, where) {
+			paths = append(paths, t.path)
+		}
+	}
+	iter := newFilterIter(g.newScanIter(paths), conditionCheckFunc)
+
+	isAggregate := len(s.groupBy) > 0
+	for _, item := range s.items {
+		if item.fn != "" {
+			isAggregate = true
+		}
+	}
+
+	var r *CsvRows
+	var orderCols []string
+	if isAggregate {
+		r, orderCols, err = selectAggregate(s, iter, g.columns, g.columnTypes, colMap, g.nullValue)
+	} else {
+		r, orderCols, err = selectColumns(s, iter, g.columns, g.columnTypes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.nullValue = g.nullValue
+	r.tmpDir = g.dataDir
+	r.SetSortMemory(db.sortMemory)
+	if len(orderCols) > 0 {
+		keys := make([]OrderSpec, len(orderCols))
+		for i, o := range s.orderBy {
+			keys[i] = OrderSpec{Field: orderCols[i], Direction: o.direction,
+				Nulls: o.nulls, Collation: o.collation}
+		}
+		if err := r.OrderByKeys(keys...); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	if offset > 0 || limit >= 0 {
+		r.limit(offset, limit)
+	}
+	return r, nil
+}
+
+// selectColumns() returns rows of the selected columns
+// and the columns to order them by
+func selectColumns(s *sqlSelect, iter rowIterator,
+	columns, columnTypes []string) (*CsvRows, []string, error) {
+	selectedCols := make([]string, 0, len(s.items))
+	labels := make([]string, 0, len(s.items))
+	for _, item := range s.items {
+		if item.column == "*" {
+			selectedCols = append(selectedCols, columns...)
+			labels = append(labels, columns...)
+			continue
+		}
+		selectedCols = append(selectedCols, item.column)
+		labels = append(labels, item.label)
+	}
+
+	orderCols := make([]string, len(s.orderBy))
+	for i, o := range s.orderBy {
+		switch {
+		case o.pos > 0:
+			if o.pos > len(selectedCols) {
+				iter.close()
+				return nil, nil, errors.Errorf("ORDER BY position %d is out of range", o.pos)
+			}
+			orderCols[i] = selectedCols[o.pos-1]
+		case o.item.fn != "":
+			iter.close()
+			return nil, nil, errors.Errorf("%s in ORDER BY needs GROUP BY", o.item)
+		default:
+			orderCols[i] = o.item.column
+			for j, label := range labels {
+				if label == o.item.column {
+					orderCols[i] = selectedCols[j]
+					break
+				}
+			}
+		}
+	}
+	r, err := newCsvRowsFromIter(iter, columns, columnTypes, selectedCols)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.labels = labels
+	return r, orderCols, nil
+}
+
+// selectAggregate() returns aggregated rows of the select list
+// and the columns to order them by
+func selectAggregate(s *sqlSelect, iter rowIterator, columns, columnTypes []string,
+	colMap map[string]int, nullValue string) (*CsvRows, []string, error) {
+	defer iter.close()
+
+	aggs := make([]AggSpec, 0)
+	// indexes of the aggregated columns: GROUP BY columns then aggregates
+	selIdxs := make([]int, len(s.items))
+	labels := make([]string, len(s.items))
+	for i, item := range s.items {
+		labels[i] = item.label
+		if item.fn == "" {
+			k := -1
+			for j, col := range s.groupBy {
+				if col == item.column {
+					k = j
+					break
+				}
+			}
+			if k < 0 {
+				return nil, nil, errors.Errorf("column %s must be in GROUP BY", item.column)
+			}
+			selIdxs[i] = k
+			continue
+		}
+		aggs = append(aggs, AggSpec{Func: item.fn, Column: item.column, As: item.label})
+		selIdxs[i] = len(s.groupBy) + len(aggs) - 1
+	}
+
+	orderPos := make([]int, len(s.orderBy))
+	for i, o := range s.orderBy {
+		if o.pos > 0 {
+			if o.pos > len(labels) {
+				return nil, nil, errors.Errorf("ORDER BY position %d is out of range", o.pos)
+			}
+			orderPos[i] = selIdxs[o.pos-1]
+			continue
+		}
+		found := false
+		for j, item := range s.items {
+			if labels[j] == o.item.String() ||
+				(item.fn == o.item.fn && item.column == o.item.column) {
+				orderPos[i] = selIdxs[j]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, errors.Errorf("ORDER BY %s is not in the select list", o.item)
+		}
+	}
+	r, err := aggregate(iter, columns, columnTypes, colMap, s.groupBy, aggs, nullValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	orderCols := make([]string, len(orderPos))
+	for i, pos := range orderPos {
+		orderCols[i] = r.tableCols[pos]
+	}
+	r.selectedColIndexes = selIdxs
+	r.labels = labels
+	return r, orderCols, nil
+}
+
+// singleTable() returns the only table of tables for INSERT
+func singleTable(name string, tables []*CsvTable) (*CsvTable, error) {
+	if len(tables) != 1 {
+		return nil, errors.Errorf("%s has %d tables. Use groupName.tableName", name, len(tables))
+	}
+	return tables[0], nil
+}
+
+func (db *CsvDB) execInsert(s *sqlInsert, args []interface{}) (int64, error) {
+	_, tables, err := db.resolveTables(s.table)
+	if err != nil {
+		return 0, err
+	}
+	t, err := singleTable(s.table, tables)
+	if err != nil {
+		return 0, err
+	}
+	// all rows are converted and their keys checked before any is written
+	// so that an invalid row does not leave a partial insert
+	rows := make([][]string, len(s.rows))
+	for i, row := range s.rows {
+		if rows[i], err = t.newRow(s.columns, bindValues(row, args)...); err != nil {
+			return 0, err
+		}
+	}
+	if err := t.insertRows(rows); err != nil {
+		return 0, err
+	}
+	return int64(len(s.rows)), nil
+}
+
+func (db *CsvDB) execUpdate(s *sqlUpdate, args []interface{}) (int64, error) {
+	_, tables, err := db.resolveTables(s.table)
+	if err != nil {
+		return 0, err
+	}
+	updates := make(map[string]interface{}, len(s.columns))
+	for i, col := range s.columns {
+		updates[col] = bindValue(s.values[i], args)
+	}
+	where := bindCond(s.where, args)
+	cnt := int64(0)
+	for _, t := range tables {
+		n, err := t.update(where, updates, false)
+		if err != nil {
+			return cnt, err
+		}
+		cnt += n
+	}
+	return cnt, nil
+}
+
+func (db *CsvDB) execDelete(s *sqlDelete, args []interface{}) (int64, error) {
+	_, tables, err := db.resolveTables(s.table)
+	if err != nil {
+		return 0, err
+	}
+	where := bindCond(s.where, args)
+	cnt := int64(0)
+	for _, t := range tables {
+		var n int64
+		var err error
+		if where == nil {
+			n = int64(t.Count(nil))
+			err = t.Truncate()
+		} else {
+			n, err = t.update(where, nil, false)
+		}
+		if err != nil {
+			return cnt, err
+		}
+		cnt += n
+	}
+	return cnt, nil
+}
+
+// splitTableName() splits groupName.tableName.
+// The group name of a plain table name is the table name
+func splitTableName(name string) (string, string) {
+	if pos := strings.Index(name, "."); pos >= 0 {
+		return name[:pos], name[pos+1:]
+	}
+	return name, name
+}
+
+func (db *CsvDB) execCreate(s *sqlCreate) error {
+	groupName, tableName := splitTableName(s.table)
+	g, ok := db.Groups[groupName]
+	if !ok {
+		_, err := db.createTable(groupName, tableName, s.columns, s.columnTypes,
+			false, cDefaultBuffSize)
+		return err
+	}
+	if _, ok := g.tableDefs[tableName]; ok {
+		if s.ifNotExists {
+			return nil
+		}
+		return errors.New(fmt.Sprintf("The table %s exists", s.table))
+	}
+	// columns of untyped groups are strings
+	if strings.Join(g.columns, ",") != strings.Join(s.columns, ",") ||
+		!sameColTypes(g.columnTypes, s.columnTypes) {
+		return errors.Errorf("columns of %s do not match those of the group %s",
+			s.table, groupName)
+	}
+	// the table gets the codec and the other settings of the group
+	_, err := g.CreateTable(tableName)
+	return err
+}
+
+func (db *CsvDB) execDrop(s *sqlDrop) error {
+	groupName, tableName := splitTableName(s.table)
+	g, ok := db.Groups[groupName]
+	if !ok || g.tableDefs[tableName] == nil {
+		if s.ifExists {
+			return nil
+		}
+		return errors.New(fmt.Sprintf("The table %s does not exist", s.table))
+	}
+	if err := g.DropTable(tableName); err != nil {
+		return err
+	}
+	delete(g.tableDefs, tableName)
+	if len(g.tableDefs) == 0 {
+		delete(db.Groups, groupName)
+		return g.Drop()
+	}
+	return g.save()
+}
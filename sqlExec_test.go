@@ -0,0 +1,323 @@
+package csvdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSQL(t *testing.T) {
+	rootDir, err := ensureTestDir("TestSQL")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateTypedGroup("sales",
+		[]string{"id", "day", "amount"},
+		[]string{CColTypeInt64, CColTypeString, CColTypeFloat64},
+		false, 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, tableName := range []string{"sales_1", "sales_2"} {
+		if _, err := g.CreateTable(tableName); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+
+	n, err := db.Exec(`INSERT INTO sales.sales_1 (id, day, amount)
+		VALUES (1, '2025-12-31', 100), (1, '2026-01-01', 10), (2, '2026-01-02', 20.5)`)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("inserted", n, int64(3)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{
+		{2, "2026-01-03", 1.5},
+		{3, "2026-01-03", 5},
+		{1, "2026-01-04", 30},
+	} {
+		if _, err := db.Exec("INSERT INTO sales.sales_2 VALUES (?, ?, ?)", row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if _, err := db.Exec("INSERT INTO sales VALUES (4, '2026-01-05', 1)"); err == nil {
+		t.Errorf("insert into a group of 2 tables must fail")
+		return
+	}
+
+	r, err := db.Query(`SELECT id, SUM(amount) FROM sales
+		WHERE day >= '2026-01-01' GROUP BY id ORDER BY 2 DESC LIMIT 2`)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("columns", len(r.Columns()), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	expected := [][]interface{}{
+		{int64(1), 40.0},
+		{int64(2), 22.0},
+	}
+	i := 0
+	for r.Next() {
+		var id int64
+		var sum float64
+		if err := r.Scan(&id, &sum); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("id", id, expected[i][0]); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr("sum", sum, expected[i][1]); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		i++
+	}
+	if err := getGotExpErr("rows", i, 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	var cnt int
+	var avg float64
+	r, err = db.Query(`SELECT count(*) AS cnt, avg(amount) FROM sales
+		WHERE id IN (2, 3) AND NOT day LIKE '2025%'`)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if !r.Next() {
+		t.Errorf("no aggregated row")
+		return
+	}
+	if err := r.Scan(&cnt, &avg); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("count", cnt, 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("avg", avg, 9.0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	r, err = db.Query("SELECT day AS d, id FROM sales WHERE amount BETWEEN ? AND ? ORDER BY d DESC",
+		5, 30)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("label", r.Columns()[0], "d"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	days := []string{}
+	for r.Next() {
+		var day string
+		var id int
+		if err := r.Scan(&day, &id); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		days = append(days, day)
+	}
+	if err := getGotExpErr("ordered days", len(days), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("first day", days[0], "2026-01-04"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	r, err = db.Query("SELECT id, day FROM sales ORDER BY id ASC, day DESC")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	days = []string{}
+	for r.Next() {
+		var day string
+		var id int
+		if err := r.Scan(&id, &day); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		days = append(days, day)
+	}
+	if err := getGotExpErr("mixed directions", days[0]+","+days[2]+","+days[3],
+		"2026-01-04,2025-12-31,2026-01-03"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	n, err = db.Exec("UPDATE sales SET amount = ? WHERE id = 1", 0)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("updated", n, int64(3)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	n, err = db.Exec("DELETE FROM sales WHERE amount = 0 OR id = 3")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("deleted", n, int64(4)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("remaining", g.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// an invalid row inserts nothing
+	if _, err := db.Exec(`INSERT INTO sales.sales_1 VALUES
+		(5, '2026-01-06', 1), ('x', '2026-01-06', 2)`); err == nil {
+		t.Errorf("insert of an invalid row must fail")
+		return
+	}
+	if err := getGotExpErr("no partial insert", g.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// a duplicate key inserts nothing
+	keyed, err := db.CreateTypedGroup("keyed", []string{"id", "name"},
+		[]string{CColTypeInt64, CColTypeString}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := keyed.SetPrimaryKey([]string{"id"}, CDupReject); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := keyed.CreateTable("keyed_1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := db.Exec("INSERT INTO keyed.keyed_1 VALUES (1, 'a')"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, query := range []string{
+		"INSERT INTO keyed.keyed_1 VALUES (2, 'b'), (1, 'c')",
+		"INSERT INTO keyed.keyed_1 VALUES (2, 'b'), (2, 'c')",
+	} {
+		if _, err := db.Exec(query); errors.Cause(err) != ErrDuplicateKey {
+			t.Errorf("%s must fail with ErrDuplicateKey, got %v", query, err)
+			return
+		}
+		if err := getGotExpErr("no partial insert of keys", keyed.Count(nil), 1); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if n, err = db.Exec("INSERT INTO keyed.keyed_1 VALUES (2, 'b'), (3, 'c')"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("inserted keys", n, int64(2)); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// tables created in untyped groups have the settings of the group
+	logs, err := db.CreateGroup("logs", []string{"id", "msg"}, true, 5)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := logs.CreateTable("logs_1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := db.Exec("CREATE TABLE logs.logs_2 (id, msg VARCHAR(10))"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := db.Exec("CREATE TABLE logs.logs_3 (id INT, msg)"); err == nil {
+		t.Errorf("create of a table of other types must fail")
+		return
+	}
+	tb, err := logs.GetTable("logs_2")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("codec", tb.codec, CCodecGzip); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	packed, err := db.CreateGroup("packed", []string{"id", "msg"}, false, 5)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := packed.SetCodec(CCodecZstd, 9); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := packed.CreateTable("packed_1"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if _, err := db.Exec("CREATE TABLE packed.packed_2 (id, msg)"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if tb, err = packed.GetTable("packed_2"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("zstd codec", fmt.Sprint(tb.codec, " ", tb.codecLevel, " ", filepath.Ext(tb.path)),
+		"zstd 9 .zst"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	for _, query := range []string{
+		"SELECT id FROM nothing",
+		"SELECT nothing FROM sales",
+		"SELECT id, SUM(amount) FROM sales",
+		"SELECT id FROM sales WHERE",
+		"SELECT id FROM sales ORDER BY id NULLS",
+	} {
+		if r, err := db.Query(query); err == nil {
+			r.Close()
+			t.Errorf("%s must fail", query)
+			return
+		}
+	}
+}
@@ -0,0 +1,812 @@
+package csvdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokSymbol
+	tokParam
+)
+
+type sqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// sqlParam is the index of a ? placeholder
+type sqlParam int
+
+type sqlSelectItem struct {
+	fn     string
+	column string
+	label  string
+}
+
+type sqlOrderItem struct {
+	item      *sqlSelectItem
+	pos       int
+	direction int
+	nulls     int
+	collation string
+}
+
+type sqlSelect struct {
+	items   []*sqlSelectItem
+	from    string
+	where   *Cond
+	groupBy []string
+	orderBy []*sqlOrderItem
+	limit   interface{}
+	offset  interface{}
+}
+
+type sqlInsert struct {
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
+type sqlUpdate struct {
+	table   string
+	columns []string
+	values  []interface{}
+	where   *Cond
+}
+
+type sqlDelete struct {
+	table string
+	where *Cond
+}
+
+type sqlCreate struct {
+	table       string
+	ifNotExists bool
+	columns     []string
+	columnTypes []string
+}
+
+type sqlDrop struct {
+	table    string
+	ifExists bool
+}
+
+type sqlParser struct {
+	query   string
+	tokens  []sqlToken
+	pos     int
+	nParams int
+}
+
+func tokenizeSQL(query string) ([]sqlToken, error) {
+	tokens := make([]sqlToken, 0)
+	rs := []rune(query)
+	i := 0
+	for i < len(rs) {
+		r := rs[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) ||
+				rs[i] == '_' || rs[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{tokIdent, string(rs[start:i]), start})
+			continue
+		case unicode.IsDigit(r) ||
+			((r == '-' || r == '.') && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			i++
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' ||
+				rs[i] == 'e' || rs[i] == 'E' ||
+				((rs[i] == '-' || rs[i] == '+') && (rs[i-1] == 'e' || rs[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{tokNumber, string(rs[start:i]), start})
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(rs) {
+				if rs[i] == r {
+					if i+1 < len(rs) && rs[i+1] == r {
+						b.WriteRune(r)
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			if !closed {
+				return nil, errors.Errorf("unterminated quote at %d", start)
+			}
+			kind := tokQuotedIdent
+			if r == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, sqlToken{kind, b.String(), start})
+			continue
+		case r == '?':
+			i++
+			tokens = append(tokens, sqlToken{tokParam, "?", start})
+			continue
+		}
+
+		if i+1 < len(rs) {
+			two := string(rs[i : i+2])
+			switch two {
+			case "<=", ">=", "!=", "<>":
+				if two == "<>" {
+					two = cCondNe
+				}
+				tokens = append(tokens, sqlToken{tokSymbol, two, start})
+				i += 2
+				continue
+			}
+		}
+		switch r {
+		case '=', '<', '>', ',', '(', ')', '*', ';':
+			tokens = append(tokens, sqlToken{tokSymbol, string(r), start})
+			i++
+		default:
+			return nil, errors.Errorf("unexpected character %q at %d", r, start)
+		}
+	}
+	tokens = append(tokens, sqlToken{tokEOF, "", len(rs)})
+	return tokens, nil
+}
+
+// parseSQL() parses a statement into *sqlSelect, *sqlInsert,
+// *sqlUpdate, *sqlDelete, *sqlCreate or *sqlDrop
+func parseSQL(query string) (interface{}, int, error) {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := new(sqlParser)
+	p.query = query
+	p.tokens = tokens
+
+	var stmt interface{}
+	switch {
+	case p.acceptKeyword("SELECT"):
+		stmt, err = p.parseSelect()
+	case p.acceptKeyword("INSERT"):
+		stmt, err = p.parseInsert()
+	case p.acceptKeyword("UPDATE"):
+		stmt, err = p.parseUpdate()
+	case p.acceptKeyword("DELETE"):
+		stmt, err = p.parseDelete()
+	case p.acceptKeyword("CREATE"):
+		stmt, err = p.parseCreate()
+	case p.acceptKeyword("DROP"):
+		stmt, err = p.parseDrop()
+	default:
+		return nil, 0, p.errorf("SELECT, INSERT, UPDATE, DELETE, CREATE or DROP expected")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, 0, p.errorf("end of statement expected")
+	}
+	return stmt, p.nParams, nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) advance() sqlToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *sqlParser) errorf(format string, args ...interface{}) error {
+	tok := p.peek()
+	near := tok.text
+	if tok.kind == tokEOF {
+		near = "end of statement"
+	}
+	return errors.Errorf("sql syntax error near %q at %d: %s",
+		near, tok.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *sqlParser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, kw)
+}
+
+func (p *sqlParser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf("%s expected", kw)
+	}
+	return nil
+}
+
+func (p *sqlParser) acceptSymbol(sym string) bool {
+	tok := p.peek()
+	if tok.kind == tokSymbol && tok.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return p.errorf("%s expected", sym)
+	}
+	return nil
+}
+
+func (p *sqlParser) parseIdent() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent && tok.kind != tokQuotedIdent {
+		return "", p.errorf("identifier expected")
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+func (p *sqlParser) parseIdentList() ([]string, error) {
+	idents := make([]string, 0)
+	for {
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		idents = append(idents, ident)
+		if !p.acceptSymbol(",") {
+			return idents, nil
+		}
+	}
+}
+
+// parseValue() parses a literal or a placeholder
+func (p *sqlParser) parseValue() (interface{}, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.pos++
+		if i, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %s", tok.text)
+		}
+		return f, nil
+	case tokString:
+		p.pos++
+		return tok.text, nil
+	case tokParam:
+		p.pos++
+		p.nParams++
+		return sqlParam(p.nParams - 1), nil
+	case tokIdent:
+		switch strings.ToUpper(tok.text) {
+		case "TRUE":
+			p.pos++
+			return true, nil
+		case "FALSE":
+			p.pos++
+			return false, nil
+		case "NULL":
+			p.pos++
+			return nil, nil
+		}
+	}
+	return nil, p.errorf("value expected")
+}
+
+func (p *sqlParser) parseValueList() ([]interface{}, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0)
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseItem() parses a column, * or an aggregate function call
+func (p *sqlParser) parseItem() (*sqlSelectItem, error) {
+	item := new(sqlSelectItem)
+	if p.acceptSymbol("*") {
+		item.column = "*"
+		return item, nil
+	}
+	tok := p.peek()
+	if tok.kind == tokIdent && p.tokens[p.pos+1].kind == tokSymbol &&
+		p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		item.fn = strings.ToLower(tok.text)
+		if p.acceptSymbol("*") {
+			item.column = "*"
+		} else {
+			col, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			item.column = col
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+	col, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	item.column = col
+	return item, nil
+}
+
+func (item *sqlSelectItem) String() string {
+	if item.fn == "" {
+		return item.column
+	}
+	return fmt.Sprintf("%s(%s)", item.fn, item.column)
+}
+
+func (p *sqlParser) parseSelect() (*sqlSelect, error) {
+	s := new(sqlSelect)
+	s.limit = int64(-1)
+	s.offset = int64(0)
+	for {
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("AS") {
+			label, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			item.label = label
+		} else {
+			item.label = item.String()
+		}
+		s.items = append(s.items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	from, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.from = from
+
+	if p.acceptKeyword("WHERE") {
+		if s.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if s.groupBy, err = p.parseIdentList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			o := new(sqlOrderItem)
+			o.direction = CorderByAsc
+			if tok := p.peek(); tok.kind == tokNumber {
+				p.pos++
+				pos, err := strconv.Atoi(tok.text)
+				if err != nil || pos < 1 {
+					return nil, p.errorf("bad column position %s", tok.text)
+				}
+				o.pos = pos
+			} else if o.item, err = p.parseItem(); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("COLLATE") {
+				tok := p.advance()
+				if tok.kind != tokIdent && tok.kind != tokQuotedIdent && tok.kind != tokString {
+					return nil, p.errorf("bad collation %s", tok.text)
+				}
+				o.collation = tok.text
+			}
+			if p.acceptKeyword("DESC") {
+				o.direction = CorderByDesc
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			if p.acceptKeyword("NULLS") {
+				if p.acceptKeyword("FIRST") {
+					o.nulls = CNullsFirst
+				} else if err := p.expectKeyword("LAST"); err != nil {
+					return nil, err
+				} else {
+					o.nulls = CNullsLast
+				}
+			}
+			s.orderBy = append(s.orderBy, o)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if s.limit, err = p.parseValue(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("OFFSET") {
+			if s.offset, err = p.parseValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func (p *sqlParser) parseInsert() (*sqlInsert, error) {
+	s := new(sqlInsert)
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.table = table
+	if p.acceptSymbol("(") {
+		if s.columns, err = p.parseIdentList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		row, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		s.rows = append(s.rows, row)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return s, nil
+}
+
+func (p *sqlParser) parseUpdate() (*sqlUpdate, error) {
+	s := new(sqlUpdate)
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.table = table
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		s.columns = append(s.columns, col)
+		s.values = append(s.values, v)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("WHERE") {
+		if s.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *sqlParser) parseDelete() (*sqlDelete, error) {
+	s := new(sqlDelete)
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.table = table
+	if p.acceptKeyword("WHERE") {
+		if s.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// sqlColType() maps SQL type names to column types
+func sqlColType(typeName string) (string, bool) {
+	switch strings.ToUpper(typeName) {
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT":
+		return CColTypeInt64, true
+	case "REAL", "FLOAT", "DOUBLE", "NUMBER", "NUMERIC", "DECIMAL":
+		return CColTypeFloat64, true
+	case "TEXT", "VARCHAR", "CHAR", "STRING", "CLOB":
+		return CColTypeString, true
+	case "BOOL", "BOOLEAN":
+		return CColTypeBool, true
+	case "TIMESTAMP", "DATETIME", "DATE":
+		return CColTypeTimestamp, true
+	}
+	return "", false
+}
+
+func (p *sqlParser) parseCreate() (*sqlCreate, error) {
+	s := new(sqlCreate)
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("NOT"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		s.ifNotExists = true
+	}
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.table = table
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		colType := CColTypeString
+		if tok := p.peek(); tok.kind == tokIdent {
+			p.pos++
+			var ok bool
+			if colType, ok = sqlColType(tok.text); !ok {
+				p.pos--
+				return nil, p.errorf("unknown type %s", tok.text)
+			}
+			// sizes like VARCHAR(255) are ignored
+			if p.acceptSymbol("(") {
+				for !p.acceptSymbol(")") {
+					if p.advance().kind == tokEOF {
+						return nil, p.errorf(") expected")
+					}
+				}
+			}
+		}
+		s.columns = append(s.columns, col)
+		s.columnTypes = append(s.columnTypes, colType)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *sqlParser) parseDrop() (*sqlDrop, error) {
+	s := new(sqlDrop)
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		s.ifExists = true
+	}
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	s.table = table
+	return s, nil
+}
+
+func (p *sqlParser) parseOr() (*Cond, error) {
+	c, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	conds := []*Cond{c}
+	for p.acceptKeyword("OR") {
+		c, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return Or(conds...), nil
+}
+
+func (p *sqlParser) parseAnd() (*Cond, error) {
+	c, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	conds := []*Cond{c}
+	for p.acceptKeyword("AND") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return And(conds...), nil
+}
+
+func (p *sqlParser) parseNot() (*Cond, error) {
+	if p.acceptKeyword("NOT") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not(c), nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *sqlParser) parsePredicate() (*Cond, error) {
+	if p.acceptSymbol("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	col, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("IS") {
+		negate := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		if negate {
+			return IsNotNull(col), nil
+		}
+		return IsNull(col), nil
+	}
+
+	negate := p.acceptKeyword("NOT")
+	var c *Cond
+	switch {
+	case p.acceptKeyword("BETWEEN"):
+		from, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		to, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		c = Between(col, from, to)
+	case p.acceptKeyword("IN"):
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		c = In(col, values...)
+	case p.acceptKeyword("LIKE"):
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		c = newColCond(cCondLike, col, v)
+	default:
+		if negate {
+			return nil, p.errorf("BETWEEN, IN or LIKE expected")
+		}
+		tok := p.peek()
+		if tok.kind != tokSymbol {
+			return nil, p.errorf("comparison operator expected")
+		}
+		switch tok.text {
+		case cCondEq, cCondNe, cCondLt, cCondLe, cCondGt, cCondGe:
+		default:
+			return nil, p.errorf("comparison operator expected")
+		}
+		p.pos++
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		c = newColCond(tok.text, col, v)
+	}
+	if negate {
+		return Not(c), nil
+	}
+	return c, nil
+}
@@ -0,0 +1,306 @@
+package csvdb
+
+import (
+	"database/sql"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Structs are mapped to columns by `csv:"column"` tags.
+Fields without the tag are mapped to the columns of their names,
+fields tagged `csv:"-"` and unexported fields are skipped.
+Fields of embedded structs are mapped as fields of the struct.
+Pointer fields and sql.Null* fields are NULL when they are nil or not valid.
+*/
+
+// structField is a field of a struct mapped to a column
+type structField struct {
+	column  string
+	index   []int
+	colType string
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// structFields() returns the fields of the struct type typ
+func structFields(typ reflect.Type) ([]*structField, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, errors.Errorf("%s is not a struct", typ)
+	}
+	fields := make([]*structField, 0, typ.NumField())
+	found := make(map[string]bool)
+	var walk func(typ reflect.Type, index []int) error
+	walk = func(typ reflect.Type, index []int) error {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			tag := f.Tag.Get("csv")
+			if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			fieldIndex := append(append([]int{}, index...), i)
+			if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+				if err := walk(f.Type, fieldIndex); err != nil {
+					return err
+				}
+				continue
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			column := f.Name
+			if tag != "" {
+				column = tag
+			}
+			if found[column] {
+				return errors.Errorf("column %s is mapped from 2 fields of %s", column, typ)
+			}
+			found[column] = true
+			fields = append(fields, &structField{column, fieldIndex, goColType(f.Type)})
+		}
+		return nil
+	}
+	if err := walk(typ, nil); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.Errorf("%s has no fields to map", typ)
+	}
+	return fields, nil
+}
+
+// goColType() returns the column type of values of the Go type typ
+func goColType(typ reflect.Type) string {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ {
+	case timeType, reflect.TypeOf(sql.NullTime{}):
+		return CColTypeTimestamp
+	case reflect.TypeOf(sql.NullString{}):
+		return CColTypeString
+	case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}):
+		return CColTypeInt64
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return CColTypeFloat64
+	case reflect.TypeOf(sql.NullBool{}):
+		return CColTypeBool
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return CColTypeInt64
+	case reflect.Float32, reflect.Float64:
+		return CColTypeFloat64
+	case reflect.Bool:
+		return CColTypeBool
+	case reflect.String:
+		return CColTypeString
+	}
+	return cColTypeUntyped
+}
+
+// structValue() returns the struct v or *v points to
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return rv, errors.New("nil struct pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return rv, errors.Errorf("%T is not a struct", v)
+	}
+	return rv, nil
+}
+
+// fieldValue() returns the value to store of a field. nil pointers are nil
+func fieldValue(fv reflect.Value) interface{} {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+	return fv.Interface()
+}
+
+// CreateGroupFromStruct() creates a typed group of the columns
+// mapped from the struct v. See CreateTypedGroup()
+func (db *CsvDB) CreateGroupFromStruct(groupName string, v interface{},
+	useGzip bool, bufferSize int) (*CsvTableGroup, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil {
+		return nil, errors.New("nil struct")
+	}
+	fields, err := structFields(typ)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(fields))
+	columnTypes := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = f.column
+		columnTypes[i] = f.colType
+	}
+	if err := validateColumns(columns); err != nil {
+		return nil, err
+	}
+	return db.CreateTypedGroup(groupName, columns, columnTypes, useGzip, bufferSize)
+}
+
+// InsertStruct() inserts the fields of the struct v or *v as a row.
+// Columns without fields are NULL.
+func (t *CsvTable) InsertStruct(v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	fields, err := t.structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	return t.insertStruct(rv, fields)
+}
+
+// InsertStructs() inserts each struct of the slice structs as a row
+func (t *CsvTable) InsertStructs(structs interface{}) error {
+	sv := reflect.ValueOf(structs)
+	if sv.Kind() != reflect.Slice {
+		return errors.Errorf("%T is not a slice", structs)
+	}
+	var fields []*structField
+	for i := 0; i < sv.Len(); i++ {
+		rv, err := structValue(sv.Index(i).Interface())
+		if err != nil {
+			return errors.Wrapf(err, "element %d", i)
+		}
+		if fields == nil {
+			if fields, err = t.structFields(rv.Type()); err != nil {
+				return err
+			}
+		}
+		if err := t.insertStruct(rv, fields); err != nil {
+			return errors.Wrapf(err, "element %d", i)
+		}
+	}
+	return nil
+}
+
+func (t *CsvTable) insertStruct(rv reflect.Value, fields []*structField) error {
+	columns := make([]string, len(fields))
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		columns[i] = f.column
+		args[i] = fieldValue(rv.FieldByIndex(f.index))
+	}
+	return t.InsertRow(columns, args...)
+}
+
+// structFields() returns the fields of typ. Every field must be a column
+func (t *CsvTable) structFields(typ reflect.Type) ([]*structField, error) {
+	fields, err := structFields(typ)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if _, ok := t.colMap[f.column]; !ok {
+			return nil, errors.Errorf("column %s of %s does not exist", f.column, typ)
+		}
+	}
+	return fields, nil
+}
+
+// SelectAll() scans all rows matching condition to dest, a pointer to
+// a slice of structs or struct pointers. See ScanStruct()
+func (t *CsvTable) SelectAll(condition interface{}, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("%T is not a pointer to a slice", dest)
+	}
+	sliceValue := dv.Elem()
+	elemType := sliceValue.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.Errorf("%T is not a pointer to a slice of structs", dest)
+	}
+
+	r, err := t.SelectRows(condition, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	res := reflect.MakeSlice(sliceValue.Type(), 0, 0)
+	for r.Next() {
+		ep := reflect.New(elemType)
+		if err := r.ScanStruct(ep.Interface()); err != nil {
+			return err
+		}
+		if isPtr {
+			res = reflect.Append(res, ep)
+		} else {
+			res = reflect.Append(res, ep.Elem())
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	sliceValue.Set(res)
+	return nil
+}
+
+/*
+ScanStruct() sets the values of the current row to the fields
+of the struct dest points to. Columns without fields are skipped
+and fields without columns are not changed.
+NULL sets nil to pointer fields and the zero value to other fields.
+*/
+func (r *CsvRows) ScanStruct(dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("%T is not a pointer to a struct", dest)
+	}
+	sv := dv.Elem()
+	fields, err := structFields(sv.Type())
+	if err != nil {
+		return err
+	}
+	fieldMap := make(map[string]*structField, len(fields))
+	for _, f := range fields {
+		fieldMap[f.column] = f
+	}
+
+	values := r.values()
+	colTypes := r.ColumnTypes()
+	for i, col := range r.Columns() {
+		f, ok := fieldMap[col]
+		if !ok {
+			continue
+		}
+		fv := sv.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr && !fv.Type().Implements(scannerType) {
+			if isNullOutput(r.nullValue, colTypes[i], values[i]) {
+				fv.Set(reflect.Zero(fv.Type()))
+				continue
+			}
+			fv.Set(reflect.New(fv.Type().Elem()))
+			fv = fv.Elem()
+		}
+		if err := r.scanValue(colTypes[i], values[i], fv.Addr().Interface()); err != nil {
+			return errors.Wrapf(err, "column %s", col)
+		}
+	}
+	return nil
+}
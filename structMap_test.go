@@ -0,0 +1,147 @@
+package csvdb
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testStructBase struct {
+	ID int64 `csv:"id"`
+}
+
+type testStructItem struct {
+	testStructBase
+	Name    string         `csv:"name"`
+	Price   *float64       `csv:"price"`
+	OnSale  bool           `csv:"on_sale"`
+	Added   time.Time      `csv:"added"`
+	Memo    sql.NullString `csv:"memo"`
+	Skipped string         `csv:"-"`
+	count   int
+}
+
+func TestStructMap(t *testing.T) {
+	rootDir, err := ensureTestDir("TestStructMap")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	g, err := db.CreateGroupFromStruct("items", &testStructItem{}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("columns", fmt.Sprint(g.columns, g.columnTypes),
+		"[id name price on_sale added memo] [int64 string float64 bool timestamp string]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	tb, err := g.CreateTable("items")
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	price := 1.5
+	added := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	if err := tb.InsertStruct(testStructItem{testStructBase{1}, "apple", &price, true, added,
+		sql.NullString{String: "red", Valid: true}, "x", 1}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.InsertStructs([]*testStructItem{
+		{testStructBase: testStructBase{2}, Name: "orange", Added: added},
+		{testStructBase: testStructBase{3}, Name: "lemon", Price: &price, Added: added},
+	}); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tb.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	items := []testStructItem{}
+	if err := tb.SelectAll(nil, &items); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("items", len(items), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	got := items[0]
+	if err := getGotExpErr("item 1", fmt.Sprintf("%v %v %v %v %v %v %v",
+		got.ID, got.Name, *got.Price, got.OnSale,
+		got.Added.Equal(added), got.Memo, got.Skipped == ""),
+		"1 apple 1.5 true true {red true} true"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("item 2", fmt.Sprintf("%v %q", items[1].Price == nil, items[1].Memo.String),
+		`true ""`); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	ptrs := []*testStructItem{}
+	if err := tb.SelectAll(Eq("price", 1.5), &ptrs); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	names := []string{}
+	for _, item := range ptrs {
+		names = append(names, item.Name)
+	}
+	if err := getGotExpErr("names", fmt.Sprint(names), "[apple lemon]"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// selected columns only set their fields
+	r, err := tb.SelectRows(nil, []string{"name"})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer r.Close()
+	if !r.Next() {
+		t.Errorf("no rows")
+		return
+	}
+	item := testStructItem{Skipped: "kept"}
+	if err := r.ScanStruct(&item); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("scanned", fmt.Sprintf("%v %v %v", item.Name, item.ID, item.Skipped), "apple 0 kept"); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	type unknown struct {
+		Color string `csv:"color"`
+	}
+	if err := tb.InsertStruct(unknown{"red"}); err == nil {
+		t.Errorf("a field without a column must fail")
+		return
+	}
+	if err := tb.SelectAll(nil, &names); err == nil {
+		t.Errorf("a slice of strings must fail")
+		return
+	}
+}
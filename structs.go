@@ -1,25 +1,55 @@
 package csvdb
 
 import (
-	"compress/gzip"
 	"encoding/csv"
+	"io"
 	"os"
+	"time"
 )
 
 type CsvDB struct {
-	Groups  map[string]*CsvTableGroup
-	baseDir string
+	Groups      map[string]*CsvTableGroup
+	baseDir     string
+	sortMemory  int64
+	lockTimeout time.Duration
+	parallelism int
 }
 
 type CsvTableGroup struct {
-	groupName  string
-	rootDir    string
-	dataDir    string
-	iniFile    string
-	tableDefs  map[string]*CsvTableDef
-	columns    []string
-	useGzip    bool
-	bufferSize int
+	groupName   string
+	rootDir     string
+	dataDir     string
+	iniFile     string
+	tableDefs   map[string]*CsvTableDef
+	columns     []string
+	columnTypes []string
+	codec       string
+	codecLevel  int
+	bufferSize  int
+	lockTimeout time.Duration
+	hasHeader   bool
+	format      CsvFormat
+	nullValue   string
+	primaryKey  []string
+	onDuplicate string
+	// partitionColumn is the timestamp column partitioning the tables by partitionBy
+	partitionColumn string
+	partitionBy     string
+	retention       int
+	// tables InsertRow() of the group buffers rows to
+	partTables map[string]*CsvTable
+	// tableFilter is the pattern of table names set by FilterTables()
+	tableFilter string
+	parallelism int
+	// chunkBytes is the least bytes a goroutine reads of a split file
+	chunkBytes int64
+}
+
+// CsvTx is a transaction returned by CsvDB.Begin()
+type CsvTx struct {
+	baseDir string
+	ops     []*txOp
+	done    bool
 }
 
 type CsvTableDef struct {
@@ -30,22 +60,35 @@ type CsvTableDef struct {
 
 type CsvTable struct {
 	*CsvTableDef
-	columns    []string
-	colMap     map[string]int
-	useGzip    bool
-	bufferSize int
-	buff       *insertBuff
+	columns     []string
+	columnTypes []string
+	colMap      map[string]int
+	codec       string
+	codecLevel  int
+	bufferSize  int
+	buff        *insertBuff
+	lockTimeout time.Duration
+	hasHeader   bool
+	format      CsvFormat
+	nullValue   string
+	primaryKey  []string
+	keyIdxs     []int
+	onDuplicate string
+	parallelism int
+	chunkBytes  int64
 }
 
 type CsvRows struct {
-	reader             *CsvReader
+	iter               rowIterator
 	selectedColIndexes []int
 	tableCols          []string
-	conditionCheckFunc func([]string) bool
-	orderbyBuff        orderBuffRows
-	orderbyBuffPos     int
-	orderbyExecuted    bool
-	orderbyErr         error
+	tableColTypes      []string
+	labels             []string
+	tmpDir             string
+	sortMemory         int64
+	nullValue          string
+	// tableName is the table of rows of iterators other than tableIterator
+	tableName string
 }
 
 type insertBuff struct {
@@ -57,7 +100,7 @@ type insertBuff struct {
 
 type CsvReader struct {
 	fr       *os.File
-	zr       *gzip.Reader
+	zr       io.Closer
 	reader   *csv.Reader
 	values   []string
 	err      error
@@ -66,18 +109,16 @@ type CsvReader struct {
 }
 
 type CsvWriter struct {
-	fw     *os.File
-	zw     *gzip.Writer
-	writer *csv.Writer
-	path   string
-	mode   string
+	fw      *os.File
+	zw      io.WriteCloser
+	writer  *csv.Writer
+	path    string
+	mode    string
+	tmpPath string
+	isNew   bool
 }
 
-type orderBuffRow struct {
-	v               []string
-	orderFieldTypes []string
-	orderFieldIdxs  []int
-	direction       int
+type orderBuffRows struct {
+	rows [][]string
+	cmp  *rowComparator
 }
-
-type orderBuffRows []orderBuffRow
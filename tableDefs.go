@@ -4,7 +4,22 @@ var (
 	csvTableInfoDef = `CREATE TABLE IF NOT EXISTS csvTableInfo (
 name TEXT,
 columns TEXT,
+columnTypes TEXT,
 useGzip NUMBER,
-bufferSize NUMBER
+codec TEXT,
+codecLevel NUMBER,
+bufferSize NUMBER,
+hasHeader NUMBER,
+delimiter TEXT,
+comment TEXT,
+lazyQuotes NUMBER,
+trimLeadingSpace NUMBER,
+useCRLF NUMBER,
+nullValue TEXT,
+primaryKey TEXT,
+onDuplicate TEXT,
+partitionColumn TEXT,
+partitionBy TEXT,
+retention NUMBER
 );`
 )
@@ -0,0 +1,244 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	txOpInsert = iota
+	txOpUpdate
+	txOpDelete
+)
+
+// txOp is an operation recorded in a transaction
+type txOp struct {
+	kind               int
+	table              *CsvTable
+	row                []string
+	conditionCheckFunc func([]string) bool
+	updates            map[int]string
+}
+
+// Begin() starts a transaction over tables of the CsvDB.
+// Operations are recorded in the transaction and applied to the tables
+// by Commit() all together, or not at all.
+// Reads do not see operations not committed yet.
+func (db *CsvDB) Begin() (*CsvTx, error) {
+	return newCsvTx(db.baseDir)
+}
+
+// newCsvTx() starts a transaction logged in the WAL under baseDir
+func newCsvTx(baseDir string) (*CsvTx, error) {
+	if err := ensureDir(filepath.Join(baseDir, cWalDir)); err != nil {
+		return nil, err
+	}
+	tx := new(CsvTx)
+	tx.baseDir = baseDir
+	tx.ops = make([]*txOp, 0)
+	return tx, nil
+}
+
+func (tx *CsvTx) record(op *txOp) error {
+	if tx.done {
+		return errors.New("the transaction is already committed or rolled back")
+	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// InsertRow() records an insert to t. See CsvTable.InsertRow()
+func (tx *CsvTx) InsertRow(t *CsvTable, columns []string, args ...interface{}) error {
+	row, err := t.newRow(columns, args...)
+	if err != nil {
+		return err
+	}
+	op := new(txOp)
+	op.kind = txOpInsert
+	op.table = t
+	op.row = row
+	return tx.record(op)
+}
+
+// Update() records an update of t. See CsvTable.Update()
+func (tx *CsvTx) Update(t *CsvTable, condition interface{},
+	updates map[string]interface{}) error {
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return err
+	}
+	op := new(txOp)
+	op.kind = txOpUpdate
+	op.table = t
+	op.conditionCheckFunc = conditionCheckFunc
+	op.updates = make(map[int]string, len(updates))
+	for col, updv := range updates {
+		idx, ok := t.colMap[col]
+		if !ok {
+			return errors.New(fmt.Sprintf("column %s does not exist", col))
+		}
+		s, err := t.typedString(idx, updv)
+		if err != nil {
+			return err
+		}
+		op.updates[idx] = s
+	}
+	return tx.record(op)
+}
+
+// Delete() records a delete from t. See CsvTable.Delete()
+func (tx *CsvTx) Delete(t *CsvTable, condition interface{}) error {
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return err
+	}
+	op := new(txOp)
+	op.kind = txOpDelete
+	op.table = t
+	op.conditionCheckFunc = conditionCheckFunc
+	return tx.record(op)
+}
+
+// count() returns the number of rows of t matching condition
+// as the transaction sees them, with the operations recorded on t applied
+func (tx *CsvTx) count(t *CsvTable, condition interface{}) (int, error) {
+	conditionCheckFunc, err := t.matcher(condition)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.readRows(nil)
+	if err != nil {
+		return 0, err
+	}
+	ops := make([]*txOp, 0, len(tx.ops))
+	for _, op := range tx.ops {
+		if op.table.path == t.path {
+			ops = append(ops, op)
+		}
+	}
+	n := 0
+	for _, v := range applyOps(rows, ops) {
+		if conditionCheckFunc == nil || conditionCheckFunc(v) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Rollback() discards the recorded operations
+func (tx *CsvTx) Rollback() error {
+	if tx.done {
+		return errors.New("the transaction is already committed or rolled back")
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+/*
+Commit() applies the recorded operations.
+
+The tables are locked exclusively and their new contents are written
+to the WAL under baseDir first. Once the WAL is complete, the changes
+are applied to the tables. If the process dies while applying,
+NewCsvDB() applies the rest.
+If Commit() returns an error before the WAL is complete, nothing is applied.
+*/
+func (tx *CsvTx) Commit() error {
+	if tx.done {
+		return errors.New("the transaction is already committed or rolled back")
+	}
+	tx.done = true
+	ops := tx.ops
+	tx.ops = nil
+	if len(ops) == 0 {
+		return nil
+	}
+
+	// operations by table in the order of the paths to lock them without deadlocks
+	tableOps := make(map[string][]*txOp)
+	paths := make([]string, 0)
+	for _, op := range ops {
+		if _, ok := tableOps[op.table.path]; !ok {
+			paths = append(paths, op.table.path)
+		}
+		tableOps[op.table.path] = append(tableOps[op.table.path], op)
+	}
+	sort.Strings(paths)
+
+	txid := fmt.Sprintf("%020d-%d", time.Now().UnixNano(), os.Getpid())
+	w := newWal(tx.baseDir, txid)
+	txLock, err := lockFile(w.dir, true, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		txLock.unlock()
+		removeLockFile(w.dir)
+	}()
+
+	for _, path := range paths {
+		lock, err := tableOps[path][0].table.lock(true)
+		if err != nil {
+			w.remove()
+			return err
+		}
+		defer lock.unlock()
+	}
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	for i, path := range paths {
+		if err := w.stage(i, tableOps[path][0].table, tableOps[path]); err != nil {
+			w.remove()
+			return err
+		}
+	}
+	if err := w.writeManifest(); err != nil {
+		w.remove()
+		return err
+	}
+	if err := w.apply(); err != nil {
+		// the WAL is kept for NewCsvDB() to apply
+		return err
+	}
+	return w.remove()
+}
+
+// applyOps() applies operations to rows of a table
+func applyOps(rows [][]string, ops []*txOp) [][]string {
+	for _, op := range ops {
+		switch op.kind {
+		case txOpInsert:
+			// updates must not change the recorded row
+			rows = append(rows, append([]string(nil), op.row...))
+		case txOpUpdate:
+			for _, v := range rows {
+				if op.conditionCheckFunc == nil || op.conditionCheckFunc(v) {
+					for idx, s := range op.updates {
+						v[idx] = s
+					}
+				}
+			}
+		case txOpDelete:
+			kept := make([][]string, 0, len(rows))
+			for _, v := range rows {
+				if op.conditionCheckFunc != nil && !op.conditionCheckFunc(v) {
+					kept = append(kept, v)
+				}
+			}
+			rows = kept
+		}
+	}
+	return rows
+}
+
+func (db *CsvDB) walDir() string {
+	return filepath.Join(db.baseDir, cWalDir)
+}
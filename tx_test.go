@@ -0,0 +1,222 @@
+package csvdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	rootDir, err := ensureTestDir("TestTx")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	orders, err := db.CreateTable("orders", []string{"id", "item"}, false, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	stock, err := db.CreateTypedTable("stock", []string{"item", "qty"},
+		[]string{CColTypeString, CColTypeInt64}, true, 10)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	for _, row := range [][]interface{}{{"apple", 10}, {"orange", 5}, {"grape", 0}} {
+		if err := stock.InsertRow(nil, row...); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+	if err := stock.Flush(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	record := func(tx *CsvTx, id int) error {
+		if err := tx.InsertRow(orders, nil, id, "apple"); err != nil {
+			return err
+		}
+		if err := tx.Update(stock, Eq("item", "apple"),
+			map[string]interface{}{"qty": 9}); err != nil {
+			return err
+		}
+		return tx.Delete(stock, Eq("qty", 0))
+	}
+	check := func(title string, nOrders, appleQty, nStock int) error {
+		if err := getGotExpErr(title+" orders", orders.Count(nil), nOrders); err != nil {
+			return err
+		}
+		qty := 0
+		if err := stock.Select1Row(Eq("item", "apple"), []string{"qty"}, &qty); err != nil {
+			return err
+		}
+		if err := getGotExpErr(title+" qty", qty, appleQty); err != nil {
+			return err
+		}
+		return getGotExpErr(title+" stock", stock.Count(nil), nStock)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := record(tx, 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Update(stock, nil, map[string]interface{}{"qty": "x"}); err == nil {
+		t.Errorf("a bad update must fail")
+		return
+	}
+	if err := check("before commit", 0, 10, 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Commit(); err == nil {
+		t.Errorf("commit after rollback must fail")
+		return
+	}
+	if err := check("rollback", 0, 10, 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := record(tx, 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := check("commit", 1, 9, 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	files, err := os.ReadDir(db.walDir())
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("WAL files after commit", len(files), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// crashes while committing
+	crash := func(txid string, writeManifest, apply bool) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := tx.InsertRow(orders, nil, 2, "orange"); err != nil {
+			return err
+		}
+		if err := tx.Update(stock, Eq("item", "orange"),
+			map[string]interface{}{"qty": 4}); err != nil {
+			return err
+		}
+		w := newWal(db.baseDir, txid)
+		if err := os.MkdirAll(w.dir, 0755); err != nil {
+			return err
+		}
+		if err := w.stage(0, orders, tx.ops[:1]); err != nil {
+			return err
+		}
+		if err := w.stage(1, stock, tx.ops[1:]); err != nil {
+			return err
+		}
+		if writeManifest {
+			if err := w.writeManifest(); err != nil {
+				return err
+			}
+		}
+		if apply {
+			if err := w.apply(); err != nil {
+				return err
+			}
+		}
+		_, err = NewCsvDB(rootDir)
+		return err
+	}
+	orangeQty := func() int {
+		qty := 0
+		stock.Select1Row(Eq("item", "orange"), []string{"qty"}, &qty)
+		return qty
+	}
+
+	if err := crash("00000000000000000001-1", false, false); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("not committed orders", orders.Count(nil), 1); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("not committed qty", orangeQty(), 5); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	if err := crash("00000000000000000002-1", true, false); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("replayed orders", orders.Count(nil), 2); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("replayed qty", orangeQty(), 4); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	// applied once before the crash, then replayed
+	if err := crash("00000000000000000003-1", true, true); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("applied twice orders", orders.Count(nil), 3); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	files, err = os.ReadDir(db.walDir())
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if err := getGotExpErr("WAL files after recovery", len(files), 0); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if files, _ := filepath.Glob(filepath.Join(rootDir, "stock", ".*")); len(files) > 0 {
+		t.Errorf("files are left %v", files)
+		return
+	}
+}
@@ -0,0 +1,335 @@
+package csvdb
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+wal is the write-ahead log of 1 transaction.
+
+The new rows of each table are staged in files under dir.
+The manifest lists what to do with the staged files.
+Writing the manifest commits the transaction; applying it is idempotent
+so that NewCsvDB() can apply it again after a crash.
+
+manifest columns:
+op, table path, staged path, size of the table before the transaction
+*/
+type wal struct {
+	baseDir  string
+	dir      string
+	manifest string
+	entries  []*walEntry
+}
+
+type walEntry struct {
+	op      string
+	target  string
+	staged  string
+	orgSize int64
+}
+
+func newWal(baseDir, txid string) *wal {
+	w := new(wal)
+	w.baseDir = baseDir
+	w.dir = filepath.Join(baseDir, cWalDir, txid)
+	w.manifest = fmt.Sprintf("%s.%s", w.dir, cWalExt)
+	w.entries = make([]*walEntry, 0)
+	return w
+}
+
+// stage() writes rows to append to or to replace the table at path
+func (w *wal) stage(i int, t *CsvTable, ops []*txOp) error {
+	path := t.path
+	e := new(walEntry)
+	e.target = path
+	e.staged = w.stagedPath(i, path)
+
+	// keys are checked against all rows
+	e.op = cWalAppend
+	if len(t.keyIdxs) > 0 {
+		e.op = cWalReplace
+	}
+	for _, op := range ops {
+		if op.kind != txOpInsert {
+			e.op = cWalReplace
+		}
+	}
+
+	rows := make([][]string, 0)
+	if e.op == cWalAppend {
+		if st, err := os.Stat(path); err == nil {
+			e.orgSize = st.Size()
+		} else if !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	} else if pathExist(path) {
+		reader, err := t.openR(false)
+		if err != nil {
+			return err
+		}
+		for reader.next() {
+			rows = append(rows, reader.values)
+		}
+		err = reader.lastErr()
+		reader.close()
+		if err != nil {
+			return err
+		}
+	}
+	rows = applyOps(rows, ops)
+	if len(t.keyIdxs) > 0 {
+		var err error
+		if rows, err = t.uniqueRows(rows); err != nil {
+			return err
+		}
+	}
+	return w.stageRows(e, t, rows)
+}
+
+// stagedPath() returns the path the i-th file to replace target is staged at
+func (w *wal) stagedPath(i int, target string) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%d-%s", i, filepath.Base(target)))
+}
+
+// stageRows() writes rows of the table t to the staged file of e
+func (w *wal) stageRows(e *walEntry, t *CsvTable, rows [][]string) error {
+	codec, err := getCodec(t.codec)
+	if err != nil {
+		return err
+	}
+	writer, err := openCsvWriter(e.staged, CWriteModeWrite, codec, t.codecLevel)
+	if err != nil {
+		return err
+	}
+	defer writer.close()
+	writer.setFormat(t.format)
+	// an appended table file which is empty needs the header too
+	if t.hasHeader && (e.op == cWalReplace || e.orgSize == 0) {
+		if err := writer.write(t.columns); err != nil {
+			return err
+		}
+	}
+	for _, row := range rows {
+		if err := writer.write(row); err != nil {
+			return err
+		}
+	}
+	if err := writer.commit(); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, e)
+	return nil
+}
+
+// relPath() returns path relative to baseDir so that the WAL
+// does not depend on the working directory
+func (w *wal) relPath(path string) (string, error) {
+	rel, err := filepath.Rel(w.baseDir, path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return rel, nil
+}
+
+// writeManifest() commits the transaction
+func (w *wal) writeManifest() error {
+	records := make([][]string, len(w.entries))
+	for i, e := range w.entries {
+		target, err := w.relPath(e.target)
+		if err != nil {
+			return err
+		}
+		staged, err := w.relPath(e.staged)
+		if err != nil {
+			return err
+		}
+		records[i] = []string{e.op, target, staged, strconv.FormatInt(e.orgSize, 10)}
+	}
+	return writeFileAtomic(w.manifest, 0644, func(wr io.Writer) error {
+		cw := csv.NewWriter(wr)
+		if err := cw.WriteAll(records); err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	})
+}
+
+func (w *wal) loadManifest() error {
+	reader, err := newCsvReader(w.manifest)
+	if err != nil {
+		return err
+	}
+	defer reader.close()
+	for reader.next() {
+		v := reader.values
+		if len(v) != 4 {
+			return errors.Errorf("broken WAL %s", w.manifest)
+		}
+		e := new(walEntry)
+		e.op = v[0]
+		e.target = filepath.Join(w.baseDir, v[1])
+		e.staged = filepath.Join(w.baseDir, v[2])
+		e.orgSize, err = strconv.ParseInt(v[3], 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "broken WAL %s", w.manifest)
+		}
+		w.entries = append(w.entries, e)
+	}
+	return reader.lastErr()
+}
+
+// apply() applies the staged files to the tables.
+// The caller must hold the exclusive locks of the tables
+func (w *wal) apply() error {
+	for _, e := range w.entries {
+		switch e.op {
+		case cWalReplace:
+			// the staged file is gone once it has been applied
+			if !pathExist(e.staged) {
+				continue
+			}
+			if err := os.Rename(e.staged, e.target); err != nil {
+				return errors.WithStack(err)
+			}
+			if err := syncDir(filepath.Dir(e.target)); err != nil {
+				return err
+			}
+		case cWalAppend:
+			if err := appendFile(e.target, e.staged, e.orgSize); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unknown WAL operation %s in %s", e.op, w.manifest)
+		}
+	}
+	return nil
+}
+
+// remove() removes the manifest first so that staged files left
+// by a crash are not applied
+func (w *wal) remove() error {
+	if err := os.Remove(w.manifest); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	if err := os.RemoveAll(w.dir); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// appendFile() appends staged to target as it was orgSize bytes long.
+// Bytes appended by an earlier try are overwritten.
+func appendFile(target, staged string, orgSize int64) error {
+	src, err := os.Open(staged)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if st.Size() < orgSize {
+		return errors.Errorf("%s is shorter than before the transaction", target)
+	}
+	if err := f.Truncate(orgSize); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := f.Seek(orgSize, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(f.Sync())
+}
+
+// recoverWal() applies transactions committed but not applied completely
+// and removes files of transactions not committed
+func (db *CsvDB) recoverWal() error {
+	walDir := db.walDir()
+	if !pathExist(walDir) {
+		return nil
+	}
+	files, err := os.ReadDir(walDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	txids := make([]string, 0)
+	found := make(map[string]bool)
+	for _, f := range files {
+		txid := f.Name()
+		if !f.IsDir() {
+			if !strings.HasSuffix(txid, "."+cWalExt) {
+				continue
+			}
+			txid = strings.TrimSuffix(txid, "."+cWalExt)
+		}
+		if !found[txid] {
+			found[txid] = true
+			txids = append(txids, txid)
+		}
+	}
+	sort.Strings(txids)
+
+	for _, txid := range txids {
+		w := newWal(db.baseDir, txid)
+		txLock, err := lockFile(w.dir, true, CLockNoWait)
+		if errors.Cause(err) == ErrLocked {
+			// being committed by another process
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = db.recoverTx(w)
+		txLock.unlock()
+		if err != nil {
+			return err
+		}
+		if err := removeLockFile(w.dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *CsvDB) recoverTx(w *wal) error {
+	if pathExist(w.manifest) {
+		if err := w.loadManifest(); err != nil {
+			return err
+		}
+		paths := make([]string, len(w.entries))
+		for i, e := range w.entries {
+			paths[i] = e.target
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			lock, err := lockFile(path, true, db.lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer lock.unlock()
+		}
+		if err := w.apply(); err != nil {
+			return err
+		}
+	}
+	return w.remove()
+}
@@ -0,0 +1,471 @@
+package csvdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Zone maps are statistics of blocks of rows in a sidecar file next to
+the table file: the byte range, the row count and, for each column,
+the count of values other than NULL and their min and max.
+Scans by *Cond conditions skip blocks which cannot have matching rows.
+
+Each flush appending rows adds a block. Gzip tables get a gzip stream
+by flush, so blocks of gzip tables are the streams.
+Zone maps built from existing plain files have blocks of cZoneBlockRows rows.
+Like indexes, zone maps made stale by other writes are rebuilt when used.
+*/
+
+// zoneBlock is the statistics of a block of rows at offset to end
+type zoneBlock struct {
+	offset   int64
+	end      int64
+	rows     int
+	nonNulls []int
+	mins     []string
+	maxs     []string
+}
+
+type zoneMap struct {
+	ncols  int
+	size   int64
+	mtime  int64
+	blocks []*zoneBlock
+}
+
+// CreateZoneMap() creates the zone map of the table in a sidecar file
+// and builds it from the table file.
+// Tables compressed by codecs other than gzip cannot have zone maps.
+func (t *CsvTable) CreateZoneMap() error {
+	if t.codec != CCodecNone && t.codec != CCodecGzip {
+		return errors.Errorf("tables of codec %s cannot have zone maps", t.codec)
+	}
+	lock, err := t.lock(false)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	_, err = t.buildZoneMap()
+	return err
+}
+
+// DropZoneMap() removes the zone map of the table
+func (t *CsvTable) DropZoneMap() error {
+	if !t.hasZoneMap() {
+		return nil
+	}
+	return errors.WithStack(os.Remove(t.zoneMapPath()))
+}
+
+func (t *CsvTable) zoneMapPath() string {
+	return t.path + ".zonemap"
+}
+
+func (t *CsvTable) hasZoneMap() bool {
+	return pathExist(t.zoneMapPath())
+}
+
+func (t *CsvTable) newZoneBlock(offset int64) *zoneBlock {
+	b := new(zoneBlock)
+	b.offset = offset
+	b.nonNulls = make([]int, len(t.columns))
+	b.mins = make([]string, len(t.columns))
+	b.maxs = make([]string, len(t.columns))
+	return b
+}
+
+// add() adds a row to the statistics of the block
+func (b *zoneBlock) add(t *CsvTable, record []string) {
+	b.rows++
+	for i, colType := range t.columnTypes {
+		if i >= len(record) || isNullOutput(t.nullValue, colType, record[i]) {
+			continue
+		}
+		v := record[i]
+		b.nonNulls[i]++
+		// untyped values have no order to skip blocks by
+		if colType == cColTypeUntyped {
+			continue
+		}
+		if b.nonNulls[i] == 1 || compareTyped(colType, v, b.mins[i]) < 0 {
+			b.mins[i] = v
+		}
+		if b.nonNulls[i] == 1 || compareTyped(colType, v, b.maxs[i]) > 0 {
+			b.maxs[i] = v
+		}
+	}
+}
+
+// buildZoneMap() builds the zone map from the whole table file.
+// The caller must hold the lock
+func (t *CsvTable) buildZoneMap() (*zoneMap, error) {
+	zm := new(zoneMap)
+	zm.ncols = len(t.columns)
+	zm.blocks = make([]*zoneBlock, 0)
+	st, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return zm, zm.save(t.zoneMapPath())
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if zm.blocks, err = t.scanZoneBlocks(0, st.Size(), cZoneBlockRows); err != nil {
+		return nil, err
+	}
+	zm.size = st.Size()
+	zm.mtime = st.ModTime().UnixNano()
+	return zm, zm.save(t.zoneMapPath())
+}
+
+/*
+scanZoneBlocks() returns blocks of rows from the byte offset start
+to size of the table file. Rows of plain files are split
+into blocks of blockRows rows, or not split if blockRows is 0.
+*/
+func (t *CsvTable) scanZoneBlocks(start, size int64, blockRows int) ([]*zoneBlock, error) {
+	blocks := make([]*zoneBlock, 0)
+	var b *zoneBlock
+	err := t.scanPositions(start, func(record []string, offset int64, row int) {
+		// the header row is the first row of plain files and of the first gzip stream
+		isHeader := t.hasHeader && offset == 0 && row == 0
+		if isHeader && t.codec == CCodecNone {
+			return
+		}
+		switch {
+		case b == nil:
+			b = t.newZoneBlock(offset)
+			blocks = append(blocks, b)
+		case t.codec == CCodecNone && blockRows > 0 && b.rows >= blockRows:
+			b.end = offset
+			b = t.newZoneBlock(offset)
+			blocks = append(blocks, b)
+		case t.codec != CCodecNone && offset != b.offset:
+			b.end = offset
+			b = t.newZoneBlock(offset)
+			blocks = append(blocks, b)
+		}
+		if !isHeader {
+			b.add(t, record)
+		}
+	})
+	if b != nil {
+		b.end = size
+	}
+	return blocks, err
+}
+
+// getZoneMap() returns the zone map, rebuilding it if it is stale.
+// The caller must hold the lock
+func (t *CsvTable) getZoneMap() (*zoneMap, error) {
+	zm, err := t.loadZoneMap()
+	if err != nil {
+		return nil, err
+	}
+	if zm != nil && zm.isValid(t.path) {
+		return zm, nil
+	}
+	return t.buildZoneMap()
+}
+
+// loadZoneMap() reads the zone map. nil if it is broken
+func (t *CsvTable) loadZoneMap() (*zoneMap, error) {
+	f, err := os.Open(t.zoneMapPath())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(bufio.NewReader(f))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil || len(header) != 3 || header[2] != strconv.Itoa(len(t.columns)) {
+		return nil, nil
+	}
+	zm := new(zoneMap)
+	zm.ncols = len(t.columns)
+	zm.blocks = make([]*zoneBlock, 0)
+	if zm.size, err = strconv.ParseInt(header[0], 10, 64); err != nil {
+		return nil, nil
+	}
+	if zm.mtime, err = strconv.ParseInt(header[1], 10, 64); err != nil {
+		return nil, nil
+	}
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(rec) != 3+3*len(t.columns) {
+			return nil, nil
+		}
+		b := t.newZoneBlock(0)
+		if b.offset, err = strconv.ParseInt(rec[0], 10, 64); err != nil {
+			return nil, nil
+		}
+		if b.end, err = strconv.ParseInt(rec[1], 10, 64); err != nil {
+			return nil, nil
+		}
+		if b.rows, err = strconv.Atoi(rec[2]); err != nil {
+			return nil, nil
+		}
+		for i := range t.columns {
+			if b.nonNulls[i], err = strconv.Atoi(rec[3+i*3]); err != nil {
+				return nil, nil
+			}
+			b.mins[i] = rec[4+i*3]
+			b.maxs[i] = rec[5+i*3]
+		}
+		zm.blocks = append(zm.blocks, b)
+	}
+	return zm, nil
+}
+
+// isValid() checks the zone map is built from the current table file at path
+func (zm *zoneMap) isValid(path string) bool {
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return zm.size == 0 && len(zm.blocks) == 0
+	}
+	return err == nil && st.Size() == zm.size && st.ModTime().UnixNano() == zm.mtime
+}
+
+func (zm *zoneMap) save(path string) error {
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{strconv.FormatInt(zm.size, 10),
+			strconv.FormatInt(zm.mtime, 10), strconv.Itoa(zm.ncols)})
+		for _, b := range zm.blocks {
+			rec := []string{strconv.FormatInt(b.offset, 10),
+				strconv.FormatInt(b.end, 10), strconv.Itoa(b.rows)}
+			for i := range b.mins {
+				rec = append(rec, strconv.Itoa(b.nonNulls[i]), b.mins[i], b.maxs[i])
+			}
+			writer.Write(rec)
+		}
+		writer.Flush()
+		return errors.WithStack(writer.Error())
+	})
+}
+
+// updateZoneMap() updates the zone map after a write in wmode.
+// orgSt is the table file before the write. Appended rows are added
+// as a block if the zone map was valid before the write, or it is rebuilt.
+// Errors are ignored as a stale zone map is rebuilt when it is used.
+// The caller must hold the exclusive lock
+func (t *CsvTable) updateZoneMap(wmode string, orgSt os.FileInfo) {
+	if !t.hasZoneMap() {
+		return
+	}
+	if wmode == CWriteModeAppend && orgSt != nil && orgSt.Size() > 0 {
+		if zm, err := t.loadZoneMap(); err == nil && zm != nil &&
+			zm.size == orgSt.Size() && zm.mtime == orgSt.ModTime().UnixNano() {
+			if st, err := os.Stat(t.path); err == nil {
+				blocks, err := t.scanZoneBlocks(zm.size, st.Size(), 0)
+				if err == nil {
+					zm.blocks = append(zm.blocks, blocks...)
+					zm.size = st.Size()
+					zm.mtime = st.ModTime().UnixNano()
+					if zm.save(t.zoneMapPath()) == nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	t.buildZoneMap()
+}
+
+// mayMatch() returns false if no rows of the block can match c
+func (b *zoneBlock) mayMatch(t *CsvTable, c *Cond) bool {
+	if c == nil {
+		return true
+	}
+	switch c.op {
+	case cCondAnd:
+		for _, child := range c.children {
+			if !b.mayMatch(t, child) {
+				return false
+			}
+		}
+		return true
+	case cCondOr:
+		for _, child := range c.children {
+			if child == nil || b.mayMatch(t, child) {
+				return true
+			}
+		}
+		return len(c.children) == 0
+	case cCondNot:
+		return true
+	}
+	i, ok := t.colMap[c.column]
+	if !ok {
+		return true
+	}
+	if c.op == cCondIsNull {
+		return b.rows > b.nonNulls[i]
+	}
+	// other conditions do not match NULL
+	if b.nonNulls[i] == 0 {
+		return false
+	}
+	colType := t.columnTypes[i]
+	if colType == cColTypeUntyped {
+		return true
+	}
+	values := make([]string, len(c.values))
+	for j, v := range c.values {
+		s, err := typedString(colType, v)
+		if err != nil {
+			return true
+		}
+		values[j] = s
+	}
+	inRange := func(v string) bool {
+		return compareTyped(colType, b.mins[i], v) <= 0 &&
+			compareTyped(colType, b.maxs[i], v) >= 0
+	}
+	switch c.op {
+	case cCondEq:
+		return len(values) != 1 || inRange(values[0])
+	case cCondIn:
+		for _, v := range values {
+			if inRange(v) {
+				return true
+			}
+		}
+		return false
+	case cCondLt:
+		return len(values) != 1 || compareTyped(colType, b.mins[i], values[0]) < 0
+	case cCondLe:
+		return len(values) != 1 || compareTyped(colType, b.mins[i], values[0]) <= 0
+	case cCondGt:
+		return len(values) != 1 || compareTyped(colType, b.maxs[i], values[0]) > 0
+	case cCondGe:
+		return len(values) != 1 || compareTyped(colType, b.maxs[i], values[0]) >= 0
+	case cCondBetween:
+		return len(values) != 2 ||
+			(compareTyped(colType, b.maxs[i], values[0]) >= 0 &&
+				compareTyped(colType, b.mins[i], values[1]) <= 0)
+	}
+	return true
+}
+
+// zoneIter reads rows of blocks which may match a condition.
+// The table file is opened under a shared lock which is released before
+// rows are read. See newLockedCsvReader()
+type zoneIter struct {
+	t      *CsvTable
+	f      *os.File
+	blocks []*zoneBlock
+	pos    int
+	reader *csv.Reader
+	zr     *gzip.Reader
+	values []string
+	err    error
+}
+
+// newZoneIter() returns an iterator over rows of blocks which may match c
+func (t *CsvTable) newZoneIter(c *Cond) (*zoneIter, error) {
+	lock, err := t.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	zm, err := t.getZoneMap()
+	if err != nil {
+		return nil, err
+	}
+	it := new(zoneIter)
+	it.t = t
+	it.blocks = make([]*zoneBlock, 0, len(zm.blocks))
+	for _, b := range zm.blocks {
+		if b.mayMatch(t, c) {
+			it.blocks = append(it.blocks, b)
+		}
+	}
+	if len(it.blocks) > 0 {
+		if it.f, err = os.Open(t.path); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return it, nil
+}
+
+func (it *zoneIter) next() bool {
+	for it.err == nil {
+		if it.reader != nil {
+			values, err := it.reader.Read()
+			if err == nil {
+				it.values = values
+				return true
+			}
+			if err != io.EOF {
+				it.err = errors.WithStack(err)
+				return false
+			}
+			it.reader = nil
+		}
+		if it.pos >= len(it.blocks) {
+			return false
+		}
+		it.err = it.openBlock(it.blocks[it.pos])
+		it.pos++
+	}
+	return false
+}
+
+// openBlock() starts reading rows of the block b
+func (it *zoneIter) openBlock(b *zoneBlock) error {
+	if _, err := it.f.Seek(b.offset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	src := io.LimitReader(it.f, b.end-b.offset)
+	if it.t.codec == CCodecGzip {
+		br := bufio.NewReader(src)
+		var err error
+		if it.zr == nil {
+			it.zr, err = gzip.NewReader(br)
+		} else {
+			err = it.zr.Reset(br)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		it.zr.Multistream(false)
+		src = it.zr
+	}
+	it.reader = csv.NewReader(src)
+	it.t.format.applyReader(it.reader)
+	if it.t.codec == CCodecGzip && b.offset == 0 && it.t.hasHeader {
+		if _, err := it.reader.Read(); err != nil && err != io.EOF {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (it *zoneIter) current() []string {
+	return it.values
+}
+
+func (it *zoneIter) lastErr() error {
+	return it.err
+}
+
+func (it *zoneIter) close() {
+	if it.zr != nil {
+		it.zr.Close()
+		it.zr = nil
+	}
+	if it.f != nil {
+		it.f.Close()
+		it.f = nil
+	}
+}
@@ -0,0 +1,167 @@
+package csvdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestZoneMap(t *testing.T) {
+	rootDir, err := ensureTestDir("TestZoneMap")
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	db, err := NewCsvDB(rootDir)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	err = db.DropAll()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	os.RemoveAll(db.walDir())
+
+	day := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, useGzip := range []bool{false, true} {
+		groupName := fmt.Sprintf("events%v", useGzip)
+		g, err := db.CreateTypedGroup(groupName, []string{"ts", "kind", "memo"},
+			[]string{CColTypeTimestamp, CColTypeString, ""}, useGzip, 100)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := g.SetHeader(true); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		tb, err := g.CreateTable(groupName)
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		// a day by flush
+		insertDay := func(d int) error {
+			for h := 0; h < 24; h++ {
+				kind := "info"
+				if h == 12 {
+					kind = "error"
+				}
+				if err := tb.InsertRow(nil, day.AddDate(0, 0, d).Add(time.Duration(h)*time.Hour),
+					kind, fmt.Sprintf("day%d", d)); err != nil {
+					return err
+				}
+			}
+			return tb.Flush()
+		}
+		if err := insertDay(0); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.CreateZoneMap(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		for d := 1; d < 5; d++ {
+			if err := insertDay(d); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+
+		title := fmt.Sprintf("gzip=%v", useGzip)
+		zm, err := tb.loadZoneMap()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" valid", zm.isValid(tb.path), true); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" blocks", len(zm.blocks), 5); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		for _, c := range []struct {
+			cond   *Cond
+			blocks int
+			count  int
+		}{
+			{Between("ts", day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)), 2, 25},
+			{Ge("ts", day.AddDate(0, 0, 4).Add(23*time.Hour)), 1, 1},
+			{Lt("ts", day), 0, 0},
+			{And(Eq("kind", "error"), Lt("ts", day.AddDate(0, 0, 1))), 1, 1},
+			{Or(Eq("kind", "debug"), Gt("ts", day.AddDate(0, 0, 3))), 2, 47},
+			{Eq("memo", "day3"), 5, 24},
+			{IsNull("kind"), 0, 0},
+		} {
+			it, err := tb.newZoneIter(c.cond)
+			if err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+			nblocks := len(it.blocks)
+			it.close()
+			if err := getGotExpErr(fmt.Sprintf("%s blocks of %d", title, c.count),
+				nblocks, c.blocks); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+			if err := getGotExpErr(fmt.Sprintf("%s count of %d", title, c.count),
+				tb.Count(c.cond), c.count); err != nil {
+				t.Errorf("%v", err)
+				return
+			}
+		}
+
+		// rewrites rebuild the zone map
+		if err := tb.Delete(Lt("ts", day.AddDate(0, 0, 4))); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" deleted", tb.Count(Ge("ts", day)), 24); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tx.InsertRow(tb, nil, day.AddDate(0, 0, 9), "info", "day9"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" committed", tb.Count(Gt("ts", day.AddDate(0, 0, 5))), 1); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		var memo string
+		if err := tb.Max(Gt("ts", day.AddDate(0, 0, 4)), "memo", &memo); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" max", memo, "day9"); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := tb.Drop(); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		if err := getGotExpErr(title+" zone map after drop", tb.hasZoneMap(), false); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+	}
+}